@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/tommy-cxcpwz/gossm/internal"
+)
+
+var (
+	aliasCommand = &cobra.Command{
+		Use:   "alias",
+		Short: "Show named target aliases and groups from the gossm config",
+		Long: `Show named target aliases and groups from the gossm config.
+
+Aliases and groups are defined in ~/.gossm/config.yaml:
+
+  aliases:
+    db-bastion: i-0abc123def456789
+    web: "tag:Role=web,tag:Env=prod"
+  groups:
+    backend: [db-bastion, "tag:Role=api"]
+
+An expression is a comma-separated list of instance IDs, alias names,
+tag:Key=Value selectors, field=value filters, asg:Name Auto Scaling groups and
+nodegroup:cluster/name EKS node groups. Selectors in one expression must all
+match; asg: and nodegroup: resolve to the InService members only.
+Use the name directly (gossm start db-bastion) or with @ for every match
+(gossm exec -t @web uptime). Names are case-insensitive.`,
+	}
+
+	aliasListCommand = &cobra.Command{
+		Use:   "list",
+		Short: "List aliases and groups with the instances they currently resolve to",
+		Long:  "List aliases and groups with the instances they currently resolve to",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			ssmClient := ssm.NewFromConfig(*_credential.awsConfig)
+			ec2Client := ec2.NewFromConfig(*_credential.awsConfig)
+			resolver := newTargetResolver(ssmClient, ec2Client, internal.FindOptions{})
+
+			if len(resolver.Aliases) == 0 && len(resolver.Groups) == 0 {
+				color.Yellow("No aliases or groups defined in %s", viperConfigFile())
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, color.CyanString("NAME\tEXPRESSION\tRESOLVES TO"))
+			fmt.Fprintln(w, color.CyanString("----\t----------\t-----------"))
+
+			for _, name := range internal.SortedMapKeys(resolver.Aliases) {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", name, resolver.Aliases[name], describeResolution(ctx, resolver, name))
+			}
+			for _, name := range internal.SortedMapKeys(resolver.Groups) {
+				ref := "@" + name
+				fmt.Fprintf(w, "%s\t%s\t%s\n", ref, strings.Join(resolver.Groups[name], ", "), describeResolution(ctx, resolver, ref))
+			}
+			return w.Flush()
+		},
+	}
+)
+
+// newTargetResolver returns a resolver for the aliases and groups in the gossm config.
+func newTargetResolver(ssmClient internal.SSMDescribeInstanceInfoAPI, ec2Client internal.EC2DescribeInstancesAPI, opts internal.FindOptions) *internal.TargetResolver {
+	resolver := internal.NewTargetResolver(
+		viper.GetStringMapString("aliases"),
+		viper.GetStringMapStringSlice("groups"),
+		ssmClient, ec2Client,
+	)
+	resolver.FindOptions = opts
+	resolver.AutoScaling = autoscaling.NewFromConfig(*_credential.awsConfig)
+	return resolver
+}
+
+// addGroupFlags registers the flags for targeting Auto Scaling group and EKS node group members.
+func addGroupFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("asg", nil, "[optional] target the InService members of an Auto Scaling group (repeatable)")
+	cmd.Flags().StringArray("nodegroup", nil, "[optional] target the InService members of an EKS node group, as cluster/name (repeatable)")
+}
+
+// groupRefs returns the --asg and --nodegroup flags as asg: and nodegroup: target expressions.
+func groupRefs(cmd *cobra.Command) []string {
+	asgs, _ := cmd.Flags().GetStringArray("asg")
+	nodegroups, _ := cmd.Flags().GetStringArray("nodegroup")
+	refs := make([]string, 0, len(asgs)+len(nodegroups))
+	for _, name := range asgs {
+		refs = append(refs, "asg:"+strings.TrimSpace(name))
+	}
+	for _, ref := range nodegroups {
+		refs = append(refs, "nodegroup:"+strings.TrimSpace(ref))
+	}
+	return refs
+}
+
+// describeResolution resolves ref and formats the resulting targets for display.
+func describeResolution(ctx context.Context, resolver *internal.TargetResolver, ref string) string {
+	targets, err := resolver.Resolve(ctx, []string{ref})
+	if err != nil {
+		return color.RedString("[err] %v", err)
+	}
+	names := make([]string, 0, len(targets))
+	for _, t := range targets {
+		if t.TagName != "" {
+			names = append(names, fmt.Sprintf("%s (%s)", t.Name, t.TagName))
+		} else {
+			names = append(names, t.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// viperConfigFile returns the config file in use, or the default location.
+func viperConfigFile() string {
+	if f := viper.ConfigFileUsed(); f != "" {
+		return f
+	}
+	return fmt.Sprintf("~/.gossm/%s", _configFileName)
+}
+
+func init() {
+	aliasCommand.AddCommand(aliasListCommand)
+	rootCmd.AddCommand(aliasCommand)
+}
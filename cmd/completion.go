@@ -0,0 +1,310 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/tommy-cxcpwz/gossm/internal"
+)
+
+const (
+	// completionLookupTimeout bounds the FindInstances call made when the instance cache is stale.
+	completionLookupTimeout = 3 * time.Second
+	_cacheDirName           = "cache"
+)
+
+var (
+	completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+	completionCommand = &cobra.Command{
+		Use:   "completion [bash|zsh|fish|powershell]",
+		Short: "Generate the autocompletion script for the specified shell",
+		Long: `Generate the autocompletion script for the specified shell.
+
+To load completions in the current shell:
+  bash:  source <(gossm completion bash)
+  zsh:   source <(gossm completion zsh)
+  fish:  gossm completion fish | source
+
+Use 'gossm completion install' to install them permanently.
+
+Targets complete from instances cached by the last 'gossm list' or completion
+(kept for 5 minutes), or from a quick instance lookup.`,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: completionShells,
+		// completion scripts don't need AWS credentials
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return writeCompletionScript(os.Stdout, args[0])
+		},
+	}
+
+	completionInstallCommand = &cobra.Command{
+		Use:   "install [bash|zsh|fish]",
+		Short: "Install the autocompletion script for your shell",
+		Long: `Install the autocompletion script for your shell.
+
+The shell defaults to the one in $SHELL. Scripts are written to:
+  bash:  ~/.local/share/bash-completion/completions/gossm (needs bash-completion 2)
+  zsh:   ~/.zfunc/_gossm (add ~/.zfunc to fpath in ~/.zshrc)
+  fish:  ~/.config/fish/completions/gossm.fish`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"bash", "zsh", "fish"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			shell := filepath.Base(os.Getenv("SHELL"))
+			if len(args) > 0 {
+				shell = args[0]
+			}
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			path, err := completionInstallPath(shell, home, os.Getenv)
+			if err != nil {
+				return err
+			}
+
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			f, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			if err := writeCompletionScript(f, shell); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+
+			color.Green("[completion] installed %s completion to %s", shell, path)
+			switch shell {
+			case "zsh":
+				fmt.Println("Make sure ~/.zshrc contains, before compinit:")
+				fmt.Println("  fpath=(~/.zfunc $fpath)")
+				fmt.Println("  autoload -Uz compinit && compinit")
+			case "bash":
+				fmt.Println("Completions load in new shells when bash-completion 2 is installed.")
+			}
+			fmt.Println("Restart your shell to enable them.")
+			return nil
+		},
+	}
+)
+
+// writeCompletionScript writes the completion script for shell to w.
+func writeCompletionScript(w io.Writer, shell string) error {
+	switch shell {
+	case "bash":
+		return rootCmd.GenBashCompletionV2(w, true)
+	case "zsh":
+		return rootCmd.GenZshCompletion(w)
+	case "fish":
+		return rootCmd.GenFishCompletion(w, true)
+	case "powershell":
+		return rootCmd.GenPowerShellCompletionWithDesc(w)
+	default:
+		return fmt.Errorf("unsupported shell %q (must be one of %s)", shell, strings.Join(completionShells, ", "))
+	}
+}
+
+// completionInstallPath returns where the completion script for shell is installed,
+// honouring XDG_DATA_HOME and XDG_CONFIG_HOME.
+func completionInstallPath(shell, home string, getenv func(string) string) (string, error) {
+	dataHome := getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	configHome := getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(home, ".config")
+	}
+
+	switch shell {
+	case "bash":
+		return filepath.Join(dataHome, "bash-completion", "completions", "gossm"), nil
+	case "zsh":
+		return filepath.Join(home, ".zfunc", "_gossm"), nil
+	case "fish":
+		return filepath.Join(configHome, "fish", "completions", "gossm.fish"), nil
+	default:
+		return "", fmt.Errorf("cannot install completion for shell %q (must be bash, zsh or fish)", shell)
+	}
+}
+
+// completeTargetArg completes the single positional target of start and doctor.
+func completeTargetArg(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeTargets(cmd, args, toComplete)
+}
+
+// completeTargets completes target expressions: instance IDs described by their Name tag,
+// aliases, @groups and tag:Name= values. Terms after a comma are completed too.
+func completeTargets(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	if home, err := getGossmHomePath(); err == nil {
+		_ = loadGossmConfig(filepath.Join(home, _configFileName))
+	}
+	return targetCompletions(completionInstances(cmd.Context()), viper.GetStringMapString("aliases"),
+		viper.GetStringMapStringSlice("groups"), toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// targetCompletions returns the candidates for the last comma-separated term of toComplete.
+func targetCompletions(targets []*internal.Target, aliases map[string]string, groups map[string][]string, toComplete string) []cobra.Completion {
+	head, term := "", toComplete
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		head, term = toComplete[:i+1], toComplete[i+1:]
+	}
+
+	var completions []cobra.Completion
+	add := func(choice, description string) {
+		if strings.HasPrefix(choice, term) {
+			completions = append(completions, cobra.CompletionWithDesc(head+choice, description))
+		}
+	}
+
+	for _, t := range targets {
+		add(t.Name, t.TagName)
+		if strings.HasPrefix(term, "tag:") && t.TagName != "" {
+			add("tag:Name="+t.TagName, t.Name)
+		}
+	}
+	for _, name := range internal.SortedMapKeys(aliases) {
+		add(name, "alias: "+aliases[name])
+	}
+	for _, name := range internal.SortedMapKeys(groups) {
+		add("@"+name, "group: "+strings.Join(groups[name], ", "))
+	}
+	return completions
+}
+
+// completionInstances returns instances for completion from the cache, refreshing it
+// with a bounded FindInstances call when it is stale. Errors yield no instances.
+func completionInstances(ctx context.Context) []*internal.Target {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	home, err := getGossmHomePath()
+	if err != nil {
+		return nil
+	}
+	profile := resolveAWSProfile(viper.GetString("profile"))
+	awsConfig, err := internal.NewSharedConfig(ctx, profile,
+		[]string{config.DefaultSharedConfigFilename()}, []string{config.DefaultSharedCredentialsFilename()})
+	if err != nil {
+		return nil
+	}
+	if region := viper.GetString("region"); region != "" {
+		awsConfig.Region = region
+	}
+	if awsConfig.Region == "" {
+		return nil
+	}
+
+	cache := internal.NewInstanceCache(filepath.Join(home, _cacheDirName), profile, awsConfig.Region)
+	if targets, ok := cache.Load(); ok {
+		return targets
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, completionLookupTimeout)
+	defer cancel()
+	table, err := internal.FindInstances(ctx, ssm.NewFromConfig(awsConfig), ec2.NewFromConfig(awsConfig), internal.FindOptions{})
+	if err != nil {
+		return nil
+	}
+	if err := cache.Save(table); err != nil {
+		internal.DebugLog("cannot save instance cache: %v", err)
+	}
+	targets := make([]*internal.Target, 0, len(table))
+	for _, t := range table {
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	return targets
+}
+
+// saveInstanceCache stores instances found by a command for later completion.
+func saveInstanceCache(table map[string]*internal.Target) {
+	cache := internal.NewInstanceCache(filepath.Join(_credential.gossmHomePath, _cacheDirName),
+		_credential.awsProfile, _credential.awsConfig.Region)
+	if err := cache.Save(table); err != nil {
+		internal.DebugLog("cannot save instance cache: %v", err)
+	}
+}
+
+// completeProfiles completes profile names from the shared config and credentials files.
+func completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	profiles := profileNames(config.DefaultSharedConfigFilename(), config.DefaultSharedCredentialsFilename())
+	return filterPrefix(profiles, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeRegions completes AWS region names.
+func completeRegions(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	return filterPrefix(internal.KnownRegions(), toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// profileNames returns the sorted profile names defined in the given shared config files.
+// Config files name sections [profile name], credentials files [name].
+func profileNames(paths ...string) []string {
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+				continue
+			}
+			section := strings.TrimSpace(strings.Trim(line, "[]"))
+			if strings.HasPrefix(section, "sso-session ") || strings.HasPrefix(section, "services ") {
+				continue
+			}
+			if name := strings.TrimSpace(strings.TrimPrefix(section, "profile ")); name != "" {
+				seen[name] = true
+			}
+		}
+		f.Close()
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// filterPrefix returns the choices that start with prefix.
+func filterPrefix(choices []string, prefix string) []cobra.Completion {
+	var completions []cobra.Completion
+	for _, c := range choices {
+		if strings.HasPrefix(c, prefix) {
+			completions = append(completions, c)
+		}
+	}
+	return completions
+}
+
+func init() {
+	completionCommand.AddCommand(completionInstallCommand)
+	rootCmd.AddCommand(completionCommand)
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tommy-cxcpwz/gossm/internal"
+)
+
+func TestTargetCompletions(t *testing.T) {
+	targets := []*internal.Target{
+		{Name: "i-0aaaaaaaa", TagName: "web-1"},
+		{Name: "i-0bbbbbbbb", TagName: "db"},
+	}
+	aliases := map[string]string{"bastion": "i-0bbbbbbbb"}
+	groups := map[string][]string{"web": {"tag:Role=web"}}
+
+	tests := []struct {
+		name       string
+		toComplete string
+		want       []cobra.Completion
+	}{
+		{name: "empty offers everything", toComplete: "", want: []cobra.Completion{
+			"i-0aaaaaaaa\tweb-1", "i-0bbbbbbbb\tdb", "bastion\talias: i-0bbbbbbbb", "@web\tgroup: tag:Role=web",
+		}},
+		{name: "instance ID prefix", toComplete: "i-0a", want: []cobra.Completion{"i-0aaaaaaaa\tweb-1"}},
+		{name: "group prefix", toComplete: "@", want: []cobra.Completion{"@web\tgroup: tag:Role=web"}},
+		{name: "name tag values", toComplete: "tag:Name=w", want: []cobra.Completion{"tag:Name=web-1\ti-0aaaaaaaa"}},
+		{name: "term after comma", toComplete: "i-0aaaaaaaa,i-0b", want: []cobra.Completion{"i-0aaaaaaaa,i-0bbbbbbbb\tdb"}},
+		{name: "no match", toComplete: "x", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := targetCompletions(targets, aliases, groups, tt.toComplete)
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestProfileNames_ReadsConfigAndCredentials(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	credentialsFile := filepath.Join(dir, "credentials")
+	require.NoError(t, os.WriteFile(configFile, []byte("[default]\nregion = us-east-1\n[profile prod]\n[sso-session corp]\n[ profile dev ]\n"), 0600))
+	require.NoError(t, os.WriteFile(credentialsFile, []byte("[default]\naws_access_key_id = x\n[legacy]\n"), 0600))
+
+	names := profileNames(configFile, credentialsFile, filepath.Join(dir, "missing"))
+
+	assert.Equal(t, []string{"default", "dev", "legacy", "prod"}, names)
+}
+
+func TestCompletionInstallPath(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+
+	tests := []struct {
+		shell   string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{shell: "bash", want: "/home/u/.local/share/bash-completion/completions/gossm"},
+		{shell: "bash", env: map[string]string{"XDG_DATA_HOME": "/xdg/data"}, want: "/xdg/data/bash-completion/completions/gossm"},
+		{shell: "zsh", want: "/home/u/.zfunc/_gossm"},
+		{shell: "fish", env: map[string]string{"XDG_CONFIG_HOME": "/xdg/config"}, want: "/xdg/config/fish/completions/gossm.fish"},
+		{shell: "tcsh", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			env = tt.env
+
+			got, err := completionInstallPath(tt.shell, "/home/u", getenv)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWriteCompletionScript(t *testing.T) {
+	for _, shell := range completionShells {
+		var buf bytes.Buffer
+
+		require.NoError(t, writeCompletionScript(&buf, shell), shell)
+
+		assert.Contains(t, buf.String(), "gossm", shell)
+	}
+	assert.Error(t, writeCompletionScript(&bytes.Buffer{}, "tcsh"))
+}
+
+func TestTargetFlags_HaveCompletion(t *testing.T) {
+	for _, c := range []*cobra.Command{startSessionCommand, execCommand} {
+		_, ok := c.GetFlagCompletionFunc("target")
+
+		assert.True(t, ok, c.Name())
+	}
+	for _, name := range []string{"profile", "region"} {
+		_, ok := rootCmd.GetFlagCompletionFunc(name)
+
+		assert.True(t, ok, name)
+	}
+}
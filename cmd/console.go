@@ -0,0 +1,361 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/fatih/color"
+	"github.com/kballard/go-shellquote"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/tommy-cxcpwz/gossm/internal"
+)
+
+// _consoleHistoryFileName is the file in ~/.gossm the console keeps its history in.
+const _consoleHistoryFileName = "console_history"
+
+// consoleHelp describes the console commands.
+const consoleHelp = `Lines are sent to every target as shell commands, or PowerShell commands when
+all targets run Windows. Console commands:
+  :targets                 list the targets
+  :targets add <target>... add instances, aliases, @groups or tag:Key=Value selectors
+  :targets rm <target>...  remove instances by ID or Name tag
+  :targets pick            choose the targets again interactively
+  :cd [dir]                run later commands in dir (no dir: the default directory)
+  :env [NAME=value...]     set environment variables of later commands, or list them
+  :env -u NAME...          unset environment variables
+  :history                 list the lines entered
+  :help                    show this help
+  :quit                    leave the console (or Ctrl+D)`
+
+var (
+	consoleCommand = &cobra.Command{
+		Use:   "console",
+		Short: "Run commands interactively on a set of instances",
+		Long: `Run commands interactively on a set of instances via SSM.
+
+The console keeps a set of targets, given with -t, --asg and --nodegroup or
+selected interactively, and sends each line entered to all of them like exec.
+Once every instance has answered, each distinct result is printed once, after
+the instances that produced it.
+
+Lines starting with : are console commands. :targets changes the set of
+targets, and :cd and :env set the working directory and environment variables
+later commands run with. Up and down recall earlier lines, which are kept in
+~/.gossm/console_history. Ctrl+C cancels a running command; Ctrl+D or :quit
+leaves the console.
+
+` + consoleHelp + `
+
+Examples:
+  gossm console -t @web
+  gossm console --asg web-asg --max-concurrency 10
+  gossm console                     # interactive multi-select`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			ssmClient := ssm.NewFromConfig(*_credential.awsConfig)
+			ec2Client := ec2.NewFromConfig(*_credential.awsConfig)
+
+			sendOpts, wait, err := sendOptionsFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			sendOpts.ExecutionTimeout, _ = cmd.Flags().GetDuration("execution-timeout")
+			if err := sendOpts.Validate(); err != nil {
+				return err
+			}
+
+			refs := commandRefs(cmd)
+			// the set of targets changes, so commands are always sent by instance ID
+			targets, _, err := selectCommandTargets(ctx, cmd, ssmClient, ec2Client, refs)
+			if err != nil {
+				return err
+			}
+			if err := ensureRunning(ctx, cmd, ec2Client, ssmClient, targets); err != nil {
+				return err
+			}
+			if skipCheck, _ := cmd.Flags().GetBool("skip-check"); len(refs) > 0 && !skipCheck {
+				if err := checkConnected(ctx, ssmClient, targets); err != nil {
+					return err
+				}
+			}
+			internal.PrintReadyMulti("console", _credential.awsConfig.Region, targets)
+
+			history, err := internal.LoadConsoleHistory(filepath.Join(_credential.gossmHomePath, _consoleHistoryFileName))
+			if err != nil {
+				internal.DebugLog("cannot read console history: %v", err)
+				history = &internal.ConsoleHistory{}
+			}
+			sendOpts.Comment = issuerComment(ctx)
+			c := &console{
+				cmd:       cmd,
+				ssmClient: ssmClient,
+				ec2Client: ec2Client,
+				session:   &internal.ConsoleSession{Targets: targets},
+				history:   history,
+				sendOpts:  sendOpts,
+				wait:      wait,
+			}
+			fmt.Println("Type :help for console commands.")
+			return c.run(ctx, newLineReader(cmd.InOrStdin(), cmd.OutOrStdout(), history))
+		},
+	}
+)
+
+type (
+	// console sends the lines read to the targets of its session.
+	console struct {
+		cmd       *cobra.Command
+		ssmClient *ssm.Client
+		ec2Client *ec2.Client
+		session   *internal.ConsoleSession
+		history   *internal.ConsoleHistory
+		sendOpts  internal.SendCommandOptions
+		wait      time.Duration
+	}
+
+	// lineReader reads the lines entered in the console.
+	lineReader interface {
+		ReadLine(prompt string) (string, error)
+	}
+
+	// terminalReader reads lines from a terminal with line editing and history. The
+	// terminal is in raw mode only while a line is read, so commands print as usual.
+	terminalReader struct {
+		fd       int
+		terminal *term.Terminal
+	}
+
+	// scannerReader reads lines from input that is not a terminal, such as a pipe.
+	scannerReader struct {
+		scanner *bufio.Scanner
+	}
+
+	// terminalHistory offers the console history to a terminal for recall. Lines are
+	// added by console.run, for terminals and pipes alike, so the terminal adds none.
+	terminalHistory struct {
+		*internal.ConsoleHistory
+	}
+)
+
+// newLineReader returns a terminalReader when in and out are a terminal, or a scannerReader.
+func newLineReader(in io.Reader, out io.Writer, history *internal.ConsoleHistory) lineReader {
+	inFile, inOK := in.(*os.File)
+	outFile, outOK := out.(*os.File)
+	if !inOK || !outOK || !term.IsTerminal(int(inFile.Fd())) || !term.IsTerminal(int(outFile.Fd())) {
+		return &scannerReader{scanner: bufio.NewScanner(in)}
+	}
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{in, out}, "")
+	terminal.History = terminalHistory{history}
+	return &terminalReader{fd: int(inFile.Fd()), terminal: terminal}
+}
+
+// ReadLine shows prompt and reads a line. Ctrl+C and Ctrl+D return io.EOF.
+func (r *terminalReader) ReadLine(prompt string) (string, error) {
+	state, err := term.MakeRaw(r.fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(r.fd, state)
+	r.terminal.SetPrompt(prompt)
+	return r.terminal.ReadLine()
+}
+
+// ReadLine reads the next line; there is no prompt, as nobody is typing.
+func (r *scannerReader) ReadLine(string) (string, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+// Add does nothing; see terminalHistory.
+func (terminalHistory) Add(string) {}
+
+// run reads, records in the history and handles lines until the input ends or :quit.
+// Errors of single lines are printed and do not end the console.
+func (c *console) run(ctx context.Context, in lineReader) error {
+	for {
+		line, err := in.ReadLine(c.prompt())
+		if err == io.EOF {
+			fmt.Println()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		c.history.Add(strings.TrimSpace(line))
+		quit, err := c.handle(ctx, line)
+		if err != nil {
+			color.Red("%v", err)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// prompt shows how many targets the next command goes to and the directory it runs in.
+func (c *console) prompt() string {
+	prompt := fmt.Sprintf("gossm(%d)", len(c.session.Targets))
+	if c.session.Dir != "" {
+		prompt += ":" + c.session.Dir
+	}
+	return prompt + "> "
+}
+
+// handle runs a console command or sends line to the targets. It reports whether the
+// console should end.
+func (c *console) handle(ctx context.Context, line string) (bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return false, nil
+	}
+	if !strings.HasPrefix(line, ":") {
+		return false, c.send(ctx, line)
+	}
+
+	words, err := shellquote.Split(line[1:])
+	if err != nil {
+		return false, fmt.Errorf("cannot parse %s: %w", line, err)
+	}
+	if len(words) == 0 {
+		return false, fmt.Errorf("missing console command; type :help for a list")
+	}
+	name, args := words[0], words[1:]
+	switch name {
+	case "q", "quit", "exit":
+		return true, nil
+	case "help":
+		fmt.Println(consoleHelp)
+	case "targets":
+		return false, c.targets(ctx, args)
+	case "cd":
+		if len(args) > 1 {
+			return false, fmt.Errorf("usage: :cd [dir]")
+		}
+		c.session.ChangeDir(strings.Join(args, ""))
+	case "env":
+		return false, c.env(args)
+	case "history":
+		for i, l := range c.history.Lines() {
+			fmt.Printf("%5d  %s\n", i+1, l)
+		}
+	default:
+		return false, fmt.Errorf("unknown console command :%s; type :help for a list", name)
+	}
+	return false, nil
+}
+
+// send runs line on the targets and prints the results grouped by output.
+func (c *console) send(ctx context.Context, line string) error {
+	targets := c.session.Targets
+	if len(targets) == 0 {
+		return fmt.Errorf("no targets; add some with :targets add or :targets pick")
+	}
+	// targets added later may run another platform, so the document is picked every time
+	if err := internal.DescribePlatforms(ctx, c.ssmClient, targets); err != nil {
+		return err
+	}
+	document, err := internal.ScriptDocument(targets)
+	if err != nil {
+		return err
+	}
+	params, err := c.session.Parameters(document, line)
+	if err != nil {
+		return err
+	}
+	batches, sendErr := internal.SendCommand(ctx, c.ssmClient, targets, document, params, c.sendOpts)
+	if len(batches) == 0 {
+		return sendErr
+	}
+	watchOpts := shellScriptWatchOptions(c.cmd, "")
+	watchOpts.HideOutput = true
+	watchOpts.FullOutput.Document = document
+	results, err := watchCommand(ctx, c.ssmClient, targets, batches, internal.InvocationInputs(batches), sendErr, c.wait, watchOpts)
+	internal.PrintResultGroups(internal.GroupResults(results), false)
+	return err
+}
+
+// targets lists or changes the targets of the session.
+func (c *console) targets(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		if len(c.session.Targets) == 0 {
+			color.Yellow("no targets")
+		}
+		for _, t := range c.session.Targets {
+			fmt.Printf("  %s %s\n", color.YellowString(t.Name), color.CyanString(t.TagName))
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) == 1 {
+			return fmt.Errorf("usage: :targets add <target>...")
+		}
+		targets, err := newTargetResolver(c.ssmClient, c.ec2Client, findOptionsFromFlags(c.cmd)).Resolve(ctx, args[1:])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("added %d instance(s)\n", c.session.AddTargets(targets))
+	case "rm":
+		if len(args) == 1 {
+			return fmt.Errorf("usage: :targets rm <target>...")
+		}
+		fmt.Printf("removed %d instance(s)\n", c.session.RemoveTargets(args[1:]))
+	case "pick":
+		targets, err := internal.AskMultiTarget(ctx, c.ssmClient, c.ec2Client, findOptionsFromFlags(c.cmd))
+		if err != nil {
+			return err
+		}
+		c.session.Targets = targets
+	default:
+		return fmt.Errorf("unknown :targets command %q (want add, rm or pick)", args[0])
+	}
+	return nil
+}
+
+// env lists, sets or unsets the environment variables of the session.
+func (c *console) env(args []string) error {
+	if len(args) == 0 {
+		for _, name := range internal.SortedMapKeys(c.session.Env) {
+			fmt.Printf("%s=%s\n", name, c.session.Env[name])
+		}
+		return nil
+	}
+	if args[0] == "-u" {
+		c.session.UnsetEnv(args[1:])
+		return nil
+	}
+	return c.session.SetEnv(args)
+}
+
+func init() {
+	consoleCommand.Flags().StringArrayP("target", "t", nil, "target instance ID, alias, @group, tag:Key=Value selector or field=value filter (repeatable)")
+	consoleCommand.RegisterFlagCompletionFunc("target", completeTargets)
+	addGroupFlags(consoleCommand)
+	consoleCommand.Flags().Bool("online-only", false, "[optional] only offer instances whose SSM agent ping status is Online")
+	addStartFlags(consoleCommand)
+	addSendFlags(consoleCommand)
+	consoleCommand.Flags().Duration("execution-timeout", 0, "[optional] how long each command may run once delivered, up to 48h (default 1h)")
+	consoleCommand.Flags().Bool("skip-check", false, "[optional] skip SSM connectivity check before starting")
+
+	rootCmd.AddCommand(consoleCommand)
+}
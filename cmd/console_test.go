@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tommy-cxcpwz/gossm/internal"
+)
+
+func TestConsole_Handle_ConsoleCommands(t *testing.T) {
+	c := &console{
+		session: &internal.ConsoleSession{Targets: []*internal.Target{{Name: "i-1"}, {Name: "i-2", TagName: "web-2"}}},
+		history: &internal.ConsoleHistory{},
+	}
+	ctx := context.Background()
+
+	for _, line := range []string{":cd /srv/app", `:env RAILS_ENV=production MSG="two words"`, ":env -u RAILS_ENV", ":targets rm web-2", "  "} {
+		quit, err := c.handle(ctx, line)
+		require.NoError(t, err, line)
+		assert.False(t, quit, line)
+	}
+	assert.Equal(t, "/srv/app", c.session.Dir)
+	assert.Equal(t, map[string]string{"MSG": "two words"}, c.session.Env)
+	assert.Len(t, c.session.Targets, 1)
+	assert.Equal(t, "gossm(1):/srv/app> ", c.prompt())
+
+	quit, err := c.handle(ctx, ":quit")
+	require.NoError(t, err)
+	assert.True(t, quit)
+}
+
+func TestConsole_Handle_Errors(t *testing.T) {
+	c := &console{session: &internal.ConsoleSession{}, history: &internal.ConsoleHistory{}}
+	ctx := context.Background()
+
+	tests := []struct {
+		line    string
+		wantErr string
+	}{
+		{line: ":nope", wantErr: "unknown console command :nope"},
+		{line: `:env MSG="open`, wantErr: "cannot parse"},
+		{line: ":env 1X=2", wantErr: "invalid variable"},
+		{line: ":cd a b", wantErr: "usage: :cd"},
+		{line: ":targets drop i-1", wantErr: "unknown :targets command"},
+		{line: "uptime", wantErr: "no targets"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			_, err := c.handle(ctx, tt.line)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestConsole_Run_RecordsPipedLinesInHistory(t *testing.T) {
+	c := &console{session: &internal.ConsoleSession{}, history: &internal.ConsoleHistory{}}
+	in := &scannerReader{scanner: bufio.NewScanner(strings.NewReader(":cd /srv\n\n  :env A=1\n:env A=1\n:quit\n"))}
+
+	require.NoError(t, c.run(context.Background(), in))
+	assert.Equal(t, []string{":cd /srv", ":env A=1", ":quit"}, c.history.Lines())
+}
+
+func TestTerminalHistory_AddRecordsNothing(t *testing.T) {
+	history := &internal.ConsoleHistory{}
+	terminalHistory{history}.Add("uptime")
+	assert.Zero(t, history.Len())
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/tommy-cxcpwz/gossm/internal"
+)
+
+var (
+	doctorCommand = &cobra.Command{
+		Use:   "doctor [target]",
+		Short: "Diagnose why an instance is not reachable via SSM",
+		Long: `Diagnose why an instance is not reachable via SSM.
+
+With a target (instance ID, alias, @group or tag:Key=Value selector), checks
+the instance state, IAM instance profile, AmazonSSMManagedInstanceCore policy,
+network path to the ssm, ssmmessages and ec2messages endpoints, and the SSM
+agent ping. Targets are looked up in EC2, so instances that are not registered
+with SSM can be given by alias or tag as well.
+
+Without a target, lists running instances that are not registered with SSM.
+
+Examples:
+  gossm doctor i-0abc123def456789
+  gossm doctor tag:Name=web-1
+  gossm doctor`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			ssmClient := ssm.NewFromConfig(*_credential.awsConfig)
+			ec2Client := ec2.NewFromConfig(*_credential.awsConfig)
+
+			if len(args) == 0 {
+				return printUnmanagedInstances(ctx, ssmClient, ec2Client)
+			}
+
+			// the instances worth diagnosing are often not registered with SSM, or stopped
+			opts := internal.FindOptions{IncludeStopped: true, IncludeUnmanaged: true}
+			targets, err := newTargetResolver(ssmClient, ec2Client, opts).Resolve(ctx, args)
+			if err != nil {
+				return err
+			}
+			target, err := internal.ChooseTarget(targets)
+			if err != nil {
+				return err
+			}
+
+			internal.PrintReady("doctor", _credential.awsConfig.Region, target.Name)
+			checks, err := internal.Diagnose(ctx, internal.DoctorClients{
+				SSM: ssmClient,
+				EC2: ec2Client,
+				IAM: iam.NewFromConfig(*_credential.awsConfig),
+			}, _credential.awsConfig.Region, target.Name)
+			if err != nil {
+				return err
+			}
+			internal.PrintDoctorChecks(checks)
+			return nil
+		},
+	}
+)
+
+// printUnmanagedInstances prints running instances that are not registered with SSM.
+func printUnmanagedInstances(ctx context.Context, ssmClient internal.SSMDescribeInstanceInfoAPI, ec2Client internal.EC2DescribeInstancesAPI) error {
+	unmanaged, err := internal.FindUnmanagedInstances(ctx, ssmClient, ec2Client)
+	if err != nil {
+		return err
+	}
+	if len(unmanaged) == 0 {
+		color.Green("[OK] every running instance is registered with SSM")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, color.CyanString("NAME\tINSTANCE ID\tPRIVATE DNS\tPUBLIC DNS"))
+	fmt.Fprintln(w, color.CyanString("----\t-----------\t-----------\t----------"))
+	for _, t := range unmanaged {
+		name, privateDNS, publicDNS := formatFields(t)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, t.Name, privateDNS, publicDNS)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%s %d running instance(s) not registered with SSM; run 'gossm doctor <instance-id>' to diagnose\n",
+		color.YellowString("[warn]"), len(unmanaged))
+	return nil
+}
+
+func init() {
+	doctorCommand.ValidArgsFunction = completeTargetArg
+	rootCmd.AddCommand(doctorCommand)
+}
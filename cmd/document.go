@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/tommy-cxcpwz/gossm/internal"
+)
+
+var (
+	runDocumentCommand = &cobra.Command{
+		Use:   "run-document [document]",
+		Short: "Run an SSM Command document on one or more instances",
+		Long: `Run an SSM Command document on one or more instances.
+
+Without a document, choose one of the Command documents owned by --owner
+(Self by default; Amazon, Private, Public, ThirdParty or All). --list prints
+them instead.
+
+The document's parameters are shown with their defaults. Without --param,
+gossm asks for each value; with --param name=value (repeatable, and repeated
+for StringList values) parameters not given use their defaults.
+
+Targets are chosen as for exec: -t/--target, --asg, --nodegroup, or an
+interactive multi-select.
+
+Examples:
+  gossm run-document --list
+  gossm run-document -t @web
+  gossm run-document AWS-ConfigureAWSPackage -t @web --param action=Install --param name=AmazonCloudWatchAgent
+  gossm run-document Acme-CollectLogs --asg web-asg --param since=1h --max-concurrency 25%`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			ssmClient := ssm.NewFromConfig(*_credential.awsConfig)
+			ec2Client := ec2.NewFromConfig(*_credential.awsConfig)
+			owner, _ := cmd.Flags().GetString("owner")
+
+			if list, _ := cmd.Flags().GetBool("list"); list {
+				docs, err := internal.ListCommandDocuments(ctx, ssmClient, owner)
+				if err != nil {
+					return err
+				}
+				return printDocuments(os.Stdout, docs)
+			}
+
+			sendOpts, wait, err := sendOptionsFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			if err := sendOpts.Validate(); err != nil {
+				return err
+			}
+
+			var name string
+			if len(args) > 0 {
+				name = args[0]
+			} else {
+				docs, err := internal.ListCommandDocuments(ctx, ssmClient, owner)
+				if err != nil {
+					return err
+				}
+				if name, err = internal.AskDocument(docs); err != nil {
+					return err
+				}
+			}
+			doc, err := internal.GetCommandDocument(ctx, ssmClient, name)
+			if err != nil {
+				return err
+			}
+			printDocumentParameters(os.Stdout, doc)
+
+			paramFlags, _ := cmd.Flags().GetStringArray("param")
+			params, err := doc.ParseParams(paramFlags)
+			if err != nil {
+				return err
+			}
+			if len(paramFlags) == 0 {
+				if params, err = internal.AskDocumentParameters(doc, params); err != nil {
+					return err
+				}
+			}
+			if missing := doc.Missing(params); len(missing) > 0 {
+				return fmt.Errorf("document %s requires parameters without defaults: %s (set them with --param name=value)", doc.Name, strings.Join(missing, ", "))
+			}
+
+			refs := commandRefs(cmd)
+			targets, selector, err := selectCommandTargets(ctx, cmd, ssmClient, ec2Client, refs)
+			if err != nil {
+				return err
+			}
+			sendOpts.Selector = selector
+			if len(refs) > 0 {
+				if err := checkConnected(ctx, ssmClient, targets); err != nil {
+					return err
+				}
+			}
+
+			internal.PrintReadyMulti(doc.Name, _credential.awsConfig.Region, targets)
+
+			sendOpts.Comment = issuerComment(ctx)
+			batches, sendErr := internal.SendCommand(ctx, ssmClient, targets, doc.Name, params, sendOpts)
+			if len(batches) == 0 {
+				return sendErr
+			}
+			inputs := internal.StepInvocationInputs(internal.InvocationInputs(batches), doc.Steps)
+			_, err = watchCommand(ctx, ssmClient, targets, batches, inputs, sendErr, wait, internal.WatchOptions{})
+			return err
+		},
+	}
+)
+
+// printDocuments prints Command documents as a table.
+func printDocuments(out io.Writer, docs []ssm_types.DocumentIdentifier) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, color.CyanString("NAME\tOWNER\tVERSION\tPLATFORMS"))
+	fmt.Fprintln(w, color.CyanString("----\t-----\t-------\t---------"))
+	for _, d := range docs {
+		platforms := make([]string, 0, len(d.PlatformTypes))
+		for _, p := range d.PlatformTypes {
+			platforms = append(platforms, string(p))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", aws.ToString(d.Name), aws.ToString(d.Owner), aws.ToString(d.DocumentVersion), strings.Join(platforms, ","))
+	}
+	return w.Flush()
+}
+
+// printDocumentParameters prints the declared parameters of a document with their defaults.
+func printDocumentParameters(out io.Writer, doc *internal.CommandDocument) {
+	fmt.Fprintf(out, "%s %s\n", color.GreenString("[document]"), doc.Name)
+	if doc.Description != "" {
+		fmt.Fprintf(out, "  %s\n", strings.SplitN(doc.Description, "\n", 2)[0])
+	}
+	for _, p := range doc.Parameters {
+		value := color.RedString("required")
+		if p.HasDefault {
+			value = fmt.Sprintf("default: %q", strings.Join(p.Default, ", "))
+		}
+		fmt.Fprintf(out, "  %s (%s) %s", color.YellowString(p.Name), p.Type, value)
+		if len(p.AllowedValues) > 0 {
+			fmt.Fprintf(out, ", one of: %s", strings.Join(p.AllowedValues, ", "))
+		}
+		if p.Description != "" {
+			fmt.Fprintf(out, " - %s", p.Description)
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+func init() {
+	runDocumentCommand.Flags().StringArrayP("target", "t", nil, "target instance ID, alias, @group, tag:Key=Value selector or field=value filter (repeatable)")
+	runDocumentCommand.RegisterFlagCompletionFunc("target", completeTargets)
+	addGroupFlags(runDocumentCommand)
+	runDocumentCommand.Flags().Bool("online-only", false, "[optional] only offer instances whose SSM agent ping status is Online")
+	runDocumentCommand.Flags().StringArray("param", nil, "[optional] document parameter as name=value (repeatable); skips the parameter prompts")
+	runDocumentCommand.Flags().String("owner", "Self", "[optional] owner of the documents to choose from: Self, Amazon, Private, Public, ThirdParty or All")
+	runDocumentCommand.RegisterFlagCompletionFunc("owner", cobra.FixedCompletions([]string{"Self", "Amazon", "Private", "Public", "ThirdParty", "All"}, cobra.ShellCompDirectiveNoFileComp))
+	runDocumentCommand.Flags().Bool("list", false, "[optional] list the Command documents of --owner and exit")
+	addSendFlags(runDocumentCommand)
+
+	rootCmd.AddCommand(runDocumentCommand)
+}
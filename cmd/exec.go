@@ -2,12 +2,20 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2_types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
@@ -18,92 +26,611 @@ import (
 
 var (
 	execCommand = &cobra.Command{
-		Use:   "exec [command] [args...]",
+		Use:   "exec [command] [args...] | --file script [-- args...]",
 		Short: "Execute a command on one or more instances via SSM",
 		Long: `Execute a command on one or more instances via SSM.
 
-Use -t/--target to specify instance IDs directly (repeatable), or omit to
-interactively select multiple instances.
+Use -t/--target to specify targets directly (repeatable), or omit to
+interactively select multiple instances. A target is an instance ID, an alias
+or @group from ~/.gossm/config.yaml, or a tag:Key=Value selector.
+--asg and --nodegroup target the current InService members of an Auto Scaling
+group or EKS node group.
+
+--max-concurrency and --max-errors limit how many instances run the command at
+once and after how many failures SSM stops sending it, as a count or a
+percentage. Instances skipped after the error threshold is reached are reported
+separately.
+
+--delivery-timeout bounds how long SSM tries to reach an instance and
+--execution-timeout how long the script may run. --wait stops watching after
+the given time and exits with code 75, leaving the command running.
+
+With a single target, exec exits with the remote command's exit code. With
+several targets it exits with 0 when all succeeded, 1 when some failed, 2 when
+all failed and 124 when any timed out on the instance. When --wait expires the
+exit code is 75, so scripts can tell a command that is still running, whose
+results "gossm exec show" finds later, from one that timed out.
+
+exec history, exec show and exec retry list, show and resend earlier commands.
+To run a remote command with one of these names, give it after --, as in
+"gossm exec -t @web -- history".
+
+Ctrl+C cancels the command on every instance and waits until the invocations
+are cancelled; press it again to exit immediately. Either way exec exits with
+code 130.
+
+Output longer than the 24,000 characters SSM returns inline is fetched in full
+from CloudWatch Logs, or from the bucket given with --output-s3-bucket.
+--follow streams each instance's output from CloudWatch Logs while it runs.
+--output-dir writes each instance's stdout and stderr to <instance>-<name>.stdout
+and .stderr, with a summary.json of statuses, exit codes and times.
+--group prints each distinct result once, after the instances that produced it,
+most common first; --diff shows the others as a diff against the most common one.
+
+--batch-size rolls the command out to that many instances at a time. After each
+batch succeeds, --health-cmd runs on the same instances and --batch-pause waits
+before the next batch. The rollout stops at the first batch whose command or
+health check fails, or on Ctrl+C, and lists which instances are done and which
+are pending. The health command should wait for the service itself, e.g. with
+curl --retry.
+
+--user runs the command as another user with sudo, --workdir in the given
+directory and --env NAME=value (repeatable) with extra environment variables,
+instead of as root in the SSM agent's directory. Commands to Windows instances
+are sent as AWS-RunPowerShellScript, where --workdir and --env work as well.
+
+--file runs a local script on the instances, with the remaining arguments (after
+--) passed to it. The script runs with the interpreter of its #! line, or sh.
 
 Examples:
   gossm exec --target i-0abc123def456789 ls -la
   gossm exec --target i-0abc123 --target i-0def456 "cat /etc/hosts"
+  gossm exec -t @web uptime
+  gossm exec -t tag:Role=web,tag:Env=prod uptime
+  gossm exec --asg web-asg uptime
+  gossm exec --nodegroup prod/workers "systemctl status kubelet"
+  gossm exec -t tag:Role=web --max-concurrency 10% --max-errors 1 "systemctl restart nginx"
+  gossm exec -t @db --execution-timeout 2h --wait 10m /opt/maintenance.sh
+  gossm exec -t @web --follow "yum -y update"
+  gossm exec -t @web --file ./deploy.sh -- v1.2.3 --dry-run
+  gossm exec -t @app --user app --workdir /srv/app --env RAILS_ENV=production "bin/rails db:migrate:status"
+  gossm exec -t @web --output-dir ./diag "journalctl -u nginx --since -1h"
+  gossm exec -t @web --diff cat /etc/os-release
+  gossm exec -t @web --batch-size 5 --batch-pause 30s \
+    --health-cmd "curl -fsS --retry 10 --retry-connrefused localhost/healthz" "systemctl restart nginx"
   gossm exec df -h                  # interactive multi-select
   gossm exec --skip-check --target i-0abc123 ls -la`,
-		Args: cobra.MinimumNArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			// with --file the arguments are passed to the script and may be omitted
+			if file, _ := cmd.Flags().GetString("file"); file != "" {
+				return nil
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := context.Background()
 			ssmClient := ssm.NewFromConfig(*_credential.awsConfig)
+			ec2Client := ec2.NewFromConfig(*_credential.awsConfig)
 
-			command := strings.Join(args, " ")
+			command, label, err := execCommandText(cmd, args)
+			if err != nil {
+				return err
+			}
 			skipCheck := viper.GetBool("exec-skip-check")
-			targetFlags, _ := cmd.Flags().GetStringSlice("target")
+			refs := commandRefs(cmd)
+			if file, _ := cmd.Flags().GetString("file"); file == "-" && len(refs) == 0 {
+				return fmt.Errorf("--file - reads the script from stdin, so targets must be given with -t, --asg or --nodegroup")
+			}
 
-			var targets []*internal.Target
+			sendOpts, wait, err := sendOptionsFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			sendOpts.ExecutionTimeout, _ = cmd.Flags().GetDuration("execution-timeout")
+			sendOpts.OutputS3Bucket, _ = cmd.Flags().GetString("output-s3-bucket")
+			outputDir, _ := cmd.Flags().GetString("output-dir")
+			group, _ := cmd.Flags().GetBool("group")
+			diff, _ := cmd.Flags().GetBool("diff")
+			if follow, _ := cmd.Flags().GetBool("follow"); follow && (group || diff) {
+				return fmt.Errorf("--follow streams each instance's output, so it cannot be combined with --group or --diff")
+			}
+			if err := sendOpts.Validate(); err != nil {
+				return err
+			}
+			rollout, healthCmd, err := rolloutFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			env, err := commandEnvFromFlags(cmd)
+			if err != nil {
+				return err
+			}
 
-			if len(targetFlags) > 0 {
-				// Validate and build targets from flags
-				for _, id := range targetFlags {
-					if err := internal.ValidateInstanceID(id); err != nil {
-						return err
-					}
-					targets = append(targets, &internal.Target{Name: id})
+			targets, selector, err := selectCommandTargets(ctx, cmd, ssmClient, ec2Client, refs)
+			if err != nil {
+				return err
+			}
+			sendOpts.Selector = selector
+			if err := internal.DescribePlatforms(ctx, ssmClient, targets); err != nil {
+				return err
+			}
+			document, err := internal.ScriptDocument(targets)
+			if err != nil {
+				return err
+			}
+			if _, err := env.Parameters(document, command); err != nil {
+				return err
+			}
+			if document == internal.PowerShellScriptDocument {
+				if file, _ := cmd.Flags().GetString("file"); file != "" {
+					return fmt.Errorf("--file runs shell scripts, which Windows instances cannot run")
 				}
+				if follow, _ := cmd.Flags().GetBool("follow"); follow {
+					return fmt.Errorf("--follow streams AWS-RunShellScript output and cannot follow Windows instances")
+				}
+			}
 
-				// Check SSM connectivity unless skipped
-				if !skipCheck {
-					connectedInstances, err := internal.FindInstanceIdsWithConnectedSSM(ctx, ssmClient)
-					if err != nil {
-						return err
-					}
-					connSet := make(map[string]struct{}, len(connectedInstances))
-					for _, id := range connectedInstances {
-						connSet[id] = struct{}{}
-					}
-					for _, t := range targets {
-						if _, ok := connSet[t.Name]; !ok {
-							return fmt.Errorf("instance %s is not connected to SSM.\nPossible causes:\n  - SSM agent is not running on the instance\n  - Instance lacks IAM permissions (AmazonSSMManagedInstanceCore)\n  - Network connectivity issues\n\nUse 'gossm list' to see available instances, or use --skip-check to bypass this validation", t.Name)
-						}
-					}
+			if err := ensureRunning(ctx, cmd, ec2Client, ssmClient, targets); err != nil {
+				return err
+			}
+
+			// Check SSM connectivity of explicit targets unless skipped
+			if len(refs) > 0 && !skipCheck {
+				if err := checkConnected(ctx, ssmClient, targets); err != nil {
+					return err
 				}
+			}
+
+			internal.PrintReadyMulti(label, _credential.awsConfig.Region, targets)
+
+			sendOpts.Comment = issuerComment(ctx)
+			watchOpts := shellScriptWatchOptions(cmd, sendOpts.OutputS3Bucket)
+			watchOpts.HideOutput = group || diff
+			watchOpts.FullOutput.Document = document
+			runner := scriptRunner{ssmClient: ssmClient, document: document, env: env, sendOpts: sendOpts, wait: wait, watchOpts: watchOpts}
+			var results []internal.InvocationResult
+			if rollout.BatchSize == 0 {
+				results, err = runner.run(ctx, targets, command)
 			} else {
-				// Interactive multi-select
-				ec2Client := ec2.NewFromConfig(*_credential.awsConfig)
-				selected, err := internal.AskMultiTarget(ctx, ssmClient, ec2Client)
-				if err != nil {
+				// each batch is sent by instance ID, so it runs on exactly those instances
+				runner.sendOpts.Selector = nil
+				run := func(ctx context.Context, batch []*internal.Target) error {
+					batchResults, err := runner.run(ctx, batch, command)
+					results = append(results, batchResults...)
 					return err
 				}
-				targets = selected
+				var health internal.RolloutStep
+				if healthCmd != "" {
+					healthRunner := runner
+					healthRunner.watchOpts.HideOutput = false
+					health = func(ctx context.Context, batch []*internal.Target) error {
+						_, err := healthRunner.run(ctx, batch, healthCmd)
+						return err
+					}
+				}
+				signals, stop := notifyInterrupt()
+				err = runRollout(ctx, signals, targets, rollout, run, health)
+				stop()
+			}
+			if group || diff {
+				internal.PrintResultGroups(internal.GroupResults(results), diff)
 			}
+			return writeOutputDir(outputDir, results, err)
+		},
+	}
+)
 
-			internal.PrintReadyMulti(command, _credential.awsConfig.Region, targets)
+// commandRefs returns the target references given by -t, --asg and --nodegroup.
+func commandRefs(cmd *cobra.Command) []string {
+	targetFlags, _ := cmd.Flags().GetStringArray("target")
+	return append(targetFlags, groupRefs(cmd)...)
+}
 
-			sendOutput, err := internal.SendCommand(ctx, ssmClient, targets, command)
-			if err != nil {
-				return err
-			}
+// selectCommandTargets resolves refs, or asks for targets interactively when there are none.
+// The selector is set when refs amount to a single tag selector, see TargetResolver.Selector.
+func selectCommandTargets(ctx context.Context, cmd *cobra.Command, ssmClient *ssm.Client, ec2Client *ec2.Client, refs []string) ([]*internal.Target, *internal.Selector, error) {
+	findOpts := findOptionsFromFlags(cmd)
+	if len(refs) == 0 {
+		// Interactive multi-select
+		targets, err := internal.AskMultiTarget(ctx, ssmClient, ec2Client, findOpts)
+		return targets, nil, err
+	}
 
-			fmt.Printf("%s\n", color.YellowString("Waiting for response..."))
-			time.Sleep(time.Second * 2)
+	// Resolve instance IDs, aliases, @groups, selectors and group members
+	resolver := newTargetResolver(ssmClient, ec2Client, findOpts)
+	targets, err := resolver.Resolve(ctx, refs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return targets, resolver.Selector(refs), nil
+}
 
-			// Build invocation inputs and name map for all targets
-			inputs := make([]*ssm.GetCommandInvocationInput, 0, len(targets))
-			nameMap := make(map[string]string, len(targets))
-			for _, t := range targets {
-				inputs = append(inputs, &ssm.GetCommandInvocationInput{
-					CommandId:  sendOutput.Command.CommandId,
-					InstanceId: aws.String(t.Name),
-				})
-				nameMap[t.Name] = t.TagName
-			}
-			internal.PrintCommandInvocation(ctx, ssmClient, inputs, nameMap)
+// sendOptionsFromFlags reads the flags registered by addSendFlags.
+func sendOptionsFromFlags(cmd *cobra.Command) (internal.SendCommandOptions, time.Duration, error) {
+	var opts internal.SendCommandOptions
+	opts.MaxConcurrency, _ = cmd.Flags().GetString("max-concurrency")
+	opts.MaxErrors, _ = cmd.Flags().GetString("max-errors")
+	opts.DeliveryTimeout, _ = cmd.Flags().GetDuration("delivery-timeout")
+	wait, _ := cmd.Flags().GetDuration("wait")
+	if wait < 0 {
+		return opts, 0, fmt.Errorf("invalid --wait %s (must not be negative)", wait)
+	}
+	return opts, wait, nil
+}
+
+// rolloutFromFlags reads --batch-size, --batch-pause and --health-cmd. A zero batch size
+// sends the command to every target at once.
+func rolloutFromFlags(cmd *cobra.Command) (internal.RolloutOptions, string, error) {
+	var opts internal.RolloutOptions
+	opts.BatchSize, _ = cmd.Flags().GetInt("batch-size")
+	opts.Pause, _ = cmd.Flags().GetDuration("batch-pause")
+	healthCmd, _ := cmd.Flags().GetString("health-cmd")
+	switch {
+	case opts.BatchSize < 0:
+		return opts, "", fmt.Errorf("invalid --batch-size %d (must not be negative)", opts.BatchSize)
+	case opts.Pause < 0:
+		return opts, "", fmt.Errorf("invalid --batch-pause %s (must not be negative)", opts.Pause)
+	case opts.BatchSize == 0 && (opts.Pause > 0 || healthCmd != ""):
+		return opts, "", fmt.Errorf("--batch-pause and --health-cmd apply between batches, so they need --batch-size")
+	}
+	return opts, healthCmd, nil
+}
+
+// commandEnvFromFlags reads --user, --workdir and --env.
+func commandEnvFromFlags(cmd *cobra.Command) (internal.CommandEnv, error) {
+	var env internal.CommandEnv
+	env.User, _ = cmd.Flags().GetString("user")
+	env.WorkDir, _ = cmd.Flags().GetString("workdir")
+	assignments, _ := cmd.Flags().GetStringArray("env")
+	vars, err := internal.ParseEnv(assignments)
+	if err != nil {
+		return env, fmt.Errorf("invalid --env: %w", err)
+	}
+	if len(vars) > 0 {
+		env.Env = vars
+	}
+	return env, nil
+}
+
+// scriptRunner sends commands to instances with a script document and watches them.
+type scriptRunner struct {
+	ssmClient *ssm.Client
+	document  string
+	env       internal.CommandEnv
+	sendOpts  internal.SendCommandOptions
+	wait      time.Duration
+	watchOpts internal.WatchOptions
+}
+
+// run sends command to targets and watches it until it finishes.
+func (r scriptRunner) run(ctx context.Context, targets []*internal.Target, command string) ([]internal.InvocationResult, error) {
+	params, err := r.env.Parameters(r.document, command)
+	if err != nil {
+		return nil, err
+	}
+	batches, sendErr := internal.SendCommand(ctx, r.ssmClient, targets, r.document, params, r.sendOpts)
+	if len(batches) == 0 {
+		return nil, sendErr
+	}
+	return watchCommand(ctx, r.ssmClient, targets, batches, internal.InvocationInputs(batches), sendErr, r.wait, r.watchOpts)
+}
+
+// watchCommand prints the invocations of the sent batches until they finish or wait expires.
+// It returns their results and the error that sets the exit code.
+func watchCommand(ctx context.Context, ssmClient internal.SSMCommandAPI, targets []*internal.Target, batches []internal.CommandBatch, inputs []*ssm.GetCommandInvocationInput, sendErr error, wait time.Duration, opts internal.WatchOptions) ([]internal.InvocationResult, error) {
+	signals, stop := notifyInterrupt()
+	defer stop()
+	return watchCommandSignals(ctx, signals, ssmClient, targets, batches, inputs, sendErr, wait, opts)
+}
+
+// runRollout runs internal.Rollout until the first signal, which stops it before the next
+// batch, also during --batch-pause. A batch already running is left to watchCommand,
+// which handles the same signal by cancelling its command.
+func runRollout(ctx context.Context, signals <-chan os.Signal, targets []*internal.Target, opts internal.RolloutOptions, run, health internal.RolloutStep) error {
+	rolloutCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	interrupted := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			close(interrupted)
+			cancel()
+		case <-rolloutCtx.Done():
+		}
+	}()
+
+	// the steps get ctx so that a batch is watched until its command is cancelled
+	withParent := func(step internal.RolloutStep) internal.RolloutStep {
+		if step == nil {
 			return nil
+		}
+		return func(_ context.Context, batch []*internal.Target) error {
+			return step(ctx, batch)
+		}
+	}
+	err := internal.Rollout(rolloutCtx, targets, opts, withParent(run), withParent(health))
+	select {
+	case <-interrupted:
+		var exitErr *exitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return &exitError{code: exitCodeInterrupted, err: err}
+		}
+	default:
+	}
+	return err
+}
+
+// notifyInterrupt returns a channel that receives Ctrl+C and SIGTERM until stop is called.
+func notifyInterrupt() (signals <-chan os.Signal, stop func()) {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	return c, func() { signal.Stop(c) }
+}
+
+// watchCommandSignals is watchCommand with the interrupts read from signals.
+func watchCommandSignals(ctx context.Context, signals <-chan os.Signal, ssmClient internal.SSMCommandAPI, targets []*internal.Target, batches []internal.CommandBatch, inputs []*ssm.GetCommandInvocationInput, sendErr error, wait time.Duration, opts internal.WatchOptions) ([]internal.InvocationResult, error) {
+	printBatches(batches)
+
+	// the first Ctrl+C cancels the commands, the second stops waiting for them
+	ctx, interrupted, stop := internal.CancelOnInterrupt(ctx, ssmClient, batchCommandIDs(batches), signals)
+	defer stop()
+
+	fmt.Printf("%s\n", color.YellowString("Waiting for response..."))
+
+	// Watch the invocations of every batch as one stream
+	opts.NameMap = make(map[string]string, len(targets))
+	for _, t := range targets {
+		opts.NameMap[t.Name] = t.TagName
+	}
+	waitCtx, cancel := ctx, context.CancelFunc(func() {})
+	if wait > 0 {
+		waitCtx, cancel = context.WithTimeout(ctx, wait)
+	}
+	defer cancel()
+	results := internal.PrintCommandInvocation(waitCtx, ssmClient, inputs, opts)
+	if errors.Is(context.Cause(ctx), internal.ErrInterrupted) {
+		return results, &exitError{code: exitCodeInterrupted, err: fmt.Errorf("interrupted; cancellation of %s may still be in progress", commandIDs(batches))}
+	}
+	if interrupted() {
+		return results, &exitError{code: exitCodeInterrupted, err: fmt.Errorf("interrupted; cancelled %s", commandIDs(batches))}
+	}
+	if waitCtx.Err() != nil {
+		return results, &exitError{code: exitCodeStillRunning, err: fmt.Errorf("stopped waiting after %s; the command keeps running (%s)", wait, commandIDs(batches))}
+	}
+	if sendErr != nil {
+		return results, sendErr
+	}
+	return results, invocationError(results)
+}
+
+// shellScriptWatchOptions returns how to watch an AWS-RunShellScript command: output longer
+// than SSM returns inline is read from CloudWatch Logs or bucket, and streamed with --follow.
+func shellScriptWatchOptions(cmd *cobra.Command, bucket string) internal.WatchOptions {
+	logsClient := cloudwatchlogs.NewFromConfig(*_credential.awsConfig)
+	opts := internal.WatchOptions{
+		FullOutput: &internal.FullOutput{
+			Logs:   logsClient,
+			S3:     s3.NewFromConfig(*_credential.awsConfig),
+			Bucket: bucket,
 		},
 	}
-)
+	if follow, _ := cmd.Flags().GetBool("follow"); follow {
+		opts.Follow = logsClient
+	}
+	return opts
+}
+
+// writeOutputDir writes results to the --output-dir, if one was given, and returns the
+// watch error err along with any error writing them.
+func writeOutputDir(dir string, results []internal.InvocationResult, err error) error {
+	if dir == "" {
+		return err
+	}
+	if writeErr := internal.WriteResults(dir, results); writeErr != nil {
+		return errors.Join(err, fmt.Errorf("cannot write results: %w", writeErr))
+	}
+	color.Green("[output] wrote the results of %d instance(s) to %s", len(results), dir)
+	return err
+}
+
+// printBatches shows the commands sent when the targets did not fit into a single
+// SendCommand by instance ID.
+func printBatches(batches []internal.CommandBatch) {
+	if len(batches) == 1 && batches[0].Selector == nil {
+		return
+	}
+	for _, b := range batches {
+		if b.Selector != nil {
+			fmt.Printf("%s command %s to %s (%d instance(s))\n", color.GreenString("[send]"), b.CommandID, b.Selector, len(b.InstanceIDs))
+			continue
+		}
+		fmt.Printf("%s command %s to %d instance(s)\n", color.GreenString("[send]"), b.CommandID, len(b.InstanceIDs))
+	}
+}
+
+// execCommandText returns the shell command to send and how to show it: the arguments
+// joined, or the --file script run with the arguments.
+func execCommandText(cmd *cobra.Command, args []string) (command, label string, err error) {
+	file, _ := cmd.Flags().GetString("file")
+	if file == "" {
+		command = strings.Join(args, " ")
+		return command, command, nil
+	}
+
+	var script []byte
+	if file == "-" {
+		script, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		script, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("cannot read script: %w", err)
+	}
+	command, err = internal.ScriptCommand(file, script, args)
+	if err != nil {
+		return "", "", err
+	}
+	return command, strings.Join(append([]string{filepath.Base(file)}, args...), " "), nil
+}
+
+// invocationExitCode returns the exit code for the results of a command. A single instance
+// exits with the remote exit code; several instances exit with exitCodeSomeFailed or
+// exitCodeAllFailed. Timeouts exit with exitCodeTimeout.
+func invocationExitCode(results []internal.InvocationResult) int {
+	failed, timedOut := 0, false
+	for _, r := range results {
+		if !r.Succeeded() {
+			failed++
+			timedOut = timedOut || r.TimedOut()
+		}
+	}
+	switch {
+	case failed == 0:
+		return 0
+	case timedOut:
+		return exitCodeTimeout
+	case len(results) == 1:
+		// exit codes are a single byte, so larger remote codes cannot be passed on
+		if code := results[0].ResponseCode; code > 0 && code < 256 {
+			return int(code)
+		}
+		return exitCodeSomeFailed
+	case failed == len(results):
+		return exitCodeAllFailed
+	default:
+		return exitCodeSomeFailed
+	}
+}
+
+// invocationError returns an exitError carrying invocationExitCode when the command did not
+// succeed everywhere, or nil.
+func invocationError(results []internal.InvocationResult) error {
+	code := invocationExitCode(results)
+	if code == 0 {
+		return nil
+	}
+	if len(results) == 1 {
+		r := results[0]
+		if r.Err != nil {
+			return &exitError{code: code, err: fmt.Errorf("command on %s: %w", r.InstanceID, r.Err)}
+		}
+		return &exitError{code: code, err: fmt.Errorf("command on %s: %s (exit code %d)", r.InstanceID, r.StatusDetails, r.ResponseCode)}
+	}
+	failed := 0
+	for _, r := range results {
+		if !r.Succeeded() {
+			failed++
+		}
+	}
+	return &exitError{code: code, err: fmt.Errorf("command did not succeed on %d of %d instances", failed, len(results))}
+}
+
+// commandIDs lists the command IDs of batches for looking them up later.
+func commandIDs(batches []internal.CommandBatch) string {
+	return "command ID " + strings.Join(batchCommandIDs(batches), ", ")
+}
+
+// batchCommandIDs returns the command ID of every batch.
+func batchCommandIDs(batches []internal.CommandBatch) []string {
+	ids := make([]string, 0, len(batches))
+	for _, b := range batches {
+		ids = append(ids, b.CommandID)
+	}
+	return ids
+}
+
+// checkConnected returns an error if any target is not connected to SSM.
+func checkConnected(ctx context.Context, ssmClient internal.SSMDescribeInstanceInfoAPI, targets []*internal.Target) error {
+	connectedInstances, err := internal.FindInstanceIdsWithConnectedSSM(ctx, ssmClient)
+	if err != nil {
+		return err
+	}
+	connSet := make(map[string]struct{}, len(connectedInstances))
+	for _, id := range connectedInstances {
+		connSet[id] = struct{}{}
+	}
+	for _, t := range targets {
+		if _, ok := connSet[t.Name]; !ok {
+			return fmt.Errorf("instance %s is not connected to SSM.\nPossible causes:\n  - SSM agent is not running on the instance\n  - Instance lacks IAM permissions (AmazonSSMManagedInstanceCore)\n  - Network connectivity issues\n\nRun 'gossm doctor %s' to diagnose, 'gossm list' to see available instances, or use --skip-check to bypass this validation", t.Name, t.Name)
+		}
+	}
+	return nil
+}
+
+// ensureRunning starts stopped targets when --start-if-stopped is set,
+// otherwise it rejects targets known to be stopped.
+func ensureRunning(ctx context.Context, cmd *cobra.Command, ec2Client internal.EC2StartInstancesAPI, ssmClient internal.SSMDescribeInstanceInfoAPI, targets []*internal.Target) error {
+	startIfStopped, _ := cmd.Flags().GetBool("start-if-stopped")
+	if !startIfStopped {
+		for _, t := range targets {
+			if t.State == string(ec2_types.InstanceStateNameStopped) {
+				return fmt.Errorf("instance %s is stopped; use --start-if-stopped to start it before connecting", t.Name)
+			}
+		}
+		return nil
+	}
+
+	timeout, _ := cmd.Flags().GetDuration("start-timeout")
+	ids := make([]string, 0, len(targets))
+	for _, t := range targets {
+		ids = append(ids, t.Name)
+	}
+	if _, err := internal.StartStoppedInstances(ctx, ec2Client, ssmClient, ids, timeout); err != nil {
+		return err
+	}
+	for _, t := range targets {
+		if t.State != "" {
+			t.State = string(ec2_types.InstanceStateNameRunning)
+		}
+	}
+	return nil
+}
+
+// addSendFlags registers the flags that control how a command is sent and watched.
+func addSendFlags(cmd *cobra.Command) {
+	cmd.Flags().String("max-concurrency", "", "[optional] maximum instances running the command at once, as a count or percentage (e.g. 10 or 25%)")
+	cmd.Flags().String("max-errors", "", "[optional] stop sending the command after this many errors, as a count or percentage (e.g. 0 or 10%)")
+	cmd.Flags().Duration("delivery-timeout", time.Minute, "[optional] how long SSM tries to deliver the command to an instance (30s to 720h)")
+	cmd.Flags().Duration("wait", 0, "[optional] stop waiting for results after this long and exit with code 75 (default wait until done)")
+}
+
+// addStartFlags registers the flags for selecting and starting stopped instances.
+func addStartFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("include-stopped", false, "[optional] also offer stopped instances in the interactive picker")
+	cmd.Flags().Bool("start-if-stopped", false, "[optional] start stopped targets and wait for the SSM agent before connecting")
+	cmd.Flags().Duration("start-timeout", 5*time.Minute, "[optional] how long to wait for started instances to become reachable")
+}
+
+// addRolloutFlags registers the flags read by rolloutFromFlags.
+func addRolloutFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("batch-size", 0, "[optional] roll out to this many instances at a time, stopping at the first failed batch")
+	cmd.Flags().Duration("batch-pause", 0, "[optional] wait this long after each healthy batch before the next one")
+	cmd.Flags().String("health-cmd", "", "[optional] run this command on each batch after the command; a failure stops the rollout")
+}
+
+// addCommandEnvFlags registers the flags read by commandEnvFromFlags.
+func addCommandEnvFlags(cmd *cobra.Command) {
+	cmd.Flags().String("user", "", "[optional] run the command as this user with sudo (Linux only)")
+	cmd.Flags().String("workdir", "", "[optional] run the command in this directory on the instances")
+	cmd.Flags().StringArray("env", nil, "[optional] set an environment variable for the command, as NAME=value (repeatable)")
+}
 
 func init() {
-	execCommand.Flags().StringSliceP("target", "t", nil, "target instance ID (repeatable)")
+	execCommand.Flags().StringArrayP("target", "t", nil, "target instance ID, alias, @group, tag:Key=Value selector or field=value filter (repeatable)")
+	execCommand.RegisterFlagCompletionFunc("target", completeTargets)
+	addGroupFlags(execCommand)
+	execCommand.Flags().Bool("online-only", false, "[optional] only offer instances whose SSM agent ping status is Online")
+	addStartFlags(execCommand)
+	addSendFlags(execCommand)
+	execCommand.Flags().Duration("execution-timeout", 0, "[optional] how long the script may run once delivered, up to 48h (default 1h)")
+	execCommand.Flags().String("file", "", "[optional] run this local script (- for stdin) instead of a command; arguments are passed to it")
+	execCommand.Flags().Bool("follow", false, "[optional] stream output from CloudWatch Logs while the command runs")
+	addRolloutFlags(execCommand)
+	addCommandEnvFlags(execCommand)
+	execCommand.Flags().Bool("group", false, "[optional] print each distinct output once, with the instances that produced it")
+	execCommand.Flags().Bool("diff", false, "[optional] like --group, but show how outputs differ from the most common one")
+	execCommand.Flags().String("output-dir", "", "[optional] write each instance's stdout and stderr and a summary.json to this directory")
+	execCommand.Flags().String("output-s3-bucket", "", "[optional] also store the output in this S3 bucket and read long output from it")
 	execCommand.Flags().Bool("skip-check", false, "[optional] skip SSM connectivity check before executing")
 	viper.BindPFlag("exec-skip-check", execCommand.Flags().Lookup("skip-check"))
 
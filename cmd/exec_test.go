@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tommy-cxcpwz/gossm/internal"
+)
+
+func invocationResult(status ssm_types.CommandInvocationStatus, code int32) internal.InvocationResult {
+	return internal.InvocationResult{InstanceID: "i-0aaaaaaaa", Status: status, StatusDetails: string(status), ResponseCode: code}
+}
+
+func TestInvocationExitCode(t *testing.T) {
+	var (
+		success  = invocationResult(ssm_types.CommandInvocationStatusSuccess, 0)
+		failed   = invocationResult(ssm_types.CommandInvocationStatusFailed, 3)
+		timedOut = invocationResult(ssm_types.CommandInvocationStatusTimedOut, -1)
+		stopped  = internal.InvocationResult{InstanceID: "i-0bbbbbbbb", ResponseCode: -1, Err: context.DeadlineExceeded}
+	)
+
+	tests := []struct {
+		name    string
+		results []internal.InvocationResult
+		want    int
+	}{
+		{name: "no results", results: nil, want: 0},
+		{name: "single success", results: []internal.InvocationResult{success}, want: 0},
+		{name: "single remote code", results: []internal.InvocationResult{failed}, want: 3},
+		{name: "single code out of range", results: []internal.InvocationResult{invocationResult(ssm_types.CommandInvocationStatusFailed, 300)}, want: exitCodeSomeFailed},
+		{name: "single undeliverable", results: []internal.InvocationResult{invocationResult(ssm_types.CommandInvocationStatusFailed, -1)}, want: exitCodeSomeFailed},
+		{name: "single timed out", results: []internal.InvocationResult{timedOut}, want: exitCodeTimeout},
+		{name: "all succeeded", results: []internal.InvocationResult{success, success}, want: 0},
+		{name: "some failed", results: []internal.InvocationResult{success, failed}, want: exitCodeSomeFailed},
+		{name: "all failed", results: []internal.InvocationResult{failed, failed}, want: exitCodeAllFailed},
+		{name: "any timed out", results: []internal.InvocationResult{failed, success, stopped}, want: exitCodeTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, invocationExitCode(tt.results))
+		})
+	}
+}
+
+func TestInvocationError_Failures_CarryExitCode(t *testing.T) {
+	single := invocationError([]internal.InvocationResult{invocationResult(ssm_types.CommandInvocationStatusFailed, 3)})
+	several := invocationError([]internal.InvocationResult{
+		invocationResult(ssm_types.CommandInvocationStatusSuccess, 0),
+		invocationResult(ssm_types.CommandInvocationStatusFailed, 1),
+	})
+
+	var exitErr *exitError
+	require.True(t, errors.As(single, &exitErr))
+	assert.Equal(t, 3, exitErr.code)
+	assert.Contains(t, single.Error(), "i-0aaaaaaaa")
+	require.True(t, errors.As(several, &exitErr))
+	assert.Equal(t, exitCodeSomeFailed, exitErr.code)
+	assert.Contains(t, several.Error(), "1 of 2 instances")
+	assert.NoError(t, invocationError([]internal.InvocationResult{invocationResult(ssm_types.CommandInvocationStatusSuccess, 0)}))
+}
+
+func TestExecCommandText(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "deploy.sh")
+	require.NoError(t, os.WriteFile(script, []byte("echo deploy\n"), 0644))
+
+	plain, plainLabel, err := execCommandText(execCommand, []string{"uptime", "-p"})
+	require.NoError(t, err)
+	require.NoError(t, execCommand.Flags().Set("file", script))
+	defer execCommand.Flags().Set("file", "")
+	command, label, err := execCommandText(execCommand, []string{"v1", "two words"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "uptime -p", plain)
+	assert.Equal(t, plain, plainLabel)
+	assert.Equal(t, "deploy.sh v1 two words", label)
+	assert.Contains(t, command, "base64 -d")
+	assert.Contains(t, command, `"$f" v1 'two words'`)
+}
+
+func TestRolloutFromFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    internal.RolloutOptions
+		health  string
+		wantErr string
+	}{
+		{name: "no rollout"},
+		{
+			name:   "batches",
+			args:   []string{"--batch-size", "5", "--batch-pause", "30s", "--health-cmd", "curl -f localhost"},
+			want:   internal.RolloutOptions{BatchSize: 5, Pause: 30 * time.Second},
+			health: "curl -f localhost",
+		},
+		{name: "negative size", args: []string{"--batch-size", "-1"}, wantErr: "invalid --batch-size"},
+		{name: "health without batches", args: []string{"--health-cmd", "true"}, wantErr: "need --batch-size"},
+		{name: "pause without batches", args: []string{"--batch-pause", "1m"}, wantErr: "need --batch-size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			addRolloutFlags(cmd)
+			require.NoError(t, cmd.ParseFlags(tt.args))
+
+			opts, health, err := rolloutFromFlags(cmd)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, opts)
+			assert.Equal(t, tt.health, health)
+		})
+	}
+}
+
+func TestCommandEnvFromFlags(t *testing.T) {
+	cmd := &cobra.Command{}
+	addCommandEnvFlags(cmd)
+	require.NoError(t, cmd.ParseFlags([]string{"--user", "app", "--workdir", "/srv/app", "--env", "A=1", "--env", "B=two words"}))
+
+	env, err := commandEnvFromFlags(cmd)
+	require.NoError(t, err)
+	assert.Equal(t, internal.CommandEnv{User: "app", WorkDir: "/srv/app", Env: map[string]string{"A": "1", "B": "two words"}}, env)
+
+	cmd = &cobra.Command{}
+	addCommandEnvFlags(cmd)
+	require.NoError(t, cmd.ParseFlags([]string{"--env", "NOVALUE"}))
+	_, err = commandEnvFromFlags(cmd)
+	assert.ErrorContains(t, err, "invalid --env")
+}
+
+// runningCommands is an SSM client whose invocations keep running until cancelled.
+type runningCommands struct {
+	mu        sync.Mutex
+	cancelled bool
+}
+
+func (c *runningCommands) status() ssm_types.CommandInvocationStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancelled {
+		return ssm_types.CommandInvocationStatusCancelled
+	}
+	return ssm_types.CommandInvocationStatusInProgress
+}
+
+func (c *runningCommands) SendCommand(ctx context.Context, params *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *runningCommands) GetCommandInvocation(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+	status := c.status()
+	return &ssm.GetCommandInvocationOutput{
+		CommandId:     params.CommandId,
+		InstanceId:    params.InstanceId,
+		Status:        status,
+		StatusDetails: aws.String(string(status)),
+		ResponseCode:  -1,
+	}, nil
+}
+
+func (c *runningCommands) ListCommandInvocations(ctx context.Context, params *ssm.ListCommandInvocationsInput, optFns ...func(*ssm.Options)) (*ssm.ListCommandInvocationsOutput, error) {
+	status := c.status()
+	return &ssm.ListCommandInvocationsOutput{CommandInvocations: []ssm_types.CommandInvocation{
+		{CommandId: params.CommandId, InstanceId: aws.String("i-0aaaaaaaa"), Status: status, StatusDetails: aws.String(string(status))},
+	}}, nil
+}
+
+func (c *runningCommands) CancelCommand(ctx context.Context, params *ssm.CancelCommandInput, optFns ...func(*ssm.Options)) (*ssm.CancelCommandOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancelled = true
+	return &ssm.CancelCommandOutput{}, nil
+}
+
+// watchRunning watches a command on one instance of client, with interrupts read from signals.
+func watchRunning(client *runningCommands, signals <-chan os.Signal, wait time.Duration) error {
+	targets := []*internal.Target{{Name: "i-0aaaaaaaa"}}
+	batches := []internal.CommandBatch{{CommandID: "cmd-1", InstanceIDs: []string{"i-0aaaaaaaa"}}}
+	_, err := watchCommandSignals(context.Background(), signals, client, targets, batches, internal.InvocationInputs(batches), nil, wait, internal.WatchOptions{})
+	return err
+}
+
+func TestWatchCommand_WaitExpired_ExitsStillRunning(t *testing.T) {
+	err := watchRunning(&runningCommands{}, make(chan os.Signal), 50*time.Millisecond)
+
+	var exitErr *exitError
+	require.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, exitCodeStillRunning, exitErr.code)
+	assert.NotEqual(t, exitCodeTimeout, exitErr.code)
+	assert.Contains(t, err.Error(), "keeps running (command ID cmd-1)")
+}
+
+func TestWatchCommand_InterruptedOnce_ExitsInterrupted(t *testing.T) {
+	client := &runningCommands{}
+	signals := make(chan os.Signal, 1)
+	signals <- os.Interrupt
+
+	err := watchRunning(client, signals, 0)
+
+	var exitErr *exitError
+	require.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, exitCodeInterrupted, exitErr.code)
+	assert.Equal(t, ssm_types.CommandInvocationStatusCancelled, client.status())
+}
+
+func TestRunRollout_InterruptedDuringPause_ExitsInterrupted(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	targets := []*internal.Target{{Name: "i-1"}, {Name: "i-2"}, {Name: "i-3"}}
+	var ran []string
+	run := func(ctx context.Context, batch []*internal.Target) error {
+		require.NoError(t, ctx.Err(), "batches run with the caller's context")
+		ran = append(ran, batch[0].Name)
+		signals <- os.Interrupt // Ctrl+C once the batch is done, while the rollout pauses
+		return nil
+	}
+
+	err := runRollout(context.Background(), signals, targets, internal.RolloutOptions{BatchSize: 1, Pause: time.Hour}, run, nil)
+
+	var exitErr *exitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, exitCodeInterrupted, exitErr.code)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "rollout aborted before batch 2/3")
+	assert.Equal(t, []string{"i-1"}, ran)
+}
+
+func TestRunRollout_NotInterrupted_ReturnsRolloutError(t *testing.T) {
+	failed := errors.New("failed")
+	run := func(ctx context.Context, batch []*internal.Target) error { return failed }
+
+	err := runRollout(context.Background(), make(chan os.Signal), []*internal.Target{{Name: "i-1"}}, internal.RolloutOptions{BatchSize: 1}, run, nil)
+
+	var exitErr *exitError
+	assert.False(t, errors.As(err, &exitErr))
+	assert.ErrorIs(t, err, failed)
+}
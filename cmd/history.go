@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/tommy-cxcpwz/gossm/internal"
+)
+
+// maxHistoryCommandWidth is how much of a command's text the history table shows.
+const maxHistoryCommandWidth = 60
+
+var (
+	execHistoryCommand = &cobra.Command{
+		Use:   "history",
+		Short: "List recent commands sent with exec and run-document",
+		Long: `List recent commands of the account and region, most recent first.
+
+SSM does not record who sent a command, so gossm marks the commands it sends with
+the caller's identity; --mine lists only the commands sent by your identity.
+SSM keeps command history for 30 days.
+
+Examples:
+  gossm exec history
+  gossm exec history --mine --since 24h
+  gossm exec history --limit 100`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			opts := internal.HistoryOptions{}
+			opts.Limit, _ = cmd.Flags().GetInt("limit")
+			if opts.Limit < 0 {
+				return fmt.Errorf("invalid --limit %d (must not be negative)", opts.Limit)
+			}
+			if since, _ := cmd.Flags().GetDuration("since"); since > 0 {
+				opts.Since = time.Now().Add(-since)
+			}
+			if mine, _ := cmd.Flags().GetBool("mine"); mine {
+				comment, err := internal.IssuerComment(ctx, sts.NewFromConfig(*_credential.awsConfig))
+				if err != nil {
+					return fmt.Errorf("cannot look up your identity: %w", err)
+				}
+				opts.Comment = comment
+			}
+
+			commands, err := internal.ListCommandHistory(ctx, ssm.NewFromConfig(*_credential.awsConfig), opts)
+			if err != nil {
+				return err
+			}
+			if len(commands) == 0 {
+				color.Yellow("no commands found")
+				return nil
+			}
+			return printCommandHistory(cmd.OutOrStdout(), commands)
+		},
+	}
+
+	execShowCommand = &cobra.Command{
+		Use:   "show <command-id>",
+		Short: "Show the per-instance results of an earlier command",
+		Long: `Show the output and status of an earlier command on every instance it was sent
+to, as exec shows them. Instances where the command is still running are watched
+until it finishes there. The exit code is set as exec sets it.
+
+Examples:
+  gossm exec show 0b1c2d3e-4f5a-6b7c-8d9e-0f1a2b3c4d5e`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			ssmClient := ssm.NewFromConfig(*_credential.awsConfig)
+			commandID := args[0]
+
+			// the output bucket the command was sent with, to read truncated output from
+			command, err := internal.LookupCommand(ctx, ssmClient, commandID)
+			if err != nil {
+				return err
+			}
+			inputs, document, err := internal.CommandInvocationInputs(ctx, ssmClient, commandID)
+			if err != nil {
+				return err
+			}
+			if len(inputs) == 0 {
+				return fmt.Errorf("command %s has no invocations; it may be unknown in %s or older than 30 days", commandID, _credential.awsConfig.Region)
+			}
+
+			var ids []string
+			for _, input := range inputs {
+				id := aws.ToString(input.InstanceId)
+				if len(ids) == 0 || ids[len(ids)-1] != id {
+					ids = append(ids, id)
+				}
+			}
+			names, err := internal.InstanceNames(ctx, ec2.NewFromConfig(*_credential.awsConfig), ids)
+			if err != nil {
+				internal.DebugLog("cannot look up instance names: %v", err)
+			}
+
+			fmt.Printf("%s command %s on %d instance(s)\n", color.GreenString("[show]"), commandID, len(ids))
+			results := internal.PrintCommandInvocation(ctx, ssmClient, inputs, internal.WatchOptions{
+				NameMap: names,
+				FullOutput: &internal.FullOutput{
+					Logs:     cloudwatchlogs.NewFromConfig(*_credential.awsConfig),
+					S3:       s3.NewFromConfig(*_credential.awsConfig),
+					Bucket:   aws.ToString(command.OutputS3BucketName),
+					Document: document,
+				},
+			})
+			return invocationError(results)
+		},
+	}
+
+	execRetryCommand = &cobra.Command{
+		Use:   "retry <command-id>... [-- command]",
+		Short: "Resend an earlier command to the instances where it did not succeed",
+		Long: `Resend earlier commands to only the instances where they failed, timed out or
+could not be delivered. The commands are resent with their original document,
+parameters and send options; send flags given here override the options.
+
+exec sends commands to more than 50 instances in batches, each with its own
+command ID; give all of them to retry every batch. A command after -- replaces
+the original one, for AWS-RunShellScript commands.
+
+Examples:
+  gossm exec retry 0b1c2d3e-4f5a-6b7c-8d9e-0f1a2b3c4d5e
+  gossm exec retry 0b1c2d3e-4f5a-6b7c-8d9e-0f1a2b3c4d5e 1c2d3e4f-5a6b-7c8d-9e0f-1a2b3c4d5e6f
+  gossm exec retry 0b1c2d3e-4f5a-6b7c-8d9e-0f1a2b3c4d5e --max-concurrency 1 -- "systemctl restart app"`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if ids, _ := retryArgs(cmd, args); len(ids) == 0 {
+				return fmt.Errorf("requires at least 1 command ID")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			ssmClient := ssm.NewFromConfig(*_credential.awsConfig)
+			commandIDs, command := retryArgs(cmd, args)
+
+			plan, err := internal.PlanRetry(ctx, ssmClient, commandIDs)
+			if err != nil {
+				return err
+			}
+			label := plan.Document
+			if plan.Document == internal.ShellScriptDocument {
+				label = shortCommandText(strings.Join(plan.Parameters["commands"], "; "))
+			}
+			if command != "" {
+				if plan.Document != internal.ShellScriptDocument {
+					return fmt.Errorf("cannot replace the command of %s; only %s commands can be changed", plan.Document, internal.ShellScriptDocument)
+				}
+				plan.Parameters["commands"] = []string{command}
+				label = command
+			}
+			if len(plan.InstanceIDs) == 0 {
+				color.Green("[retry] no instance failed; nothing to retry")
+				return nil
+			}
+
+			sendOpts, wait, err := retrySendOptions(cmd, plan.Options)
+			if err != nil {
+				return err
+			}
+			sendOpts.Comment = issuerComment(ctx)
+			outputDir, _ := cmd.Flags().GetString("output-dir")
+
+			names, err := internal.InstanceNames(ctx, ec2.NewFromConfig(*_credential.awsConfig), plan.InstanceIDs)
+			if err != nil {
+				internal.DebugLog("cannot look up instance names: %v", err)
+			}
+			targets := make([]*internal.Target, 0, len(plan.InstanceIDs))
+			for _, id := range plan.InstanceIDs {
+				targets = append(targets, &internal.Target{Name: id, TagName: names[id]})
+			}
+			internal.PrintReadyMulti("retry "+label, _credential.awsConfig.Region, targets)
+
+			batches, sendErr := internal.SendCommand(ctx, ssmClient, targets, plan.Document, plan.Parameters, sendOpts)
+			if len(batches) == 0 {
+				return sendErr
+			}
+			inputs := internal.StepInvocationInputs(internal.InvocationInputs(batches), plan.Steps)
+			watchOpts := shellScriptWatchOptions(cmd, sendOpts.OutputS3Bucket)
+			watchOpts.FullOutput.Document = plan.Document
+			if plan.Document != internal.ShellScriptDocument {
+				// --follow streams AWS-RunShellScript output only
+				watchOpts.Follow = nil
+			}
+			results, err := watchCommand(ctx, ssmClient, targets, batches, inputs, sendErr, wait, watchOpts)
+			return writeOutputDir(outputDir, results, err)
+		},
+	}
+)
+
+// retryArgs splits the arguments of exec retry into command IDs and the replacement
+// command given after --, if any.
+func retryArgs(cmd *cobra.Command, args []string) (commandIDs []string, command string) {
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		return args[:dash], strings.Join(args[dash:], " ")
+	}
+	return args, ""
+}
+
+// retrySendOptions returns the send options of the original commands, overridden by the
+// send flags that were given.
+func retrySendOptions(cmd *cobra.Command, original internal.SendCommandOptions) (internal.SendCommandOptions, time.Duration, error) {
+	flagOpts, wait, err := sendOptionsFromFlags(cmd)
+	if err != nil {
+		return original, 0, err
+	}
+	opts := original
+	if cmd.Flags().Changed("max-concurrency") {
+		opts.MaxConcurrency = flagOpts.MaxConcurrency
+	}
+	if cmd.Flags().Changed("max-errors") {
+		opts.MaxErrors = flagOpts.MaxErrors
+	}
+	if cmd.Flags().Changed("delivery-timeout") {
+		opts.DeliveryTimeout = flagOpts.DeliveryTimeout
+	}
+	return opts, wait, opts.Validate()
+}
+
+// issuerComment returns the comment that marks the commands we send as ours, or "" when
+// the caller's identity cannot be looked up.
+func issuerComment(ctx context.Context) string {
+	comment, err := internal.IssuerComment(ctx, sts.NewFromConfig(*_credential.awsConfig))
+	if err != nil {
+		internal.DebugLog("cannot look up caller identity for the command comment: %v", err)
+		return ""
+	}
+	return comment
+}
+
+// printCommandHistory prints commands as a table.
+func printCommandHistory(out io.Writer, commands []ssm_types.Command) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, color.CyanString("COMMAND ID\tREQUESTED\tSTATUS\tDONE\tERRORS\tCOMMAND"))
+	fmt.Fprintln(w, color.CyanString("----------\t---------\t------\t----\t------\t-------"))
+	for _, c := range commands {
+		requested := "-"
+		if c.RequestedDateTime != nil {
+			requested = c.RequestedDateTime.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%d\t%s\n", aws.ToString(c.CommandId), requested, c.Status, c.CompletedCount, c.TargetCount, c.ErrorCount, shortCommandText(internal.CommandText(c)))
+	}
+	return w.Flush()
+}
+
+// shortCommandText returns the text of a command on one line, cut to maxHistoryCommandWidth.
+func shortCommandText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxHistoryCommandWidth {
+		text = string(runes[:maxHistoryCommandWidth-3]) + "..."
+	}
+	return text
+}
+
+func init() {
+	execHistoryCommand.Flags().Bool("mine", false, "[optional] list only the commands sent by your identity")
+	execHistoryCommand.Flags().Duration("since", 0, "[optional] list only the commands sent within this duration, such as 24h")
+	execHistoryCommand.Flags().Int("limit", 20, "[optional] the most commands listed (0 lists all)")
+
+	addSendFlags(execRetryCommand)
+	execRetryCommand.Flags().Bool("follow", false, "[optional] stream output from CloudWatch Logs while the command runs")
+	execRetryCommand.Flags().String("output-dir", "", "[optional] write each instance's stdout and stderr and a summary.json to this directory")
+
+	execCommand.AddCommand(execHistoryCommand)
+	execCommand.AddCommand(execShowCommand)
+	execCommand.AddCommand(execRetryCommand)
+}
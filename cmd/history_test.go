@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tommy-cxcpwz/gossm/internal"
+)
+
+// parsedRetryCommand returns a command with the exec retry flags parsed from args.
+func parsedRetryCommand(t *testing.T, args ...string) (*cobra.Command, []string) {
+	cmd := &cobra.Command{}
+	addSendFlags(cmd)
+	require.NoError(t, cmd.ParseFlags(args))
+	return cmd, cmd.Flags().Args()
+}
+
+func TestRetryArgs(t *testing.T) {
+	cmd, args := parsedRetryCommand(t, "c1", "c2")
+	ids, command := retryArgs(cmd, args)
+	assert.Equal(t, []string{"c1", "c2"}, ids)
+	assert.Empty(t, command)
+
+	cmd, args = parsedRetryCommand(t, "c1", "--", "systemctl", "restart", "app")
+	ids, command = retryArgs(cmd, args)
+	assert.Equal(t, []string{"c1"}, ids)
+	assert.Equal(t, "systemctl restart app", command)
+}
+
+func TestRetrySendOptions_FlagsOverrideOriginal(t *testing.T) {
+	original := internal.SendCommandOptions{MaxConcurrency: "50", MaxErrors: "0", DeliveryTimeout: 10 * time.Minute, OutputS3Bucket: "logs"}
+
+	cmd, _ := parsedRetryCommand(t)
+	opts, wait, err := retrySendOptions(cmd, original)
+	require.NoError(t, err)
+	assert.Equal(t, original, opts)
+	assert.Zero(t, wait)
+
+	cmd, _ = parsedRetryCommand(t, "--max-errors", "2", "--delivery-timeout", "2m", "--wait", "5m")
+	opts, wait, err = retrySendOptions(cmd, original)
+	require.NoError(t, err)
+	assert.Equal(t, internal.SendCommandOptions{MaxConcurrency: "50", MaxErrors: "2", DeliveryTimeout: 2 * time.Minute, OutputS3Bucket: "logs"}, opts)
+	assert.Equal(t, 5*time.Minute, wait)
+
+	cmd, _ = parsedRetryCommand(t, "--max-concurrency", "0")
+	_, _, err = retrySendOptions(cmd, original)
+	assert.Error(t, err)
+}
+
+func TestShortCommandText(t *testing.T) {
+	assert.Equal(t, "cd /app && ./deploy.sh", shortCommandText("cd /app &&\n  ./deploy.sh"))
+
+	long := shortCommandText(strings.Repeat("x", 100))
+	assert.Len(t, long, maxHistoryCommandWidth)
+	assert.True(t, strings.HasSuffix(long, "..."))
+}
+
+func TestExecCommand_RoutesSubcommandsBeforeRemoteCommand(t *testing.T) {
+	for _, name := range []string{"history", "show", "retry"} {
+		sub, _, err := rootCmd.Find([]string{"exec", name})
+		require.NoError(t, err)
+		assert.Equal(t, name, sub.Name())
+	}
+
+	// after -- and for other names, the arguments are the remote command
+	for _, args := range [][]string{
+		{"exec", "-t", "i-0aaaaaaaa", "--", "history"},
+		{"exec", "-t", "i-0aaaaaaaa", "uptime"},
+	} {
+		sub, _, err := rootCmd.Find(args)
+		require.NoError(t, err)
+		assert.Equal(t, execCommand, sub, args)
+	}
+}
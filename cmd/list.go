@@ -5,8 +5,9 @@ import (
 	"fmt"
 	"os"
 	"sort"
-	"text/tabwriter"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/fatih/color"
@@ -19,21 +20,84 @@ var (
 	listCommand = &cobra.Command{
 		Use:   "list",
 		Short: "List all available instances that can be connected via SSM",
-		Long:  "List all available instances that can be connected via SSM",
+		Long: `List all available instances that can be connected via SSM.
+
+Use -o/--output to choose the format:
+  table      aligned table (default)
+  wide       table with type, AZ, IPs, state, launch time and SSM agent columns
+  json, yaml machine-readable records with fields named after Target
+  csv, tsv   delimited rows with a header of column names
+  template   Go template per instance, e.g. -o template='{{.Name}} {{.TagName}}'
+
+Use --columns to choose the columns of the table, wide, csv and tsv formats, and
+--sort-by to order instances by any column, e.g. --sort-by launch-time.
+Columns: ` + strings.Join(columnNames(), ", ") + `
+
+Use --filter to keep only matching instances. A filter is a comma-separated list of
+tag:Key=Value and field=value terms that must all match, e.g.
+--filter instance-type=t3.micro,az=us-east-1a. Repeated filters must all match too.
+Fields: ` + strings.Join(internal.SelectorFieldNames(), ", ") + `
+
+Use --asg and --nodegroup to list the InService members of Auto Scaling groups
+or EKS node groups. Repeated groups are combined.
+
+Colors are disabled for machine-readable formats and when stdout is not a terminal.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := context.Background()
 			ssmClient := ssm.NewFromConfig(*_credential.awsConfig)
 			ec2Client := ec2.NewFromConfig(*_credential.awsConfig)
 			showTags, _ := cmd.Flags().GetBool("show-tags")
+			showAgent, _ := cmd.Flags().GetBool("show-agent")
+			outputFlag, _ := cmd.Flags().GetString("output")
+			columns, _ := cmd.Flags().GetStringSlice("columns")
+			sortFlag, _ := cmd.Flags().GetString("sort-by")
+			filterFlags, _ := cmd.Flags().GetStringArray("filter")
 
-			table, err := internal.FindInstances(ctx, ssmClient, ec2Client)
+			output, err := parseListOutput(outputFlag, showTags, showAgent)
 			if err != nil {
 				return err
 			}
+			if cmd.Flags().Changed("columns") {
+				if err := output.setColumns(columns); err != nil {
+					return err
+				}
+			}
+			filters := make([]*internal.Selector, 0, len(filterFlags))
+			for _, f := range filterFlags {
+				sel, err := internal.ParseSelector(f)
+				if err != nil {
+					return err
+				}
+				filters = append(filters, sel)
+			}
+			groups := make([]*internal.Selector, 0)
+			for _, ref := range groupRefs(cmd) {
+				sel, err := internal.ParseSelector(ref)
+				if err != nil {
+					return err
+				}
+				groups = append(groups, sel)
+			}
+			var sortBy *listColumn
+			if sortFlag != "" {
+				c, err := sortColumn(sortFlag)
+				if err != nil {
+					return err
+				}
+				sortBy = &c
+			}
+			if output.machineReadable() {
+				color.NoColor = true
+			}
 
-			if len(table) == 0 {
-				color.Yellow("No instances found with SSM agent connected.")
-				return nil
+			findOpts := findOptionsFromFlags(cmd)
+			table, err := internal.FindInstances(ctx, ssmClient, ec2Client, findOpts)
+			if err != nil {
+				return err
+			}
+			// completion takes the cache for every instance, so a filtered listing is not saved
+			if findOpts == (internal.FindOptions{}) {
+				saveInstanceCache(table)
 			}
 
 			// Sort keys for consistent output
@@ -42,35 +106,34 @@ var (
 				keys = append(keys, k)
 			}
 			sort.Strings(keys)
-
-			if showTags {
-				// Print header
-				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-				fmt.Fprintln(w, color.CyanString("NAME\tINSTANCE ID\tPRIVATE DNS\tPUBLIC DNS\tTAGS"))
-				fmt.Fprintln(w, color.CyanString("----\t-----------\t-----------\t----------\t----"))
-				w.Flush()
-
-				for _, k := range keys {
-					t := table[k]
-					name, privateDNS, publicDNS := formatFields(t)
-
-					fmt.Printf("%s  %s  %s  %s\n", name, t.Name, privateDNS, publicDNS)
-					fmt.Printf("%s\n\n", internal.FormatTags(t.Tags))
+			targets := make([]*internal.Target, 0, len(keys))
+			for _, k := range keys {
+				if matchesAll(filters, table[k]) && matchesAny(groups, table[k]) {
+					targets = append(targets, table[k])
 				}
-			} else {
-				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-				fmt.Fprintln(w, color.CyanString("NAME\tINSTANCE ID\tPRIVATE DNS\tPUBLIC DNS"))
-				fmt.Fprintln(w, color.CyanString("----\t-----------\t-----------\t----------"))
-
-				for _, k := range keys {
-					t := table[k]
-					name, privateDNS, publicDNS := formatFields(t)
-					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, t.Name, privateDNS, publicDNS)
+			}
+			if len(groups) > 0 {
+				asgClient := autoscaling.NewFromConfig(*_credential.awsConfig)
+				if targets, err = internal.FilterInService(ctx, asgClient, targets); err != nil {
+					return err
 				}
-				w.Flush()
+			}
+			if sortBy != nil {
+				sortTargets(targets, *sortBy)
+			}
+
+			if len(targets) == 0 && !output.machineReadable() {
+				color.Yellow("No instances found with SSM agent connected.")
+				return nil
+			}
+
+			if err := output.render(os.Stdout, targets); err != nil {
+				return err
 			}
 
-			fmt.Printf("\n%s %d instance(s) found\n", color.GreenString("[OK]"), len(table))
+			if !output.machineReadable() {
+				fmt.Printf("\n%s %d instance(s) found\n", color.GreenString("[OK]"), len(targets))
+			}
 			return nil
 		},
 	}
@@ -92,7 +155,59 @@ func formatFields(t *internal.Target) (name, privateDNS, publicDNS string) {
 	return
 }
 
+// findOptionsFromFlags builds instance discovery options from the command's flags.
+// --start-if-stopped also finds stopped instances, so that aliases and selectors match them.
+func findOptionsFromFlags(cmd *cobra.Command) internal.FindOptions {
+	onlineOnly, _ := cmd.Flags().GetBool("online-only")
+	includeStopped, _ := cmd.Flags().GetBool("include-stopped")
+	startIfStopped, _ := cmd.Flags().GetBool("start-if-stopped")
+	return internal.FindOptions{OnlineOnly: onlineOnly, IncludeStopped: includeStopped || startIfStopped}
+}
+
+// matchesAll reports whether the target matches every selector.
+func matchesAll(selectors []*internal.Selector, t *internal.Target) bool {
+	for _, sel := range selectors {
+		if !sel.Match(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAny reports whether the target matches one of the selectors, or there are none.
+func matchesAny(selectors []*internal.Selector, t *internal.Target) bool {
+	for _, sel := range selectors {
+		if sel.Match(t) {
+			return true
+		}
+	}
+	return len(selectors) == 0
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// underline returns a dashed separator for each header.
+func underline(headers []string) []string {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		lines = append(lines, strings.Repeat("-", len(h)))
+	}
+	return lines
+}
+
 func init() {
 	listCommand.Flags().Bool("show-tags", false, "display instance tags")
+	listCommand.Flags().StringP("output", "o", outputTable, "output format: table, wide, json, yaml, csv, tsv or template=<go-template>")
+	listCommand.Flags().Bool("show-agent", false, "display SSM agent ping status, version, platform and last ping time")
+	listCommand.Flags().Bool("online-only", false, "only list instances whose SSM agent ping status is Online")
+	listCommand.Flags().StringSlice("columns", nil, "comma-separated columns to display, e.g. name,instance-id,instance-type,az")
+	listCommand.Flags().String("sort-by", "", "sort instances by a column, e.g. launch-time")
+	listCommand.Flags().StringArray("filter", nil, "only list instances matching tag:Key=Value and field=value terms (repeatable)")
+	addGroupFlags(listCommand)
 	rootCmd.AddCommand(listCommand)
 }
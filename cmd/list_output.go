@@ -0,0 +1,403 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v3"
+
+	"github.com/tommy-cxcpwz/gossm/internal"
+)
+
+const (
+	outputTable    = "table"
+	outputWide     = "wide"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputCSV      = "csv"
+	outputTSV      = "tsv"
+	outputTemplate = "template"
+)
+
+type (
+	// listColumn is a column of the list table and csv/tsv output.
+	listColumn struct {
+		name   string                          // stable key, used as csv/tsv header
+		header string                          // table header
+		value  func(t *internal.Target) string // raw value; empty when unknown
+		// compare orders two non-empty values for --sort-by; nil compares the values as strings.
+		compare func(a, b *internal.Target) int
+	}
+
+	// targetRecord is the machine-readable form of a Target.
+	// Field names follow Target and must stay stable for scripts.
+	targetRecord struct {
+		Name             string            `json:"name" yaml:"name"`
+		TagName          string            `json:"tagName" yaml:"tagName"`
+		Tags             map[string]string `json:"tags" yaml:"tags"`
+		PublicDomain     string            `json:"publicDomain" yaml:"publicDomain"`
+		PrivateDomain    string            `json:"privateDomain" yaml:"privateDomain"`
+		State            string            `json:"state" yaml:"state"`
+		PingStatus       string            `json:"pingStatus" yaml:"pingStatus"`
+		AgentVersion     string            `json:"agentVersion" yaml:"agentVersion"`
+		IsLatestVersion  bool              `json:"isLatestVersion" yaml:"isLatestVersion"`
+		PlatformName     string            `json:"platformName" yaml:"platformName"`
+		LastPingDateTime *time.Time        `json:"lastPingDateTime,omitempty" yaml:"lastPingDateTime,omitempty"`
+
+		PrivateIP          string     `json:"privateIp" yaml:"privateIp"`
+		PublicIP           string     `json:"publicIp" yaml:"publicIp"`
+		InstanceType       string     `json:"instanceType" yaml:"instanceType"`
+		AvailabilityZone   string     `json:"availabilityZone" yaml:"availabilityZone"`
+		VpcID              string     `json:"vpcId" yaml:"vpcId"`
+		SubnetID           string     `json:"subnetId" yaml:"subnetId"`
+		LaunchTime         *time.Time `json:"launchTime,omitempty" yaml:"launchTime,omitempty"`
+		IAMInstanceProfile string     `json:"iamInstanceProfile" yaml:"iamInstanceProfile"`
+		PlatformDetails    string     `json:"platformDetails" yaml:"platformDetails"`
+		Architecture       string     `json:"architecture" yaml:"architecture"`
+	}
+
+	// listOutput describes how list renders targets.
+	listOutput struct {
+		format   string
+		template *template.Template
+		showTags bool
+		columns  []listColumn
+	}
+)
+
+var (
+	listColumns = []listColumn{
+		{name: "name", header: "NAME", value: func(t *internal.Target) string { return t.TagName }},
+		{name: "instance-id", header: "INSTANCE ID", value: func(t *internal.Target) string { return t.Name }},
+		{name: "private-dns", header: "PRIVATE DNS", value: func(t *internal.Target) string { return t.PrivateDomain }},
+		{name: "public-dns", header: "PUBLIC DNS", value: func(t *internal.Target) string { return t.PublicDomain }},
+		{name: "state", header: "STATE", value: func(t *internal.Target) string { return t.State }},
+		{name: "ping-status", header: "PING STATUS", value: func(t *internal.Target) string { return t.PingStatus }},
+		{name: "agent-version", header: "AGENT VERSION", value: formatAgentVersion},
+		{name: "platform", header: "PLATFORM", value: func(t *internal.Target) string { return t.PlatformName }},
+		{name: "last-ping", header: "LAST PING", value: formatLastPing, compare: func(a, b *internal.Target) int {
+			return a.LastPingDateTime.Compare(b.LastPingDateTime)
+		}},
+		{name: "private-ip", header: "PRIVATE IP", value: func(t *internal.Target) string { return t.PrivateIP }, compare: func(a, b *internal.Target) int {
+			return compareIPs(a.PrivateIP, b.PrivateIP)
+		}},
+		{name: "public-ip", header: "PUBLIC IP", value: func(t *internal.Target) string { return t.PublicIP }, compare: func(a, b *internal.Target) int {
+			return compareIPs(a.PublicIP, b.PublicIP)
+		}},
+		{name: "instance-type", header: "TYPE", value: func(t *internal.Target) string { return t.InstanceType }},
+		{name: "az", header: "AZ", value: func(t *internal.Target) string { return t.AvailabilityZone }},
+		{name: "vpc-id", header: "VPC", value: func(t *internal.Target) string { return t.VpcID }},
+		{name: "subnet-id", header: "SUBNET", value: func(t *internal.Target) string { return t.SubnetID }},
+		{name: "launch-time", header: "LAUNCH TIME", value: formatLaunchTime, compare: func(a, b *internal.Target) int {
+			return a.LaunchTime.Compare(b.LaunchTime)
+		}},
+		{name: "iam-profile", header: "IAM PROFILE", value: func(t *internal.Target) string { return t.IAMInstanceProfile }},
+		{name: "platform-details", header: "PLATFORM DETAILS", value: func(t *internal.Target) string { return t.PlatformDetails }},
+		{name: "architecture", header: "ARCH", value: func(t *internal.Target) string { return t.Architecture }},
+	}
+
+	defaultColumnNames = []string{"name", "instance-id", "private-dns", "public-dns"}
+	agentColumnNames   = []string{"ping-status", "agent-version", "platform", "last-ping"}
+	wideColumnNames    = []string{"name", "instance-id", "instance-type", "az", "private-ip", "public-ip", "state", "launch-time", "ping-status", "agent-version", "platform"}
+)
+
+// parseListOutput parses the -o flag value. showAgent adds the agent columns to the table format.
+func parseListOutput(value string, showTags, showAgent bool) (*listOutput, error) {
+	format, text, hasTemplate := strings.Cut(value, "=")
+	out := &listOutput{format: strings.ToLower(strings.TrimSpace(format)), showTags: showTags}
+
+	switch out.format {
+	case "", outputTable:
+		out.format = outputTable
+		names := defaultColumnNames
+		if showAgent {
+			names = append(append([]string{}, defaultColumnNames...), agentColumnNames...)
+		}
+		out.columns = columnsByName(names)
+	case outputWide:
+		out.columns = columnsByName(wideColumnNames)
+	case outputCSV, outputTSV:
+		out.columns = listColumns
+	case outputJSON, outputYAML:
+	case outputTemplate:
+		if !hasTemplate || text == "" {
+			return nil, fmt.Errorf("template output needs a template, such as -o template='{{.Name}} {{.TagName}}'")
+		}
+		tmpl, err := template.New("list").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output template: %w", err)
+		}
+		out.template = tmpl
+	default:
+		return nil, fmt.Errorf("unknown output format %q (must be one of table, wide, json, yaml, csv, tsv, template=...)", value)
+	}
+	return out, nil
+}
+
+// setColumns replaces the columns of the table, wide, csv and tsv formats with the named columns.
+func (o *listOutput) setColumns(names []string) error {
+	if o.columns == nil {
+		return fmt.Errorf("--columns does not apply to %s output", o.format)
+	}
+	columns := make([]listColumn, 0, len(names))
+	for _, name := range names {
+		c, ok := lookupColumn(name)
+		if !ok {
+			return fmt.Errorf("unknown column %q (must be one of %s)", name, strings.Join(columnNames(), ", "))
+		}
+		columns = append(columns, c)
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("--columns needs at least one column")
+	}
+	o.columns = columns
+	return nil
+}
+
+// machineReadable reports whether the output is meant for scripts rather than people.
+func (o *listOutput) machineReadable() bool {
+	return o.format != outputTable && o.format != outputWide
+}
+
+// render writes targets in the output format.
+func (o *listOutput) render(w io.Writer, targets []*internal.Target) error {
+	switch o.format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(targetRecords(targets))
+	case outputYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(targetRecords(targets)); err != nil {
+			return err
+		}
+		return enc.Close()
+	case outputCSV, outputTSV:
+		return o.renderDelimited(w, targets)
+	case outputTemplate:
+		for _, r := range targetRecords(targets) {
+			if err := o.template.Execute(w, r); err != nil {
+				return err
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	default:
+		return o.renderTable(w, targets)
+	}
+}
+
+// renderTable writes the aligned table used for people.
+func (o *listOutput) renderTable(w io.Writer, targets []*internal.Target) error {
+	headers := make([]string, 0, len(o.columns))
+	for _, c := range o.columns {
+		headers = append(headers, c.header)
+	}
+	rows := make([][]string, 0, len(targets))
+	for _, t := range targets {
+		row := make([]string, 0, len(o.columns))
+		for _, c := range o.columns {
+			row = append(row, dashIfEmpty(c.value(t)))
+		}
+		rows = append(rows, row)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if o.showTags {
+		fmt.Fprintln(tw, color.CyanString(strings.Join(append(headers, "TAGS"), "\t")))
+		fmt.Fprintln(tw, color.CyanString(strings.Join(append(underline(headers), "----"), "\t")))
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		for i, t := range targets {
+			fmt.Fprintf(w, "%s\n", strings.Join(rows[i], "  "))
+			fmt.Fprintf(w, "%s\n\n", internal.FormatTags(t.Tags))
+		}
+		return nil
+	}
+
+	fmt.Fprintln(tw, color.CyanString(strings.Join(headers, "\t")))
+	fmt.Fprintln(tw, color.CyanString(strings.Join(underline(headers), "\t")))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// renderDelimited writes csv or tsv with a header row of column names and a trailing tags column.
+func (o *listOutput) renderDelimited(w io.Writer, targets []*internal.Target) error {
+	cw := csv.NewWriter(w)
+	if o.format == outputTSV {
+		cw.Comma = '\t'
+	}
+
+	header := make([]string, 0, len(o.columns)+1)
+	for _, c := range o.columns {
+		header = append(header, c.name)
+	}
+	if err := cw.Write(append(header, "tags")); err != nil {
+		return err
+	}
+	for _, t := range targets {
+		record := make([]string, 0, len(o.columns)+1)
+		for _, c := range o.columns {
+			record = append(record, c.value(t))
+		}
+		if err := cw.Write(append(record, formatTagPairs(t.Tags))); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// columnsByName returns the registered columns with the given names, in order.
+func columnsByName(names []string) []listColumn {
+	columns := make([]listColumn, 0, len(names))
+	for _, name := range names {
+		if c, ok := lookupColumn(name); ok {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// lookupColumn returns the registered column with the given name, ignoring case.
+func lookupColumn(name string) (listColumn, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, c := range listColumns {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return listColumn{}, false
+}
+
+// columnNames returns the names of all registered columns.
+func columnNames() []string {
+	names := make([]string, 0, len(listColumns))
+	for _, c := range listColumns {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// sortColumn returns the column named by --sort-by.
+func sortColumn(by string) (listColumn, error) {
+	c, ok := lookupColumn(by)
+	if !ok {
+		return listColumn{}, fmt.Errorf("unknown sort column %q (must be one of %s)", by, strings.Join(columnNames(), ", "))
+	}
+	return c, nil
+}
+
+// sortTargets stably sorts targets by the column. Targets without a value sort last.
+func sortTargets(targets []*internal.Target, c listColumn) {
+	compare := c.compare
+	if compare == nil {
+		compare = func(a, b *internal.Target) int { return strings.Compare(c.value(a), c.value(b)) }
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		iEmpty, jEmpty := c.value(targets[i]) == "", c.value(targets[j]) == ""
+		if iEmpty || jEmpty {
+			return !iEmpty && jEmpty
+		}
+		return compare(targets[i], targets[j]) < 0
+	})
+}
+
+// compareIPs orders IP addresses numerically, falling back to string order for unparsable values.
+func compareIPs(a, b string) int {
+	ipA, errA := netip.ParseAddr(a)
+	ipB, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+	return ipA.Compare(ipB)
+}
+
+// targetRecords converts targets to their machine-readable form.
+func targetRecords(targets []*internal.Target) []targetRecord {
+	records := make([]targetRecord, 0, len(targets))
+	for _, t := range targets {
+		tags := t.Tags
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		r := targetRecord{
+			Name:            t.Name,
+			TagName:         t.TagName,
+			Tags:            tags,
+			PublicDomain:    t.PublicDomain,
+			PrivateDomain:   t.PrivateDomain,
+			State:           t.State,
+			PingStatus:      t.PingStatus,
+			AgentVersion:    t.AgentVersion,
+			IsLatestVersion: t.IsLatestVersion,
+			PlatformName:    t.PlatformName,
+
+			PrivateIP:          t.PrivateIP,
+			PublicIP:           t.PublicIP,
+			InstanceType:       t.InstanceType,
+			AvailabilityZone:   t.AvailabilityZone,
+			VpcID:              t.VpcID,
+			SubnetID:           t.SubnetID,
+			IAMInstanceProfile: t.IAMInstanceProfile,
+			PlatformDetails:    t.PlatformDetails,
+			Architecture:       t.Architecture,
+		}
+		if !t.LastPingDateTime.IsZero() {
+			lastPing := t.LastPingDateTime.UTC()
+			r.LastPingDateTime = &lastPing
+		}
+		if !t.LaunchTime.IsZero() {
+			launchTime := t.LaunchTime.UTC()
+			r.LaunchTime = &launchTime
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// formatAgentVersion returns the agent version, flagging agents that are not the latest version.
+func formatAgentVersion(t *internal.Target) string {
+	if t.AgentVersion != "" && !t.IsLatestVersion {
+		return fmt.Sprintf("%s (outdated)", t.AgentVersion)
+	}
+	return t.AgentVersion
+}
+
+// formatLastPing returns the last agent ping time in local time.
+func formatLastPing(t *internal.Target) string {
+	if t.LastPingDateTime.IsZero() {
+		return ""
+	}
+	return t.LastPingDateTime.Local().Format("2006-01-02 15:04:05")
+}
+
+// formatLaunchTime returns the instance launch time in local time.
+func formatLaunchTime(t *internal.Target) string {
+	if t.LaunchTime.IsZero() {
+		return ""
+	}
+	return t.LaunchTime.Local().Format("2006-01-02 15:04:05")
+}
+
+// formatTagPairs formats tags as key=value pairs sorted by key and separated by semicolons.
+func formatTagPairs(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for _, k := range internal.SortedMapKeys(tags) {
+		pairs = append(pairs, k+"="+tags[k])
+	}
+	return strings.Join(pairs, ";")
+}
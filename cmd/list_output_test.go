@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tommy-cxcpwz/gossm/internal"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// goldenTargets returns a fixed set of targets covering populated and empty fields.
+func goldenTargets() []*internal.Target {
+	return []*internal.Target{
+		{
+			Name:             "i-0abc123def456789a",
+			TagName:          "web-1",
+			Tags:             map[string]string{"Role": "web", "Env": "prod"},
+			PrivateDomain:    "ip-10-0-0-1.ec2.internal",
+			PublicDomain:     "ec2-1-2-3-4.compute-1.amazonaws.com",
+			State:            "running",
+			PingStatus:       "Online",
+			AgentVersion:     "3.3.40.0",
+			IsLatestVersion:  true,
+			PlatformName:     "Amazon Linux",
+			LastPingDateTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+
+			PrivateIP:          "10.0.0.1",
+			PublicIP:           "1.2.3.4",
+			InstanceType:       "t3.micro",
+			AvailabilityZone:   "us-east-1a",
+			VpcID:              "vpc-0aaa",
+			SubnetID:           "subnet-0aaa",
+			LaunchTime:         time.Date(2023, 6, 7, 8, 9, 10, 0, time.UTC),
+			IAMInstanceProfile: "web-profile",
+			PlatformDetails:    "Linux/UNIX",
+			Architecture:       "x86_64",
+		},
+		{
+			Name:          "i-0def456abc123789b",
+			PrivateDomain: "ip-10-0-0-2.ec2.internal",
+			State:         "stopped",
+			PingStatus:    "ConnectionLost",
+			AgentVersion:  "2.3.0.0",
+			PrivateIP:     "10.0.0.2",
+			InstanceType:  "r6g.large",
+		},
+	}
+}
+
+func TestListOutput_Render_MatchesGolden(t *testing.T) {
+	origNoColor, origLocal := color.NoColor, time.Local
+	color.NoColor, time.Local = true, time.UTC
+	defer func() { color.NoColor, time.Local = origNoColor, origLocal }()
+
+	tests := []struct {
+		name      string
+		output    string
+		showTags  bool
+		showAgent bool
+		columns   []string
+	}{
+		{name: "table", output: "table"},
+		{name: "table_agent", output: "table", showAgent: true},
+		{name: "table_tags", output: "table", showTags: true},
+		{name: "wide", output: "wide"},
+		{name: "json", output: "json"},
+		{name: "yaml", output: "yaml"},
+		{name: "csv", output: "csv"},
+		{name: "tsv", output: "tsv"},
+		{name: "template", output: "template={{.Name}} {{.TagName}} {{index .Tags \"Role\"}}"},
+		{name: "columns", output: "table", columns: []string{"instance-id", "instance-type", "AZ", "launch-time"}},
+		{name: "csv_columns", output: "csv", columns: []string{"instance-id", "private-ip"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := parseListOutput(tt.output, tt.showTags, tt.showAgent)
+			require.NoError(t, err)
+			if tt.columns != nil {
+				require.NoError(t, output.setColumns(tt.columns))
+			}
+			var buf bytes.Buffer
+
+			require.NoError(t, output.render(&buf, goldenTargets()))
+
+			golden := filepath.Join("testdata", "list", tt.name+".golden")
+			if *updateGolden {
+				require.NoError(t, os.MkdirAll(filepath.Dir(golden), 0755))
+				require.NoError(t, os.WriteFile(golden, buf.Bytes(), 0644))
+			}
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), buf.String())
+		})
+	}
+}
+
+func TestListOutput_EmptyJSON_RendersEmptyArray(t *testing.T) {
+	output, err := parseListOutput("json", false, false)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+
+	require.NoError(t, output.render(&buf, nil))
+
+	assert.Equal(t, "[]\n", buf.String())
+}
+
+func TestParseListOutput(t *testing.T) {
+	tests := []struct {
+		name            string
+		value           string
+		wantFormat      string
+		wantMachineRead bool
+		wantErr         bool
+	}{
+		{name: "default", value: "", wantFormat: outputTable},
+		{name: "case-insensitive", value: "JSON", wantFormat: outputJSON, wantMachineRead: true},
+		{name: "wide", value: "wide", wantFormat: outputWide},
+		{name: "template", value: "template={{.Name}}", wantFormat: outputTemplate, wantMachineRead: true},
+		{name: "template without text", value: "template", wantErr: true},
+		{name: "invalid template", value: "template={{.Name", wantErr: true},
+		{name: "unknown", value: "xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := parseListOutput(tt.value, false, false)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantFormat, output.format)
+			assert.Equal(t, tt.wantMachineRead, output.machineReadable())
+		})
+	}
+}
+
+func TestListOutput_TemplateUnknownField_ReturnsError(t *testing.T) {
+	output, err := parseListOutput("template={{.Missing}}", false, false)
+	require.NoError(t, err)
+
+	err = output.render(&bytes.Buffer{}, goldenTargets())
+
+	assert.Error(t, err)
+}
+
+func TestListOutput_SetColumns_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		columns []string
+	}{
+		{name: "unknown column", output: "table", columns: []string{"name", "color"}},
+		{name: "no columns", output: "table", columns: []string{}},
+		{name: "json has no columns", output: "json", columns: []string{"name"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := parseListOutput(tt.output, false, false)
+			require.NoError(t, err)
+
+			assert.Error(t, output.setColumns(tt.columns))
+		})
+	}
+}
+
+func TestSortTargets(t *testing.T) {
+	targets := []*internal.Target{
+		{Name: "i-0aaaaaaaa", PrivateIP: "10.0.0.10", LaunchTime: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), InstanceType: "t3.micro"},
+		{Name: "i-0bbbbbbbb", PrivateIP: "10.0.0.9", InstanceType: "m5.large"},
+		{Name: "i-0cccccccc", PrivateIP: "10.0.0.100", LaunchTime: time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	tests := []struct {
+		by   string
+		want []string
+	}{
+		{by: "launch-time", want: []string{"i-0cccccccc", "i-0aaaaaaaa", "i-0bbbbbbbb"}},
+		{by: "private-ip", want: []string{"i-0bbbbbbbb", "i-0aaaaaaaa", "i-0cccccccc"}},
+		{by: "instance-type", want: []string{"i-0bbbbbbbb", "i-0aaaaaaaa", "i-0cccccccc"}},
+		{by: "INSTANCE-ID", want: []string{"i-0aaaaaaaa", "i-0bbbbbbbb", "i-0cccccccc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			c, err := sortColumn(tt.by)
+			require.NoError(t, err)
+			sorted := append([]*internal.Target{}, targets...)
+
+			sortTargets(sorted, c)
+
+			ids := make([]string, 0, len(sorted))
+			for _, target := range sorted {
+				ids = append(ids, target.Name)
+			}
+			assert.Equal(t, tt.want, ids)
+		})
+	}
+}
+
+func TestSortColumn_Unknown_ReturnsError(t *testing.T) {
+	_, err := sortColumn("color")
+
+	assert.Error(t, err)
+}
@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"testing"
+	"time"
 
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/tommy-cxcpwz/gossm/internal"
 )
@@ -51,3 +54,71 @@ func TestListCommand_ShowTagsFlag_Registered(t *testing.T) {
 	assert.NotNil(t, flag)
 	assert.Equal(t, "false", flag.DefValue)
 }
+
+func TestFormatAgentVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		target *internal.Target
+		want   string
+	}{
+		{name: "latest", target: &internal.Target{AgentVersion: "3.3.40.0", IsLatestVersion: true}, want: "3.3.40.0"},
+		{name: "outdated", target: &internal.Target{AgentVersion: "2.3.0.0"}, want: "2.3.0.0 (outdated)"},
+		{name: "unknown", target: &internal.Target{}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatAgentVersion(tt.target))
+		})
+	}
+}
+
+func TestFormatLastPing_Set_ReturnsLocalTime(t *testing.T) {
+	target := &internal.Target{LastPingDateTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)}
+
+	assert.Equal(t, "2024-01-02 03:04:05", formatLastPing(target))
+	assert.Equal(t, "", formatLastPing(&internal.Target{}))
+}
+
+func TestListCommand_OnlineOnlyFlag_Registered(t *testing.T) {
+	for _, c := range []*cobra.Command{listCommand, startSessionCommand, execCommand} {
+		flag := c.Flags().Lookup("online-only")
+
+		assert.NotNil(t, flag, c.Name())
+	}
+}
+
+func TestGroupFlags_Registered(t *testing.T) {
+	for _, c := range []*cobra.Command{listCommand, startSessionCommand, execCommand} {
+		assert.NotNil(t, c.Flags().Lookup("asg"), c.Name())
+		assert.NotNil(t, c.Flags().Lookup("nodegroup"), c.Name())
+	}
+}
+
+func TestGroupRefs_ReturnsGroupExpressions(t *testing.T) {
+	cmd := &cobra.Command{}
+	addGroupFlags(cmd)
+	require.NoError(t, cmd.Flags().Parse([]string{"--asg", "web", "--nodegroup", "prod/workers", "--asg", " api "}))
+
+	assert.Equal(t, []string{"asg:web", "asg:api", "nodegroup:prod/workers"}, groupRefs(cmd))
+}
+
+func TestFindOptionsFromFlags_StartIfStopped_IncludesStopped(t *testing.T) {
+	cmd := &cobra.Command{}
+	addStartFlags(cmd)
+	require.NoError(t, cmd.Flags().Parse([]string{"--start-if-stopped"}))
+
+	assert.True(t, findOptionsFromFlags(cmd).IncludeStopped)
+}
+
+func TestMatchesAny(t *testing.T) {
+	web, err := internal.ParseSelector("asg:web")
+	require.NoError(t, err)
+	api, err := internal.ParseSelector("asg:api")
+	require.NoError(t, err)
+	target := &internal.Target{Name: "i-0aaaaaaaa", Tags: map[string]string{"aws:autoscaling:groupName": "api"}}
+
+	assert.True(t, matchesAny(nil, target))
+	assert.True(t, matchesAny([]*internal.Selector{web, api}, target))
+	assert.False(t, matchesAny([]*internal.Selector{web}, target))
+}
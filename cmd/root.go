@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -19,7 +20,19 @@ import (
 
 const (
 	_defaultProfile   = "default"
+	_configFileName   = "config.yaml"
 	_credentialFormat = "[%s]\naws_access_key_id = %s\naws_secret_access_key = %s\naws_session_token = %s\n"
+
+	// exit codes of exec across several instances; exitCodeTimeout is used when a command
+	// timed out on an instance, as timeout(1) does
+	exitCodeSomeFailed = 1
+	exitCodeAllFailed  = 2
+	exitCodeTimeout    = 124
+	// exitCodeStillRunning is used when gossm stops waiting for a command that keeps running;
+	// it is EX_TEMPFAIL of sysexits.h, as the results can be looked up later
+	exitCodeStillRunning = 75
+	// exitCodeInterrupted is the shell's exit code for a command stopped by SIGINT
+	exitCodeInterrupted = 130
 )
 
 var (
@@ -29,6 +42,10 @@ var (
 		Short: `gossm is interactive CLI tool that you select server in AWS and then could connect using AWS Systems Manager Session Manager.`,
 		Long:  `gossm is interactive CLI tool that you select server in AWS and then could connect using AWS Systems Manager Session Manager.`,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// shell completion loads what it needs itself and must never print or prompt
+			if cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd {
+				return nil
+			}
 			return initConfig()
 		},
 	}
@@ -37,6 +54,15 @@ var (
 	_credentialWithTemporary = fmt.Sprintf("%s_temporary", config.DefaultSharedCredentialsFilename())
 )
 
+// exitError is returned by commands that must end with a specific process exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
 type Credential struct {
 	awsProfile    string
 	awsConfig     *aws.Config
@@ -51,7 +77,13 @@ func Execute(version string) {
 	defer cleanupTemporaryCredentialFile()
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(color.RedString("[err] %s", err.Error()))
-		os.Exit(1)
+		code := 1
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.code
+		}
+		cleanupTemporaryCredentialFile()
+		os.Exit(code)
 	}
 }
 
@@ -101,6 +133,16 @@ func getGossmHomePath() (string, error) {
 	return filepath.Join(home, ".gossm"), nil
 }
 
+// loadGossmConfig reads the optional gossm config file (aliases, groups) into viper.
+// A missing file is not an error.
+func loadGossmConfig(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	viper.SetConfigFile(path)
+	return viper.ReadInConfig()
+}
+
 // ensureDirectoryExists creates the directory if it doesn't exist.
 func ensureDirectoryExists(path string) error {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
@@ -175,6 +217,10 @@ func initConfig() error {
 		return internal.WrapError(err)
 	}
 
+	if err := loadGossmConfig(filepath.Join(_credential.gossmHomePath, _configFileName)); err != nil {
+		return internal.WrapError(err)
+	}
+
 	_credential.ssmPluginPath = filepath.Join(_credential.gossmHomePath, internal.GetSsmPluginName())
 
 	// Check if plugin needs to be created/updated (compare sizes first to avoid loading large binary)
@@ -290,7 +336,8 @@ func initConfig() error {
 		}
 		_credential.awsConfig.Region = askRegion.Name
 	}
-	color.Green("region (%s)", _credential.awsConfig.Region)
+	// status goes to stderr so machine-readable output on stdout stays clean
+	fmt.Fprintln(os.Stderr, color.GreenString("region (%s)", _credential.awsConfig.Region))
 	return nil
 }
 
@@ -302,6 +349,9 @@ func init() {
 	rootCmd.PersistentFlags().StringP("region", "r", "", `[optional] it is region in AWS that would like to do something`)
 	rootCmd.PersistentFlags().Bool("debug", false, `[optional] enable debug mode to show timing information`)
 
+	rootCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+	rootCmd.RegisterFlagCompletionFunc("region", completeRegions)
+
 	// set version flag
 	rootCmd.InitDefaultVersionFlag()
 
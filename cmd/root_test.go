@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -311,3 +312,34 @@ func TestCleanupTemporaryCredentialFile_FileNotExists_DoesNotPanic(t *testing.T)
 		cleanupTemporaryCredentialFile()
 	})
 }
+
+// --- loadGossmConfig ---
+
+func TestLoadGossmConfig_FileNotExists_ReturnsNoError(t *testing.T) {
+	err := loadGossmConfig(filepath.Join(t.TempDir(), "config.yaml"))
+
+	assert.NoError(t, err)
+}
+
+func TestLoadGossmConfig_WithAliases_LoadsIntoViper(t *testing.T) {
+	defer viper.Reset()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "aliases:\n  db-bastion: i-0abc123def456789\ngroups:\n  web: [\"tag:Role=web\"]\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	err := loadGossmConfig(path)
+
+	require.NoError(t, err)
+	assert.Equal(t, "i-0abc123def456789", viper.GetStringMapString("aliases")["db-bastion"])
+	assert.Equal(t, []string{"tag:Role=web"}, viper.GetStringMapStringSlice("groups")["web"])
+}
+
+func TestLoadGossmConfig_InvalidYAML_ReturnsError(t *testing.T) {
+	defer viper.Reset()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("aliases: [unclosed"), 0600))
+
+	err := loadGossmConfig(path)
+
+	assert.Error(t, err)
+}
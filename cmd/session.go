@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -17,9 +18,18 @@ import (
 
 var (
 	startSessionCommand = &cobra.Command{
-		Use:   "start",
+		Use:   "start [target]",
 		Short: "Exec `start-session` under AWS SSM with interactive CLI",
-		Long:  "Exec `start-session` under AWS SSM with interactive CLI",
+		Long: `Exec ` + "`start-session`" + ` under AWS SSM with interactive CLI.
+
+The target may be an instance ID, an alias from ~/.gossm/config.yaml or a
+tag:Key=Value selector, given as an argument or with -t/--target. When it
+matches several instances you are asked to choose one.
+
+--asg and --nodegroup connect to a member of an Auto Scaling group or EKS node
+group: the first InService member whose SSM agent is Online, or a random one
+with --random.`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var (
 				target *internal.Target
@@ -30,18 +40,42 @@ var (
 			ec2Client := ec2.NewFromConfig(*_credential.awsConfig)
 
 			// get target - if provided directly, skip the API lookup
+			findOpts := findOptionsFromFlags(cmd)
+
 			argTarget := strings.TrimSpace(viper.GetString("start-session-target"))
-			if argTarget != "" {
-				if err := internal.ValidateInstanceID(argTarget); err != nil {
+			if len(args) > 0 {
+				argTarget = strings.TrimSpace(args[0])
+			}
+			groups := groupRefs(cmd)
+			if argTarget != "" && len(groups) > 0 {
+				return fmt.Errorf("use either a target or --asg/--nodegroup, not both")
+			}
+			if len(groups) > 0 {
+				random, _ := cmd.Flags().GetBool("random")
+				resolved, err := newTargetResolver(ssmClient, ec2Client, findOpts).Resolve(ctx, groups)
+				if err != nil {
+					return err
+				}
+				if target, err = internal.PickHealthy(resolved, random); err != nil {
+					return err
+				}
+			} else if argTarget != "" {
+				resolved, err := newTargetResolver(ssmClient, ec2Client, findOpts).Resolve(ctx, []string{argTarget})
+				if err != nil {
+					return err
+				}
+				if target, err = internal.ChooseTarget(resolved); err != nil {
 					return err
 				}
-				target = &internal.Target{Name: argTarget}
 			} else {
-				target, err = internal.AskTarget(ctx, ssmClient, ec2Client)
+				target, err = internal.AskTarget(ctx, ssmClient, ec2Client, findOpts)
 				if err != nil {
 					return err
 				}
 			}
+			if err := ensureRunning(ctx, cmd, ec2Client, ssmClient, []*internal.Target{target}); err != nil {
+				return err
+			}
 			internal.PrintReady("start-session", _credential.awsConfig.Region, target.Name)
 
 			input := &ssm.StartSessionInput{Target: aws.String(target.Name)}
@@ -77,8 +111,14 @@ var (
 )
 
 func init() {
-	startSessionCommand.Flags().StringP("target", "t", "", "[optional] it is ec2 instanceId.")
+	startSessionCommand.Flags().StringP("target", "t", "", "[optional] it is ec2 instanceId, alias, tag:Key=Value selector or field=value filter.")
+	addStartFlags(startSessionCommand)
+	addGroupFlags(startSessionCommand)
+	startSessionCommand.Flags().Bool("random", false, "[optional] with --asg or --nodegroup, connect to a random healthy member instead of the first")
+	startSessionCommand.Flags().Bool("online-only", false, "[optional] only offer instances whose SSM agent ping status is Online")
 	viper.BindPFlag("start-session-target", startSessionCommand.Flags().Lookup("target"))
+	startSessionCommand.RegisterFlagCompletionFunc("target", completeTargets)
+	startSessionCommand.ValidArgsFunction = completeTargetArg
 
 	// add sub command
 	rootCmd.AddCommand(startSessionCommand)
@@ -0,0 +1,360 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	groupPrefix = "@"
+	tagPrefix   = "tag:"
+)
+
+type (
+	// Selector matches instances by tag and metadata values; every term must match.
+	Selector struct {
+		Tags      map[string]string
+		Fields    map[string]string // keyed by SelectorFields names
+		ASG       string            // Auto Scaling group name
+		Nodegroup string            // EKS node group as cluster/name
+	}
+
+	// TargetResolver expands target references into targets.
+	// A reference is a comma-separated expression of instance IDs, alias names,
+	// @group names, tag:Key=Value selectors, field=value filters such as
+	// instance-type=t3.micro, asg:Name and nodegroup:cluster/name. Instance IDs and
+	// aliases are unioned, selectors and filters within one expression are combined with AND.
+	TargetResolver struct {
+		Aliases map[string]string
+		Groups  map[string][]string
+		// FindOptions filters the instances that selectors are matched against.
+		FindOptions FindOptions
+		// AutoScaling narrows asg: and nodegroup: matches to InService instances when set.
+		AutoScaling AutoScalingDescribeGroupsAPI
+
+		ssmClient SSMDescribeInstanceInfoAPI
+		ec2Client EC2DescribeInstancesAPI
+		table     map[string]*Target // lazily loaded instances keyed by instance ID
+	}
+
+	// targetExpansion collects the result of expanding references.
+	targetExpansion struct {
+		ids       []string
+		selectors []*Selector
+	}
+)
+
+var (
+	// SelectorFields are the instance metadata fields that selectors can filter on.
+	SelectorFields = map[string]func(t *Target) string{
+		"instance-type":    func(t *Target) string { return t.InstanceType },
+		"az":               func(t *Target) string { return t.AvailabilityZone },
+		"vpc-id":           func(t *Target) string { return t.VpcID },
+		"subnet-id":        func(t *Target) string { return t.SubnetID },
+		"private-ip":       func(t *Target) string { return t.PrivateIP },
+		"public-ip":        func(t *Target) string { return t.PublicIP },
+		"iam-profile":      func(t *Target) string { return t.IAMInstanceProfile },
+		"platform":         func(t *Target) string { return t.PlatformName },
+		"platform-details": func(t *Target) string { return t.PlatformDetails },
+		"architecture":     func(t *Target) string { return t.Architecture },
+		"state":            func(t *Target) string { return t.State },
+		"ping-status":      func(t *Target) string { return t.PingStatus },
+	}
+)
+
+// NewTargetResolver returns a resolver for the given aliases and groups.
+func NewTargetResolver(aliases map[string]string, groups map[string][]string, ssmClient SSMDescribeInstanceInfoAPI, ec2Client EC2DescribeInstancesAPI) *TargetResolver {
+	return &TargetResolver{
+		Aliases:   aliases,
+		Groups:    groups,
+		ssmClient: ssmClient,
+		ec2Client: ec2Client,
+	}
+}
+
+// ParseSelector parses a comma-separated expression of tag:Key=Value, field=value,
+// asg:Name and nodegroup:cluster/name terms.
+func ParseSelector(expr string) (*Selector, error) {
+	sel := &Selector{}
+	for _, term := range strings.Split(expr, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		if !sel.add(term) {
+			return nil, fmt.Errorf("invalid filter %q (must be tag:Key=Value, field=value, asg:Name or nodegroup:cluster/name, fields: %s)", term, strings.Join(SelectorFieldNames(), ", "))
+		}
+	}
+	if sel.empty() {
+		return nil, fmt.Errorf("empty filter")
+	}
+	return sel, nil
+}
+
+// Match reports whether the target carries all tags and field values of the selector
+// and belongs to its Auto Scaling group and node group. The Name tag is matched against
+// the target's TagName. Field values are compared case-insensitively.
+func (s *Selector) Match(t *Target) bool {
+	if !s.matchGroup(t) {
+		return false
+	}
+	for k, v := range s.Fields {
+		if !strings.EqualFold(SelectorFields[k](t), v) {
+			return false
+		}
+	}
+	for k, v := range s.Tags {
+		if k == "Name" {
+			if t.TagName != v {
+				return false
+			}
+			continue
+		}
+		if got, ok := t.Tags[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the selector in the expression form it was parsed from.
+func (s *Selector) String() string {
+	terms := make([]string, 0, len(s.Tags)+len(s.Fields)+2)
+	if s.ASG != "" {
+		terms = append(terms, asgPrefix+s.ASG)
+	}
+	if s.Nodegroup != "" {
+		terms = append(terms, nodegroupPrefix+s.Nodegroup)
+	}
+	for _, k := range SortedMapKeys(s.Tags) {
+		terms = append(terms, fmt.Sprintf("%s%s=%s", tagPrefix, k, s.Tags[k]))
+	}
+	for _, k := range SortedMapKeys(s.Fields) {
+		terms = append(terms, fmt.Sprintf("%s=%s", k, s.Fields[k]))
+	}
+	return strings.Join(terms, ",")
+}
+
+// add parses a tag:Key=Value, field=value, asg:Name or nodegroup:cluster/name term
+// into the selector. It reports false when the term is none of these.
+func (s *Selector) add(term string) bool {
+	if name, ok := strings.CutPrefix(term, asgPrefix); ok {
+		s.ASG = name
+		return name != ""
+	}
+	if ref, ok := strings.CutPrefix(term, nodegroupPrefix); ok {
+		s.Nodegroup = ref
+		_, _, err := parseNodegroup(ref)
+		return err == nil
+	}
+	if kv, ok := strings.CutPrefix(term, tagPrefix); ok {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return false
+		}
+		if s.Tags == nil {
+			s.Tags = map[string]string{}
+		}
+		s.Tags[k] = v
+		return true
+	}
+	k, v, ok := strings.Cut(term, "=")
+	if _, known := SelectorFields[strings.ToLower(k)]; !ok || !known {
+		return false
+	}
+	if s.Fields == nil {
+		s.Fields = map[string]string{}
+	}
+	s.Fields[strings.ToLower(k)] = v
+	return true
+}
+
+// empty reports whether the selector has no terms.
+func (s *Selector) empty() bool {
+	return len(s.Tags) == 0 && len(s.Fields) == 0 && !s.SelectsGroupMembers()
+}
+
+// Resolve expands references into a de-duplicated list of targets, in the order
+// they were referenced. Instances are only looked up when a selector needs them.
+func (r *TargetResolver) Resolve(ctx context.Context, refs []string) ([]*Target, error) {
+	exp := &targetExpansion{}
+	for _, ref := range refs {
+		if err := r.expand(ref, exp, map[string]bool{}); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		table   map[string]*Target
+		targets = make([]*Target, 0, len(exp.ids))
+		seen    = make(map[string]bool)
+	)
+	if len(exp.selectors) > 0 {
+		var err error
+		if table, err = r.instances(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range exp.ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		target, ok := table[id]
+		if !ok {
+			target = &Target{Name: id}
+		}
+		targets = append(targets, target)
+	}
+
+	for _, sel := range exp.selectors {
+		matched := make([]*Target, 0)
+		for _, t := range table {
+			if sel.Match(t) {
+				matched = append(matched, t)
+			}
+		}
+		if sel.SelectsGroupMembers() && r.AutoScaling != nil {
+			var err error
+			if matched, err = FilterInService(ctx, r.AutoScaling, matched); err != nil {
+				return nil, err
+			}
+		}
+		if len(matched) == 0 && r.FindOptions.IncludeUnmanaged {
+			return nil, fmt.Errorf("no instances match %s", sel)
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("no instances with SSM agent connected match %s", sel)
+		}
+		sort.Slice(matched, func(i, j int) bool { return matched[i].displayKey < matched[j].displayKey })
+		for _, t := range matched {
+			if seen[t.Name] {
+				continue
+			}
+			seen[t.Name] = true
+			targets = append(targets, t)
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets resolved from %s", strings.Join(refs, ", "))
+	}
+	return targets, nil
+}
+
+// Selector returns the selector that refs consist of when they expand to exactly one
+// selector and no instance IDs, so that commands can address the instances by tag.
+// It returns nil otherwise, or when FindOptions narrow the matches further.
+func (r *TargetResolver) Selector(refs []string) *Selector {
+	if r.FindOptions != (FindOptions{}) {
+		return nil
+	}
+	exp := &targetExpansion{}
+	for _, ref := range refs {
+		if err := r.expand(ref, exp, map[string]bool{}); err != nil {
+			return nil
+		}
+	}
+	if len(exp.ids) > 0 || len(exp.selectors) != 1 {
+		return nil
+	}
+	return exp.selectors[0]
+}
+
+// expand parses one expression and appends its instance IDs and selectors to exp.
+// seen guards against aliases that reference each other.
+func (r *TargetResolver) expand(expr string, exp *targetExpansion, seen map[string]bool) error {
+	sel := &Selector{}
+	for _, term := range strings.Split(expr, ",") {
+		term = strings.TrimSpace(term)
+		switch {
+		case term == "":
+			continue
+		case strings.HasPrefix(term, tagPrefix):
+			if !sel.add(term) {
+				return fmt.Errorf("invalid tag selector %q (must be tag:Key=Value)", term)
+			}
+		case strings.HasPrefix(term, asgPrefix), strings.HasPrefix(term, nodegroupPrefix):
+			if !sel.add(term) {
+				return fmt.Errorf("invalid group selector %q (must be asg:Name or nodegroup:cluster/name)", term)
+			}
+		case strings.Contains(term, "="):
+			if !sel.add(term) {
+				return fmt.Errorf("unknown filter field in %q (fields: %s)", term, strings.Join(SelectorFieldNames(), ", "))
+			}
+		case ValidateInstanceID(term) == nil:
+			exp.ids = append(exp.ids, term)
+		default:
+			if err := r.expandAlias(term, exp, seen); err != nil {
+				return err
+			}
+		}
+	}
+	if !sel.empty() {
+		exp.selectors = append(exp.selectors, sel)
+	}
+	return nil
+}
+
+// expandAlias expands an alias or @group name.
+func (r *TargetResolver) expandAlias(name string, exp *targetExpansion, seen map[string]bool) error {
+	key := strings.ToLower(name)
+	if seen[key] {
+		return fmt.Errorf("alias %s references itself", name)
+	}
+	seen[key] = true
+	defer delete(seen, key)
+
+	if strings.HasPrefix(key, groupPrefix) {
+		group := strings.TrimPrefix(key, groupPrefix)
+		if members, ok := r.Groups[group]; ok {
+			for _, member := range members {
+				if err := r.expand(member, exp, seen); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		key = group
+	}
+
+	if expr, ok := r.Aliases[key]; ok {
+		return r.expand(expr, exp, seen)
+	}
+	return fmt.Errorf("unknown target %q: not an instance ID, alias or group (see 'gossm alias list')", name)
+}
+
+// instances returns the instances FindOptions select, SSM-connected ones unless
+// IncludeUnmanaged is set, keyed by instance ID. They are loaded once.
+func (r *TargetResolver) instances(ctx context.Context) (map[string]*Target, error) {
+	if r.table != nil {
+		return r.table, nil
+	}
+	found, err := FindInstances(ctx, r.ssmClient, r.ec2Client, r.FindOptions)
+	if err != nil {
+		return nil, err
+	}
+	r.table = make(map[string]*Target, len(found))
+	for _, t := range found {
+		r.table[t.Name] = t
+	}
+	return r.table, nil
+}
+
+// SelectorFieldNames returns the sorted names of SelectorFields.
+func SelectorFieldNames() []string {
+	return SortedMapKeys(SelectorFields)
+}
+
+// SortedMapKeys returns the keys of m in sorted order.
+func SortedMapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	ec2_types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestResolver(aliases map[string]string, groups map[string][]string) *TargetResolver {
+	ssmClient, ec2Client := newFleetClients(
+		fakeFleet{id: "i-0aaaaaaaa", tags: map[string]string{"Name": "web-1", "Role": "web", "Env": "prod"}, typ: "t3.micro"},
+		fakeFleet{id: "i-0bbbbbbbb", tags: map[string]string{"Name": "web-2", "Role": "web", "Env": "dev"}, typ: "t3.micro"},
+		fakeFleet{id: "i-0cccccccc", tags: map[string]string{"Name": "db", "Role": "db", "Env": "prod"}, typ: "r6g.large"},
+	)
+	return NewTargetResolver(aliases, groups, ssmClient, ec2Client)
+}
+
+func targetIDs(targets []*Target) []string {
+	ids := make([]string, 0, len(targets))
+	for _, t := range targets {
+		ids = append(ids, t.Name)
+	}
+	return ids
+}
+
+func TestTargetResolver_Resolve(t *testing.T) {
+	aliases := map[string]string{
+		"db-bastion": "i-0cccccccc",
+		"web":        "tag:Role=web,tag:Env=prod",
+		"all-web":    "tag:Role=web",
+		"both":       "db-bastion,i-0bbbbbbbb",
+		"loop":       "loop",
+	}
+	groups := map[string][]string{
+		"backend": {"db-bastion", "tag:Name=web-2"},
+	}
+
+	tests := []struct {
+		name    string
+		refs    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "instance ID", refs: []string{"i-0aaaaaaaa"}, want: []string{"i-0aaaaaaaa"}},
+		{name: "comma-separated IDs", refs: []string{"i-0aaaaaaaa,i-0bbbbbbbb"}, want: []string{"i-0aaaaaaaa", "i-0bbbbbbbb"}},
+		{name: "alias to ID", refs: []string{"db-bastion"}, want: []string{"i-0cccccccc"}},
+		{name: "alias is case-insensitive", refs: []string{"DB-Bastion"}, want: []string{"i-0cccccccc"}},
+		{name: "alias tags are combined", refs: []string{"@web"}, want: []string{"i-0aaaaaaaa"}},
+		{name: "single tag matches many", refs: []string{"@all-web"}, want: []string{"i-0aaaaaaaa", "i-0bbbbbbbb"}},
+		{name: "inline tag selector sorted by name", refs: []string{"tag:Env=prod"}, want: []string{"i-0cccccccc", "i-0aaaaaaaa"}},
+		{name: "nested alias", refs: []string{"both"}, want: []string{"i-0cccccccc", "i-0bbbbbbbb"}},
+		{name: "static group", refs: []string{"@backend"}, want: []string{"i-0cccccccc", "i-0bbbbbbbb"}},
+		{name: "duplicates removed", refs: []string{"i-0cccccccc", "db-bastion"}, want: []string{"i-0cccccccc"}},
+		{name: "field filter", refs: []string{"instance-type=r6g.large"}, want: []string{"i-0cccccccc"}},
+		{name: "field filter is case-insensitive", refs: []string{"Instance-Type=T3.MICRO,tag:Env=prod"}, want: []string{"i-0aaaaaaaa"}},
+		{name: "unknown field", refs: []string{"color=blue"}, wantErr: true},
+		{name: "unknown alias", refs: []string{"nope"}, wantErr: true},
+		{name: "unknown group", refs: []string{"@nope"}, wantErr: true},
+		{name: "self-referencing alias", refs: []string{"loop"}, wantErr: true},
+		{name: "selector without matches", refs: []string{"tag:Role=cache"}, wantErr: true},
+		{name: "malformed selector", refs: []string{"tag:Role"}, wantErr: true},
+		{name: "empty expression", refs: []string{" , "}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := newTestResolver(aliases, groups)
+
+			got, err := resolver.Resolve(context.Background(), tt.refs)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, targetIDs(got))
+		})
+	}
+}
+
+func TestTargetResolver_ResolveIDsOnly_DoesNotLookUpInstances(t *testing.T) {
+	resolver := NewTargetResolver(nil, nil, nil, nil)
+
+	got, err := resolver.Resolve(context.Background(), []string{"i-0aaaaaaaa"})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"i-0aaaaaaaa"}, targetIDs(got))
+}
+
+func TestTargetResolver_ResolveWithSelector_EnrichesInstanceIDs(t *testing.T) {
+	resolver := newTestResolver(nil, nil)
+
+	got, err := resolver.Resolve(context.Background(), []string{"i-0aaaaaaaa", "tag:Role=db"})
+
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, "web-1", got[0].TagName)
+	assert.Equal(t, "db", got[1].TagName)
+}
+
+func TestTargetResolver_IncludeUnmanaged_ResolvesUnregisteredInstances(t *testing.T) {
+	ssmClient, _ := newFleetClients(fakeFleet{id: "i-0aaaaaaaa"})
+	_, ec2Client := newFleetClients(
+		fakeFleet{id: "i-0aaaaaaaa", tags: map[string]string{"Name": "web-1", "Role": "web"}},
+		fakeFleet{id: "i-0bbbbbbbb", tags: map[string]string{"Name": "web-2", "Role": "web"}},
+	)
+	resolver := NewTargetResolver(map[string]string{"web": "tag:Role=web"}, nil, ssmClient, ec2Client)
+
+	_, err := resolver.Resolve(context.Background(), []string{"tag:Name=web-2"})
+	assert.ErrorContains(t, err, "SSM agent connected")
+
+	resolver = NewTargetResolver(map[string]string{"web": "tag:Role=web"}, nil, ssmClient, ec2Client)
+	resolver.FindOptions = FindOptions{IncludeUnmanaged: true}
+	got, err := resolver.Resolve(context.Background(), []string{"web"})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"i-0aaaaaaaa", "i-0bbbbbbbb"}, targetIDs(got))
+	assert.Equal(t, "Online", got[0].PingStatus)
+	assert.Empty(t, got[1].PingStatus)
+}
+
+func TestTargetResolver_IncludeStopped_ResolvesStoppedTagMatch(t *testing.T) {
+	ssmClient, ec2Client := newFleetClients(
+		fakeFleet{id: "i-0aaaaaaaa", tags: map[string]string{"Name": "web-1", "Role": "web"}},
+		fakeFleet{id: "i-0bbbbbbbb", tags: map[string]string{"Name": "batch", "Role": "batch"}, ping: ssm_types.PingStatusConnectionLost, state: ec2_types.InstanceStateNameStopped},
+	)
+
+	_, err := NewTargetResolver(nil, nil, ssmClient, ec2Client).Resolve(context.Background(), []string{"tag:Role=batch"})
+	assert.Error(t, err)
+
+	resolver := NewTargetResolver(nil, nil, ssmClient, ec2Client)
+	resolver.FindOptions = FindOptions{IncludeStopped: true}
+	got, err := resolver.Resolve(context.Background(), []string{"tag:Role=batch"})
+
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "i-0bbbbbbbb", got[0].Name)
+	assert.Equal(t, "stopped", got[0].State)
+}
+
+func TestSelector_String_SortsTerms(t *testing.T) {
+	sel := &Selector{Tags: map[string]string{"Role": "web", "Env": "prod"}}
+
+	assert.Equal(t, "tag:Env=prod,tag:Role=web", sel.String())
+}
+
+func TestSelector_String_ListsFieldsAfterTags(t *testing.T) {
+	sel := &Selector{Tags: map[string]string{"Role": "web"}, Fields: map[string]string{"az": "us-east-1a", "instance-type": "t3.micro"}}
+
+	assert.Equal(t, "tag:Role=web,az=us-east-1a,instance-type=t3.micro", sel.String())
+}
+
+func TestParseSelector(t *testing.T) {
+	target := &Target{Name: "i-0aaaaaaaa", TagName: "web", Tags: map[string]string{"Env": "prod"}, InstanceType: "t3.micro", AvailabilityZone: "us-east-1a"}
+
+	tests := []struct {
+		name      string
+		expr      string
+		wantMatch bool
+		wantErr   bool
+	}{
+		{name: "field", expr: "instance-type=t3.micro", wantMatch: true},
+		{name: "field and tag", expr: "az=us-east-1a,tag:Env=prod", wantMatch: true},
+		{name: "name tag", expr: "tag:Name=web", wantMatch: true},
+		{name: "field mismatch", expr: "az=us-east-1b,tag:Env=prod", wantMatch: false},
+		{name: "empty value matches missing field", expr: "public-ip=", wantMatch: true},
+		{name: "unknown field", expr: "color=blue", wantErr: true},
+		{name: "instance ID is not a filter", expr: "i-0aaaaaaaa", wantErr: true},
+		{name: "empty", expr: " ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := ParseSelector(tt.expr)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMatch, sel.Match(target))
+		})
+	}
+}
+
+func TestTargetResolver_Selector(t *testing.T) {
+	aliases := map[string]string{"web": "tag:Role=web", "db": "i-0cccccccc"}
+
+	tests := []struct {
+		name string
+		refs []string
+		opts FindOptions
+		want string
+	}{
+		{name: "single tag selector", refs: []string{"tag:Role=web,tag:Env=prod"}, want: "tag:Env=prod,tag:Role=web"},
+		{name: "alias to selector", refs: []string{"web"}, want: "tag:Role=web"},
+		{name: "instance ID", refs: []string{"i-0aaaaaaaa"}},
+		{name: "selector and ID", refs: []string{"web", "db"}},
+		{name: "two selectors", refs: []string{"tag:Role=web", "tag:Role=db"}},
+		{name: "online only", refs: []string{"web"}, opts: FindOptions{OnlineOnly: true}},
+		{name: "unknown alias", refs: []string{"nope"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := NewTargetResolver(aliases, nil, nil, nil)
+			resolver.FindOptions = tt.opts
+
+			sel := resolver.Selector(tt.refs)
+
+			if tt.want == "" {
+				assert.Nil(t, sel)
+				return
+			}
+			require.NotNil(t, sel)
+			assert.Equal(t, tt.want, sel.String())
+		})
+	}
+}
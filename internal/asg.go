@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asg_types "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const (
+	asgPrefix       = "asg:"
+	nodegroupPrefix = "nodegroup:"
+
+	// tags that EC2 Auto Scaling and EKS managed node groups put on their instances
+	asgNameTag      = "aws:autoscaling:groupName"
+	eksClusterTag   = "eks:cluster-name"
+	eksNodegroupTag = "eks:nodegroup-name"
+
+	maxASGNamesQuery = 100
+)
+
+// parseNodegroup splits a cluster/name node group reference.
+func parseNodegroup(ref string) (cluster, name string, err error) {
+	cluster, name, ok := strings.Cut(ref, "/")
+	if !ok || cluster == "" || name == "" {
+		return "", "", fmt.Errorf("invalid node group %q (must be cluster/name)", ref)
+	}
+	return cluster, name, nil
+}
+
+// SelectsGroupMembers reports whether the selector matches Auto Scaling group or node group members,
+// which should be narrowed to InService instances with FilterInService.
+func (s *Selector) SelectsGroupMembers() bool {
+	return s.ASG != "" || s.Nodegroup != ""
+}
+
+// matchGroup reports whether the target belongs to the selector's Auto Scaling group and node group.
+func (s *Selector) matchGroup(t *Target) bool {
+	if s.ASG != "" && t.Tags[asgNameTag] != s.ASG {
+		return false
+	}
+	if s.Nodegroup != "" {
+		cluster, name, _ := strings.Cut(s.Nodegroup, "/")
+		if t.Tags[eksClusterTag] != cluster || t.Tags[eksNodegroupTag] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterInService keeps the targets whose Auto Scaling lifecycle state is InService,
+// dropping instances that are launching, detaching or terminating. Targets that do not
+// belong to an Auto Scaling group are kept.
+func FilterInService(ctx context.Context, client AutoScalingDescribeGroupsAPI, targets []*Target) ([]*Target, error) {
+	timer := StartTimer("AutoScaling DescribeAutoScalingGroups")
+	defer timer.Stop()
+
+	names := make(map[string]bool)
+	for _, t := range targets {
+		if name := t.Tags[asgNameTag]; name != "" {
+			names[name] = true
+		}
+	}
+	if len(names) == 0 {
+		return targets, nil
+	}
+
+	groupNames := SortedMapKeys(names)
+	inService := make(map[string]bool)
+	for start := 0; start < len(groupNames); start += maxASGNamesQuery {
+		end := min(start+maxASGNamesQuery, len(groupNames))
+		input := &autoscaling.DescribeAutoScalingGroupsInput{AutoScalingGroupNames: groupNames[start:end]}
+		for {
+			output, err := client.DescribeAutoScalingGroups(ctx, input)
+			if err != nil {
+				return nil, err
+			}
+			for _, group := range output.AutoScalingGroups {
+				for _, inst := range group.Instances {
+					if inst.LifecycleState == asg_types.LifecycleStateInService {
+						inService[aws.ToString(inst.InstanceId)] = true
+					}
+				}
+			}
+			if output.NextToken == nil {
+				break
+			}
+			input.NextToken = output.NextToken
+		}
+	}
+
+	result := make([]*Target, 0, len(targets))
+	for _, t := range targets {
+		if t.Tags[asgNameTag] == "" || inService[t.Name] {
+			result = append(result, t)
+		}
+	}
+	return result, nil
+}
+
+// PickHealthy returns the first target whose SSM agent is Online, or a random one of them
+// when random is set.
+func PickHealthy(targets []*Target, random bool) (*Target, error) {
+	healthy := make([]*Target, 0, len(targets))
+	for _, t := range targets {
+		if t.PingStatus == string(ssm_types.PingStatusOnline) {
+			healthy = append(healthy, t)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, fmt.Errorf("none of the %d matching instances has an Online SSM agent", len(targets))
+	}
+	sort.SliceStable(healthy, func(i, j int) bool { return healthy[i].displayKey < healthy[j].displayKey })
+	if random {
+		return healthy[rand.Intn(len(healthy))], nil
+	}
+	return healthy[0], nil
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asg_types "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAutoScaling reports group members and their lifecycle states.
+type fakeAutoScaling struct {
+	members map[string]map[string]asg_types.LifecycleState // group name -> instance ID -> state
+	calls   int
+}
+
+func (f *fakeAutoScaling) DescribeAutoScalingGroups(ctx context.Context, params *autoscaling.DescribeAutoScalingGroupsInput, optFns ...func(*autoscaling.Options)) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	f.calls++
+	output := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	for _, name := range params.AutoScalingGroupNames {
+		group := asg_types.AutoScalingGroup{AutoScalingGroupName: aws.String(name)}
+		for id, state := range f.members[name] {
+			group.Instances = append(group.Instances, asg_types.Instance{InstanceId: aws.String(id), LifecycleState: state})
+		}
+		output.AutoScalingGroups = append(output.AutoScalingGroups, group)
+	}
+	return output, nil
+}
+
+func newGroupResolver() (*TargetResolver, *fakeAutoScaling) {
+	ssmClient, ec2Client := newFleetClients(
+		fakeFleet{id: "i-0aaaaaaaa", tags: map[string]string{"Name": "web-1", asgNameTag: "web-asg"}},
+		fakeFleet{id: "i-0bbbbbbbb", tags: map[string]string{"Name": "web-2", asgNameTag: "web-asg"}},
+		fakeFleet{id: "i-0cccccccc", tags: map[string]string{"Name": "node-1", asgNameTag: "eks-workers", eksClusterTag: "prod", eksNodegroupTag: "workers"}},
+		fakeFleet{id: "i-0dddddddd", tags: map[string]string{"Name": "node-2", asgNameTag: "eks-workers-dev", eksClusterTag: "dev", eksNodegroupTag: "workers"}},
+	)
+	asgClient := &fakeAutoScaling{members: map[string]map[string]asg_types.LifecycleState{
+		"web-asg": {
+			"i-0aaaaaaaa": asg_types.LifecycleStateInService,
+			"i-0bbbbbbbb": asg_types.LifecycleStateTerminatingWait,
+		},
+		"eks-workers":     {"i-0cccccccc": asg_types.LifecycleStateInService},
+		"eks-workers-dev": {"i-0dddddddd": asg_types.LifecycleStateInService},
+	}}
+	resolver := NewTargetResolver(nil, nil, ssmClient, ec2Client)
+	resolver.AutoScaling = asgClient
+	return resolver, asgClient
+}
+
+func TestTargetResolver_ResolveGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		refs    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "asg keeps InService members", refs: []string{"asg:web-asg"}, want: []string{"i-0aaaaaaaa"}},
+		{name: "nodegroup matches cluster and name", refs: []string{"nodegroup:prod/workers"}, want: []string{"i-0cccccccc"}},
+		{name: "groups are unioned", refs: []string{"asg:web-asg", "nodegroup:dev/workers"}, want: []string{"i-0aaaaaaaa", "i-0dddddddd"}},
+		{name: "asg combined with tag", refs: []string{"asg:web-asg,tag:Name=web-2"}, wantErr: true},
+		{name: "unknown asg", refs: []string{"asg:nope"}, wantErr: true},
+		{name: "nodegroup without cluster", refs: []string{"nodegroup:workers"}, wantErr: true},
+		{name: "empty asg name", refs: []string{"asg:"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, _ := newGroupResolver()
+
+			got, err := resolver.Resolve(context.Background(), tt.refs)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, targetIDs(got))
+		})
+	}
+}
+
+func TestTargetResolver_ResolveTagsOnly_DoesNotCallAutoScaling(t *testing.T) {
+	resolver, asgClient := newGroupResolver()
+
+	_, err := resolver.Resolve(context.Background(), []string{"tag:Name=web-2"})
+
+	require.NoError(t, err)
+	assert.Zero(t, asgClient.calls)
+}
+
+func TestFilterInService_TargetsOutsideGroups_AreKept(t *testing.T) {
+	asgClient := &fakeAutoScaling{}
+	targets := []*Target{{Name: "i-0aaaaaaaa", Tags: map[string]string{}}}
+
+	got, err := FilterInService(context.Background(), asgClient, targets)
+
+	require.NoError(t, err)
+	assert.Equal(t, targets, got)
+	assert.Zero(t, asgClient.calls)
+}
+
+func TestPickHealthy(t *testing.T) {
+	targets := []*Target{
+		{Name: "i-0aaaaaaaa", PingStatus: "ConnectionLost", displayKey: "a"},
+		{Name: "i-0cccccccc", PingStatus: "Online", displayKey: "c"},
+		{Name: "i-0bbbbbbbb", PingStatus: "Online", displayKey: "b"},
+	}
+
+	first, err := PickHealthy(targets, false)
+	require.NoError(t, err)
+	random, err := PickHealthy(targets, true)
+	require.NoError(t, err)
+	_, noneErr := PickHealthy(targets[:1], false)
+
+	assert.Equal(t, "i-0bbbbbbbb", first.Name)
+	assert.Contains(t, []string{"i-0bbbbbbbb", "i-0cccccccc"}, random.Name)
+	assert.Error(t, noneErr)
+}
+
+func TestSelector_String_IncludesGroups(t *testing.T) {
+	sel, err := ParseSelector("nodegroup:prod/workers,asg:web-asg,az=us-east-1a")
+	require.NoError(t, err)
+
+	assert.Equal(t, "asg:web-asg,nodegroup:prod/workers,az=us-east-1a", sel.String())
+	assert.True(t, sel.SelectsGroupMembers())
+}
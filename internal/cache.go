@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultCacheTTL is how long cached instances are used before they are looked up again.
+	defaultCacheTTL = 5 * time.Minute
+)
+
+type (
+	// InstanceCache keeps the last discovered instances of a profile and region on disk,
+	// so shell completion does not need to call AWS on every key press.
+	InstanceCache struct {
+		Path string
+		TTL  time.Duration
+	}
+)
+
+// NewInstanceCache returns the cache for the profile and region under dir.
+func NewInstanceCache(dir, profile, region string) *InstanceCache {
+	clean := strings.NewReplacer("/", "_", `\`, "_", "..", "_")
+	name := fmt.Sprintf("instances-%s-%s.json", clean.Replace(profile), clean.Replace(region))
+	return &InstanceCache{Path: filepath.Join(dir, name), TTL: defaultCacheTTL}
+}
+
+// Load returns the cached instances sorted by instance ID. It reports false when the
+// cache is missing, unreadable or older than TTL.
+func (c *InstanceCache) Load() ([]*Target, bool) {
+	info, err := os.Stat(c.Path)
+	if err != nil || time.Since(info.ModTime()) > c.TTL {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.Path)
+	if err != nil {
+		return nil, false
+	}
+	var targets []*Target
+	if err := json.Unmarshal(data, &targets); err != nil {
+		DebugLog("ignoring unreadable instance cache %s: %v", c.Path, err)
+		return nil, false
+	}
+	return targets, true
+}
+
+// Save replaces the cached instances.
+func (c *InstanceCache) Save(table map[string]*Target) error {
+	targets := make([]*Target, 0, len(table))
+	for _, t := range table {
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+
+	data, err := json.Marshal(targets)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0700); err != nil {
+		return err
+	}
+	// write then rename so a concurrent completion never reads a partial file
+	tmp := c.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.Path)
+}
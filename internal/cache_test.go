@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceCache_SaveThenLoad_ReturnsSortedTargets(t *testing.T) {
+	cache := NewInstanceCache(t.TempDir(), "default", "us-east-1")
+
+	require.NoError(t, cache.Save(map[string]*Target{
+		"web\t(i-0bbbbbbbb)": {Name: "i-0bbbbbbbb", TagName: "web"},
+		"db\t(i-0aaaaaaaa)":  {Name: "i-0aaaaaaaa", TagName: "db"},
+	}))
+	targets, ok := cache.Load()
+
+	require.True(t, ok)
+	require.Len(t, targets, 2)
+	assert.Equal(t, "i-0aaaaaaaa", targets[0].Name)
+	assert.Equal(t, "web", targets[1].TagName)
+}
+
+func TestInstanceCache_Load_MissingOrExpired_ReportsFalse(t *testing.T) {
+	cache := NewInstanceCache(t.TempDir(), "default", "us-east-1")
+
+	_, missing := cache.Load()
+	require.NoError(t, cache.Save(map[string]*Target{}))
+	old := time.Now().Add(-2 * cache.TTL)
+	require.NoError(t, os.Chtimes(cache.Path, old, old))
+	_, expired := cache.Load()
+
+	assert.False(t, missing)
+	assert.False(t, expired)
+}
+
+func TestNewInstanceCache_ProfileWithSeparators_StaysInDir(t *testing.T) {
+	dir := t.TempDir()
+
+	cache := NewInstanceCache(dir, "../team/admin", "us-east-1")
+
+	assert.Equal(t, dir, filepath.Dir(cache.Path))
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/fatih/color"
+)
+
+// ErrInterrupted is the cause of the context returned by CancelOnInterrupt after a second
+// interrupt.
+var ErrInterrupted = errors.New("interrupted")
+
+// CancelCommands asks SSM to cancel the commands on every instance they have not finished on.
+func CancelCommands(ctx context.Context, client SSMCommandAPI, commandIDs []string) error {
+	timer := StartTimer("SSM CancelCommand API")
+	defer timer.Stop()
+
+	var errs []error
+	for _, id := range commandIDs {
+		if _, err := client.CancelCommand(ctx, &ssm.CancelCommandInput{CommandId: aws.String(id)}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CancelOnInterrupt returns a context for watching the commands. The first signal cancels
+// the commands, which then finish as Cancelled while they are still watched; the second
+// signal cancels the returned context with ErrInterrupted as its cause. interrupted reports
+// whether a signal has arrived.
+func CancelOnInterrupt(ctx context.Context, client SSMCommandAPI, commandIDs []string, signals <-chan os.Signal) (watchCtx context.Context, interrupted func() bool, stop context.CancelFunc) {
+	watchCtx, cancel := context.WithCancelCause(ctx)
+	var signalled atomic.Bool
+	go func() {
+		select {
+		case <-signals:
+			signalled.Store(true)
+		case <-watchCtx.Done():
+			return
+		}
+		color.Yellow("[cancel] cancelling command %s; press Ctrl+C again to stop waiting", strings.Join(commandIDs, ", "))
+		if err := CancelCommands(ctx, client, commandIDs); err != nil {
+			color.Red("[err] cannot cancel: %v", err)
+		}
+
+		select {
+		case <-signals:
+			cancel(ErrInterrupted)
+		case <-watchCtx.Done():
+		}
+	}()
+	return watchCtx, signalled.Load, func() { cancel(context.Canceled) }
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cancelRecorder records the commands passed to CancelCommand.
+func cancelRecorder(mu *sync.Mutex, cancelled *[]string) *mockSSMCommandAPI {
+	return &mockSSMCommandAPI{
+		cancelCommandFunc: func(ctx context.Context, params *ssm.CancelCommandInput, optFns ...func(*ssm.Options)) (*ssm.CancelCommandOutput, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			*cancelled = append(*cancelled, aws.ToString(params.CommandId))
+			return &ssm.CancelCommandOutput{}, nil
+		},
+	}
+}
+
+func TestCancelOnInterrupt_FirstSignalCancelsCommands_SecondStopsWaiting(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		cancelled []string
+	)
+	signals := make(chan os.Signal, 2)
+	ctx, interrupted, stop := CancelOnInterrupt(context.Background(), cancelRecorder(&mu, &cancelled), []string{"cmd-1", "cmd-2"}, signals)
+	defer stop()
+	assert.False(t, interrupted())
+
+	signals <- os.Interrupt
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(cancelled) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, ctx.Err())
+	assert.True(t, interrupted())
+
+	signals <- os.Interrupt
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled by the second signal")
+	}
+	assert.ErrorIs(t, context.Cause(ctx), ErrInterrupted)
+	assert.Equal(t, []string{"cmd-1", "cmd-2"}, cancelled)
+}
+
+func TestCancelOnInterrupt_Stop_DoesNotCancelCommands(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		cancelled []string
+	)
+	ctx, interrupted, stop := CancelOnInterrupt(context.Background(), cancelRecorder(&mu, &cancelled), []string{"cmd-1"}, make(chan os.Signal))
+
+	stop()
+	assert.False(t, interrupted())
+
+	assert.Error(t, ctx.Err())
+	assert.NotErrorIs(t, context.Cause(ctx), ErrInterrupted)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Empty(t, cancelled)
+}
+
+func TestCancelCommands_Errors_AreJoined(t *testing.T) {
+	client := &mockSSMCommandAPI{
+		cancelCommandFunc: func(ctx context.Context, params *ssm.CancelCommandInput, optFns ...func(*ssm.Options)) (*ssm.CancelCommandOutput, error) {
+			if aws.ToString(params.CommandId) == "cmd-2" {
+				return nil, assert.AnError
+			}
+			return &ssm.CancelCommandOutput{}, nil
+		},
+	}
+
+	err := CancelCommands(context.Background(), client, []string{"cmd-1", "cmd-2"})
+
+	assert.ErrorIs(t, err, assert.AnError)
+}
@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// maxConsoleHistory is how many lines the console history keeps.
+const maxConsoleHistory = 1000
+
+type (
+	// ConsoleSession is the state the console keeps between commands: the targets they are
+	// sent to, and the working directory and environment they run with.
+	ConsoleSession struct {
+		Targets []*Target
+		Dir     string
+		Env     map[string]string
+	}
+
+	// ConsoleHistory keeps the lines entered in the console and appends them to a file, so
+	// they are offered again in the next session. It implements the History of a
+	// golang.org/x/term Terminal.
+	ConsoleHistory struct {
+		file  string
+		lines []string // oldest first
+	}
+)
+
+// Command returns the shell command that runs line in the directory and with the
+// environment of the session. It stops before running line if the directory is missing.
+func (s *ConsoleSession) Command(line string) string {
+	var lines []string
+	if s.Dir != "" {
+		lines = append(lines, "cd "+shellQuote(s.Dir)+" || exit 1")
+	}
+	if len(s.Env) > 0 {
+		lines = append(lines, exportEnv(s.Env))
+	}
+	return strings.Join(append(lines, line), "\n")
+}
+
+// Parameters returns the parameters of document, AWS-RunShellScript or
+// AWS-RunPowerShellScript as ScriptDocument picks it, that run line in the directory and
+// with the environment of the session.
+func (s *ConsoleSession) Parameters(document, line string) (map[string][]string, error) {
+	if document == ShellScriptDocument {
+		return ShellScriptParameters(s.Command(line)), nil
+	}
+	// PowerShell has no cd || exit 1, so the SSM agent changes the directory instead
+	return CommandEnv{WorkDir: s.Dir, Env: s.Env}.Parameters(document, line)
+}
+
+// ChangeDir sets the working directory of later commands. A relative dir is taken from the
+// current one, and an empty dir goes back to the default directory of the SSM agent.
+func (s *ConsoleSession) ChangeDir(dir string) {
+	switch {
+	case dir == "":
+		s.Dir = ""
+	case path.IsAbs(dir) || s.Dir == "":
+		s.Dir = path.Clean(dir)
+	default:
+		s.Dir = path.Join(s.Dir, dir)
+	}
+}
+
+// SetEnv sets the environment variables of later commands from NAME=value assignments.
+func (s *ConsoleSession) SetEnv(assignments []string) error {
+	vars, err := ParseEnv(assignments)
+	if err != nil {
+		return err
+	}
+	if s.Env == nil {
+		s.Env = make(map[string]string, len(vars))
+	}
+	for name, value := range vars {
+		s.Env[name] = value
+	}
+	return nil
+}
+
+// UnsetEnv removes environment variables set with SetEnv.
+func (s *ConsoleSession) UnsetEnv(names []string) {
+	for _, name := range names {
+		delete(s.Env, name)
+	}
+}
+
+// AddTargets adds the targets that are not in the session yet and returns how many were added.
+func (s *ConsoleSession) AddTargets(targets []*Target) int {
+	seen := make(map[string]bool, len(s.Targets))
+	for _, t := range s.Targets {
+		seen[t.Name] = true
+	}
+	added := 0
+	for _, t := range targets {
+		if !seen[t.Name] {
+			seen[t.Name] = true
+			s.Targets = append(s.Targets, t)
+			added++
+		}
+	}
+	return added
+}
+
+// RemoveTargets removes the targets whose instance ID or Name tag is one of refs and
+// returns how many were removed.
+func (s *ConsoleSession) RemoveTargets(refs []string) int {
+	remove := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		remove[ref] = true
+	}
+	kept := s.Targets[:0]
+	for _, t := range s.Targets {
+		if !remove[t.Name] && (t.TagName == "" || !remove[t.TagName]) {
+			kept = append(kept, t)
+		}
+	}
+	removed := len(s.Targets) - len(kept)
+	clear(s.Targets[len(kept):])
+	s.Targets = kept
+	return removed
+}
+
+// LoadConsoleHistory reads the history saved in file. A missing file starts an empty history.
+func LoadConsoleHistory(file string) (*ConsoleHistory, error) {
+	h := &ConsoleHistory{file: file}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.lines = append(h.lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(h.lines) > maxConsoleHistory {
+		// keep the file from growing without bound
+		h.lines = h.lines[len(h.lines)-maxConsoleHistory:]
+		if err := os.WriteFile(file, []byte(strings.Join(h.lines, "\n")+"\n"), 0600); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Add records line as the most recent entry and appends it to the history file. A line
+// that repeats the most recent entry is not recorded again.
+func (h *ConsoleHistory) Add(line string) {
+	if line == "" || strings.Contains(line, "\n") || (len(h.lines) > 0 && h.lines[len(h.lines)-1] == line) {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if len(h.lines) > maxConsoleHistory {
+		h.lines = h.lines[1:]
+	}
+	if h.file == "" {
+		return
+	}
+	// the history is a convenience, so a file that cannot be written is not an error
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		DebugLog("cannot save console history: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		DebugLog("cannot save console history: %v", err)
+	}
+}
+
+// Len returns the number of entries in the history.
+func (h *ConsoleHistory) Len() int {
+	return len(h.lines)
+}
+
+// At returns an entry of the history, 0 being the most recent.
+func (h *ConsoleHistory) At(i int) string {
+	return h.lines[len(h.lines)-1-i]
+}
+
+// Lines returns the entries of the history, oldest first.
+func (h *ConsoleHistory) Lines() []string {
+	return h.lines
+}
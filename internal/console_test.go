@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsoleSession_Command(t *testing.T) {
+	s := &ConsoleSession{}
+	assert.Equal(t, "uptime", s.Command("uptime"))
+
+	s.ChangeDir("/srv/my app")
+	require.NoError(t, s.SetEnv([]string{"RAILS_ENV=production", "MSG=it's here"}))
+	assert.Equal(t, "cd '/srv/my app' || exit 1\nexport MSG='it'\\''s here' RAILS_ENV=production\nls -la", s.Command("ls -la"))
+}
+
+func TestConsoleSession_Parameters(t *testing.T) {
+	s := &ConsoleSession{}
+	s.ChangeDir("/srv")
+	require.NoError(t, s.SetEnv([]string{"MSG=hi"}))
+
+	params, err := s.Parameters(ShellScriptDocument, "ls")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"commands": {"cd /srv || exit 1\nexport MSG=hi\nls"}}, params)
+
+	params, err = s.Parameters(PowerShellScriptDocument, "dir")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"commands": {"$env:MSG = 'hi'\ndir"}, "workingDirectory": {"/srv"}}, params)
+}
+
+func TestConsoleSession_ChangeDir(t *testing.T) {
+	s := &ConsoleSession{}
+	s.ChangeDir("logs")
+	assert.Equal(t, "logs", s.Dir)
+	s.ChangeDir("/srv/app/")
+	assert.Equal(t, "/srv/app", s.Dir)
+	s.ChangeDir("../shared/log")
+	assert.Equal(t, "/srv/shared/log", s.Dir)
+	s.ChangeDir("")
+	assert.Empty(t, s.Dir)
+}
+
+func TestConsoleSession_SetEnv_InvalidName_ChangesNothing(t *testing.T) {
+	s := &ConsoleSession{}
+	require.NoError(t, s.SetEnv([]string{"A=1"}))
+
+	assert.ErrorContains(t, s.SetEnv([]string{"B=2", "1X=3"}), `invalid variable "1X=3"`)
+	assert.ErrorContains(t, s.SetEnv([]string{"NOVALUE"}), "want NAME=value")
+	assert.Equal(t, map[string]string{"A": "1"}, s.Env)
+
+	s.UnsetEnv([]string{"A", "MISSING"})
+	assert.Empty(t, s.Env)
+}
+
+func TestConsoleSession_AddAndRemoveTargets(t *testing.T) {
+	s := &ConsoleSession{Targets: []*Target{{Name: "i-1", TagName: "web-1"}}}
+
+	assert.Equal(t, 2, s.AddTargets([]*Target{{Name: "i-1"}, {Name: "i-2", TagName: "web-2"}, {Name: "i-3"}}))
+	assert.Equal(t, 2, s.RemoveTargets([]string{"web-1", "i-3"}))
+
+	require.Len(t, s.Targets, 1)
+	assert.Equal(t, "i-2", s.Targets[0].Name)
+}
+
+func TestConsoleHistory_SavesAndLoadsLines(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "console_history")
+	h, err := LoadConsoleHistory(file)
+	require.NoError(t, err)
+	assert.Zero(t, h.Len())
+
+	h.Add("uptime")
+	h.Add("uptime")
+	h.Add("")
+	h.Add("df -h")
+	assert.Equal(t, 2, h.Len())
+	assert.Equal(t, "df -h", h.At(0))
+	assert.Equal(t, "uptime", h.At(1))
+
+	loaded, err := LoadConsoleHistory(file)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"uptime", "df -h"}, loaded.Lines())
+}
+
+func TestLoadConsoleHistory_TrimsLongFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "console_history")
+	lines := make([]string, 0, maxConsoleHistory+10)
+	for i := 0; i < maxConsoleHistory+10; i++ {
+		lines = append(lines, strings.Repeat("x", i%7+1))
+	}
+	require.NoError(t, os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+
+	h, err := LoadConsoleHistory(file)
+	require.NoError(t, err)
+	assert.Equal(t, maxConsoleHistory, h.Len())
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, maxConsoleHistory, strings.Count(string(data), "\n"))
+}
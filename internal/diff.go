@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContext is how many unchanged lines surround each change in a unified diff.
+	diffContext = 3
+	// maxDiffCells bounds the size of the table used to compare two outputs; larger outputs
+	// are shown as replaced entirely.
+	maxDiffCells = 4 << 20
+)
+
+// diffLine is one line of a diff: kept (' '), removed ('-') or added ('+').
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// unifiedDiff returns the unified diff of the lines of from and to, or "" when they are the same.
+func unifiedDiff(from, to, fromName, toName string) string {
+	lines := diffLines(splitLines(from), splitLines(to))
+
+	// ranges of lines to show: every change with its context, merged where they touch
+	var hunks [][2]int
+	for i, l := range lines {
+		if l.kind == ' ' {
+			continue
+		}
+		lo, hi := max(0, i-diffContext), min(len(lines), i+1+diffContext)
+		if n := len(hunks); n > 0 && lo <= hunks[n-1][1] {
+			hunks[n-1][1] = hi
+			continue
+		}
+		hunks = append(hunks, [2]int{lo, hi})
+	}
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+	fromLine, toLine, next := 0, 0, 0
+	for _, h := range hunks {
+		for ; next < h[0]; next++ {
+			fromLine, toLine = advance(lines[next], fromLine, toLine)
+		}
+		fromCount, toCount := 0, 0
+		for _, l := range lines[h[0]:h[1]] {
+			fromCount, toCount = advance(l, fromCount, toCount)
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(fromLine, fromCount), hunkRange(toLine, toCount))
+		for ; next < h[1]; next++ {
+			l := lines[next]
+			fromLine, toLine = advance(l, fromLine, toLine)
+			fmt.Fprintf(&b, "%c%s\n", l.kind, l.text)
+		}
+	}
+	return b.String()
+}
+
+// advance counts a diff line towards the lines of the old and new text.
+func advance(l diffLine, from, to int) (int, int) {
+	if l.kind != '+' {
+		from++
+	}
+	if l.kind != '-' {
+		to++
+	}
+	return from, to
+}
+
+// hunkRange formats the lines of a hunk after start lines: "start+1,count", or the line
+// before it for an empty range.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprint(start + 1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// diffLines returns the lines of a and b with those of a longest common subsequence kept.
+func diffLines(a, b []string) []diffLine {
+	lines := make([]diffLine, 0, len(a)+len(b))
+	if len(a)*len(b) > maxDiffCells {
+		for _, s := range a {
+			lines = append(lines, diffLine{'-', s})
+		}
+		for _, s := range b {
+			lines = append(lines, diffLine{'+', s})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{'+', b[j]})
+	}
+	return lines
+}
+
+// splitLines returns the lines of s, without a final empty line.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
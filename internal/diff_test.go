@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{name: "same", from: "a\nb\n", to: "a\nb\n", want: ""},
+		{
+			name: "changed line",
+			from: "NAME=Amazon Linux\nVERSION=2\nID=amzn\n",
+			to:   "NAME=Amazon Linux\nVERSION=2023\nID=amzn\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,3 @@\n NAME=Amazon Linux\n-VERSION=2\n+VERSION=2023\n ID=amzn\n",
+		},
+		{
+			name: "added to empty",
+			from: "",
+			to:   "x\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1 @@\n+x\n",
+		},
+		{
+			name: "removed line",
+			from: "a\nb\n",
+			to:   "a\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1 @@\n a\n-b\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, unifiedDiff(tt.from, tt.to, "old", "new"))
+		})
+	}
+}
+
+func TestUnifiedDiff_DistantChanges_SeparateHunks(t *testing.T) {
+	var lines []string
+	for i := 0; i < 20; i++ {
+		lines = append(lines, string(rune('a'+i)))
+	}
+	from := strings.Join(lines, "\n")
+	lines[1], lines[18] = "B", "S"
+	to := strings.Join(lines, "\n")
+
+	diff := unifiedDiff(from, to, "old", "new")
+
+	assert.Equal(t, 2, strings.Count(diff, "@@ -"))
+	assert.Contains(t, diff, "@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n")
+	assert.Contains(t, diff, "@@ -16,5 +16,5 @@\n p\n q\n r\n-s\n+S\n t\n")
+}
@@ -0,0 +1,342 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2_types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/fatih/color"
+)
+
+const (
+	managedInstanceCorePolicy = "AmazonSSMManagedInstanceCore"
+	deprecatedSSMRolePolicy   = "AmazonEC2RoleforSSM"
+	stalePingThreshold        = 10 * time.Minute
+)
+
+const (
+	CheckOK   CheckStatus = "ok"
+	CheckWarn CheckStatus = "warn"
+	CheckFail CheckStatus = "fail"
+	CheckSkip CheckStatus = "skip"
+)
+
+var (
+	// ssmEndpointServices are the services the SSM agent must reach.
+	ssmEndpointServices = []string{"ssm", "ssmmessages", "ec2messages"}
+)
+
+type (
+	// CheckStatus is the outcome of a doctor check.
+	CheckStatus string
+
+	// DoctorCheck is the result of one SSM reachability check.
+	DoctorCheck struct {
+		Name   string
+		Status CheckStatus
+		Detail string
+	}
+
+	// DoctorEC2API defines the EC2 operations used by the doctor checks.
+	DoctorEC2API interface {
+		EC2DescribeInstancesAPI
+		EC2NetworkAPI
+	}
+
+	// DoctorClients groups the AWS clients used by the doctor checks.
+	DoctorClients struct {
+		SSM SSMDescribeInstanceInfoAPI
+		EC2 DoctorEC2API
+		IAM IAMInstanceProfileAPI
+	}
+)
+
+// Diagnose runs the SSM reachability checks against an instance and returns their results.
+func Diagnose(ctx context.Context, clients DoctorClients, region, instanceID string) ([]DoctorCheck, error) {
+	timer := StartTimer("Diagnose")
+	defer timer.Stop()
+
+	output, err := clients.EC2.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}})
+	if err != nil {
+		return nil, err
+	}
+	var inst *ec2_types.Instance
+	for _, rv := range output.Reservations {
+		for i := range rv.Instances {
+			inst = &rv.Instances[i]
+		}
+	}
+	if inst == nil {
+		return nil, fmt.Errorf("not found ec2 instance %s", instanceID)
+	}
+
+	return []DoctorCheck{
+		checkInstanceState(inst),
+		checkInstanceProfile(inst),
+		checkManagedPolicy(ctx, clients.IAM, inst),
+		checkNetworkPath(ctx, clients.EC2, region, inst),
+		checkAgentPing(ctx, clients.SSM, instanceID, time.Now()),
+	}, nil
+}
+
+// PrintDoctorChecks prints check results, one line per check.
+func PrintDoctorChecks(checks []DoctorCheck) {
+	for _, c := range checks {
+		var status string
+		switch c.Status {
+		case CheckOK:
+			status = color.GreenString("[ok]  ")
+		case CheckWarn:
+			status = color.YellowString("[warn]")
+		case CheckFail:
+			status = color.RedString("[fail]")
+		default:
+			status = color.CyanString("[skip]")
+		}
+		fmt.Printf("%s %s: %s\n", status, c.Name, c.Detail)
+	}
+}
+
+// checkInstanceState checks that the instance is running.
+func checkInstanceState(inst *ec2_types.Instance) DoctorCheck {
+	check := DoctorCheck{Name: "instance state"}
+	state := ec2_types.InstanceStateNamePending
+	if inst.State != nil {
+		state = inst.State.Name
+	}
+	if state == ec2_types.InstanceStateNameRunning {
+		check.Status, check.Detail = CheckOK, "running"
+	} else {
+		check.Status, check.Detail = CheckFail, fmt.Sprintf("instance is %s; SSM can only reach running instances", state)
+	}
+	return check
+}
+
+// checkInstanceProfile checks that an IAM instance profile is attached.
+func checkInstanceProfile(inst *ec2_types.Instance) DoctorCheck {
+	check := DoctorCheck{Name: "instance profile"}
+	if inst.IamInstanceProfile == nil || aws.ToString(inst.IamInstanceProfile.Arn) == "" {
+		check.Status, check.Detail = CheckFail, "no IAM instance profile attached; the SSM agent has no credentials to register"
+		return check
+	}
+	check.Status, check.Detail = CheckOK, aws.ToString(inst.IamInstanceProfile.Arn)
+	return check
+}
+
+// checkManagedPolicy checks that a role of the instance profile has AmazonSSMManagedInstanceCore attached.
+func checkManagedPolicy(ctx context.Context, client IAMInstanceProfileAPI, inst *ec2_types.Instance) DoctorCheck {
+	check := DoctorCheck{Name: "SSM policy"}
+	if inst.IamInstanceProfile == nil || aws.ToString(inst.IamInstanceProfile.Arn) == "" {
+		check.Status, check.Detail = CheckSkip, "no instance profile to inspect"
+		return check
+	}
+
+	profileName := instanceProfileName(aws.ToString(inst.IamInstanceProfile.Arn))
+	profile, err := client.GetInstanceProfile(ctx, &iam.GetInstanceProfileInput{InstanceProfileName: aws.String(profileName)})
+	if err != nil {
+		check.Status, check.Detail = CheckSkip, fmt.Sprintf("cannot inspect instance profile %s: %v", profileName, err)
+		return check
+	}
+	if profile.InstanceProfile == nil || len(profile.InstanceProfile.Roles) == 0 {
+		check.Status, check.Detail = CheckFail, fmt.Sprintf("instance profile %s has no role", profileName)
+		return check
+	}
+
+	var deprecated []string
+	roleNames := make([]string, 0, len(profile.InstanceProfile.Roles))
+	for _, role := range profile.InstanceProfile.Roles {
+		roleName := aws.ToString(role.RoleName)
+		roleNames = append(roleNames, roleName)
+		policies, err := attachedPolicyNames(ctx, client, roleName)
+		if err != nil {
+			check.Status, check.Detail = CheckSkip, fmt.Sprintf("cannot list policies of role %s: %v", roleName, err)
+			return check
+		}
+		for _, p := range policies {
+			switch p {
+			case managedInstanceCorePolicy:
+				check.Status, check.Detail = CheckOK, fmt.Sprintf("role %s has %s", roleName, managedInstanceCorePolicy)
+				return check
+			case deprecatedSSMRolePolicy:
+				deprecated = append(deprecated, roleName)
+			}
+		}
+	}
+
+	if len(deprecated) > 0 {
+		check.Status, check.Detail = CheckWarn, fmt.Sprintf("role %s uses the deprecated %s policy; attach %s instead",
+			strings.Join(deprecated, ", "), deprecatedSSMRolePolicy, managedInstanceCorePolicy)
+		return check
+	}
+	check.Status, check.Detail = CheckFail, fmt.Sprintf("role %s lacks %s (inline and custom policies were not inspected)",
+		strings.Join(roleNames, ", "), managedInstanceCorePolicy)
+	return check
+}
+
+// attachedPolicyNames returns the names of the managed policies attached to a role.
+func attachedPolicyNames(ctx context.Context, client IAMInstanceProfileAPI, roleName string) ([]string, error) {
+	var names []string
+	input := &iam.ListAttachedRolePoliciesInput{RoleName: aws.String(roleName)}
+	for {
+		output, err := client.ListAttachedRolePolicies(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range output.AttachedPolicies {
+			names = append(names, aws.ToString(p.PolicyName))
+		}
+		if !output.IsTruncated {
+			return names, nil
+		}
+		input.Marker = output.Marker
+	}
+}
+
+// instanceProfileName extracts the profile name from an instance profile ARN.
+func instanceProfileName(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// checkNetworkPath checks that the instance can reach the SSM endpoints,
+// either through VPC interface endpoints or a default route to the internet.
+func checkNetworkPath(ctx context.Context, client EC2NetworkAPI, region string, inst *ec2_types.Instance) DoctorCheck {
+	check := DoctorCheck{Name: "network path"}
+	vpcID := aws.ToString(inst.VpcId)
+	if vpcID == "" {
+		check.Status, check.Detail = CheckSkip, "instance is not in a VPC"
+		return check
+	}
+
+	serviceNames := make([]string, 0, len(ssmEndpointServices))
+	for _, svc := range ssmEndpointServices {
+		serviceNames = append(serviceNames, fmt.Sprintf("com.amazonaws.%s.%s", region, svc))
+	}
+	endpoints, err := client.DescribeVpcEndpoints(ctx, &ec2.DescribeVpcEndpointsInput{
+		Filters: []ec2_types.Filter{
+			{Name: aws.String("vpc-id"), Values: []string{vpcID}},
+			{Name: aws.String("service-name"), Values: serviceNames},
+		},
+	})
+	if err != nil {
+		check.Status, check.Detail = CheckSkip, fmt.Sprintf("cannot describe VPC endpoints: %v", err)
+		return check
+	}
+	available := make(map[string]bool)
+	for _, ep := range endpoints.VpcEndpoints {
+		if strings.EqualFold(string(ep.State), string(ec2_types.StateAvailable)) {
+			available[aws.ToString(ep.ServiceName)] = true
+		}
+	}
+	var missing []string
+	for i, name := range serviceNames {
+		if !available[name] {
+			missing = append(missing, ssmEndpointServices[i])
+		}
+	}
+	if len(missing) == 0 {
+		check.Status, check.Detail = CheckOK, fmt.Sprintf("VPC endpoints for %s in %s", strings.Join(ssmEndpointServices, ", "), vpcID)
+		return check
+	}
+
+	route, err := findDefaultRoute(ctx, client, vpcID, aws.ToString(inst.SubnetId))
+	if err != nil {
+		check.Status, check.Detail = CheckSkip, fmt.Sprintf("cannot describe route tables: %v", err)
+		return check
+	}
+	missingDetail := fmt.Sprintf("no VPC endpoints for %s", strings.Join(missing, ", "))
+	switch {
+	case route == nil:
+		check.Status, check.Detail = CheckFail, fmt.Sprintf("%s and no default route in subnet %s", missingDetail, aws.ToString(inst.SubnetId))
+	case strings.HasPrefix(aws.ToString(route.GatewayId), "igw-"):
+		if aws.ToString(inst.PublicIpAddress) == "" {
+			check.Status, check.Detail = CheckFail, fmt.Sprintf("%s; default route is %s but the instance has no public IP", missingDetail, aws.ToString(route.GatewayId))
+		} else {
+			check.Status, check.Detail = CheckOK, fmt.Sprintf("default route via %s with public IP %s", aws.ToString(route.GatewayId), aws.ToString(inst.PublicIpAddress))
+		}
+	default:
+		check.Status, check.Detail = CheckOK, fmt.Sprintf("default route via %s", routeTarget(route))
+	}
+	return check
+}
+
+// findDefaultRoute returns the active 0.0.0.0/0 route of the subnet's route table,
+// falling back to the VPC's main route table. Returns nil if there is none.
+func findDefaultRoute(ctx context.Context, client EC2NetworkAPI, vpcID, subnetID string) (*ec2_types.Route, error) {
+	output, err := client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: []ec2_types.Filter{{Name: aws.String("association.subnet-id"), Values: []string{subnetID}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.RouteTables) == 0 {
+		output, err = client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+			Filters: []ec2_types.Filter{
+				{Name: aws.String("vpc-id"), Values: []string{vpcID}},
+				{Name: aws.String("association.main"), Values: []string{"true"}},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, table := range output.RouteTables {
+		for i, route := range table.Routes {
+			if aws.ToString(route.DestinationCidrBlock) == "0.0.0.0/0" && route.State != ec2_types.RouteStateBlackhole {
+				return &table.Routes[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// routeTarget returns the ID of whatever the route points at.
+func routeTarget(route *ec2_types.Route) string {
+	for _, id := range []*string{route.NatGatewayId, route.TransitGatewayId, route.GatewayId,
+		route.NetworkInterfaceId, route.InstanceId, route.VpcPeeringConnectionId} {
+		if aws.ToString(id) != "" {
+			return aws.ToString(id)
+		}
+	}
+	return "unknown target"
+}
+
+// checkAgentPing checks that the instance is registered with SSM and its agent pings recently.
+func checkAgentPing(ctx context.Context, client SSMDescribeInstanceInfoAPI, instanceID string, now time.Time) DoctorCheck {
+	check := DoctorCheck{Name: "agent ping"}
+	instances, err := describeInstanceInformation(ctx, client, []ssm_types.InstanceInformationStringFilter{
+		{Key: aws.String("InstanceIds"), Values: []string{instanceID}},
+	})
+	if err != nil {
+		check.Status, check.Detail = CheckSkip, fmt.Sprintf("cannot describe instance information: %v", err)
+		return check
+	}
+	info, ok := instances[instanceID]
+	if !ok {
+		check.Status, check.Detail = CheckFail, "not registered with SSM; the agent is not running or has never reached SSM"
+		return check
+	}
+
+	lastPing := aws.ToTime(info.LastPingDateTime)
+	since := "never"
+	if !lastPing.IsZero() {
+		since = fmt.Sprintf("%s ago", now.Sub(lastPing).Round(time.Second))
+	}
+	switch {
+	case info.PingStatus != ssm_types.PingStatusOnline:
+		check.Status, check.Detail = CheckFail, fmt.Sprintf("ping status %s, last ping %s", info.PingStatus, since)
+	case !lastPing.IsZero() && now.Sub(lastPing) > stalePingThreshold:
+		check.Status, check.Detail = CheckWarn, fmt.Sprintf("Online but last ping %s", since)
+	case !aws.ToBool(info.IsLatestVersion):
+		check.Status, check.Detail = CheckWarn, fmt.Sprintf("Online, but agent %s is outdated", aws.ToString(info.AgentVersion))
+	default:
+		check.Status, check.Detail = CheckOK, fmt.Sprintf("Online, agent %s, last ping %s", aws.ToString(info.AgentVersion), since)
+	}
+	return check
+}
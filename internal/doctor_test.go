@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2_types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iam_types "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDoctorEC2 implements DoctorEC2API with canned responses.
+type fakeDoctorEC2 struct {
+	instance    ec2_types.Instance
+	endpoints   []ec2_types.VpcEndpoint
+	subnetRoute []ec2_types.RouteTable
+	mainRoute   []ec2_types.RouteTable
+	err         error
+}
+
+func (f *fakeDoctorEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []ec2_types.Reservation{{Instances: []ec2_types.Instance{f.instance}}},
+	}, nil
+}
+
+func (f *fakeDoctorEC2) DescribeVpcEndpoints(ctx context.Context, params *ec2.DescribeVpcEndpointsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVpcEndpointsOutput, error) {
+	return &ec2.DescribeVpcEndpointsOutput{VpcEndpoints: f.endpoints}, f.err
+}
+
+func (f *fakeDoctorEC2) DescribeRouteTables(ctx context.Context, params *ec2.DescribeRouteTablesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRouteTablesOutput, error) {
+	for _, filter := range params.Filters {
+		if aws.ToString(filter.Name) == "association.main" {
+			return &ec2.DescribeRouteTablesOutput{RouteTables: f.mainRoute}, nil
+		}
+	}
+	return &ec2.DescribeRouteTablesOutput{RouteTables: f.subnetRoute}, nil
+}
+
+// fakeIAM implements IAMInstanceProfileAPI with canned responses.
+type fakeIAM struct {
+	roles    []string
+	policies map[string][]string
+	err      error
+}
+
+func (f *fakeIAM) GetInstanceProfile(ctx context.Context, params *iam.GetInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.GetInstanceProfileOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	profile := &iam_types.InstanceProfile{InstanceProfileName: params.InstanceProfileName}
+	for _, r := range f.roles {
+		profile.Roles = append(profile.Roles, iam_types.Role{RoleName: aws.String(r)})
+	}
+	return &iam.GetInstanceProfileOutput{InstanceProfile: profile}, nil
+}
+
+func (f *fakeIAM) ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error) {
+	output := &iam.ListAttachedRolePoliciesOutput{}
+	for _, p := range f.policies[aws.ToString(params.RoleName)] {
+		output.AttachedPolicies = append(output.AttachedPolicies, iam_types.AttachedPolicy{PolicyName: aws.String(p)})
+	}
+	return output, nil
+}
+
+func newAgentInfoClient(infos ...ssm_types.InstanceInformation) *mockSSMDescribeInstanceInfoAPI {
+	return &mockSSMDescribeInstanceInfoAPI{
+		describeInstanceInformationFunc: func(ctx context.Context, params *ssm.DescribeInstanceInformationInput, optFns ...func(*ssm.Options)) (*ssm.DescribeInstanceInformationOutput, error) {
+			return &ssm.DescribeInstanceInformationOutput{InstanceInformationList: infos}, nil
+		},
+	}
+}
+
+func profiledInstance() *ec2_types.Instance {
+	return &ec2_types.Instance{
+		InstanceId:         aws.String("i-0aaaaaaaa"),
+		State:              &ec2_types.InstanceState{Name: ec2_types.InstanceStateNameRunning},
+		IamInstanceProfile: &ec2_types.IamInstanceProfile{Arn: aws.String("arn:aws:iam::123456789012:instance-profile/path/web-profile")},
+		VpcId:              aws.String("vpc-1"),
+		SubnetId:           aws.String("subnet-1"),
+	}
+}
+
+func defaultRouteTable(route ec2_types.Route) []ec2_types.RouteTable {
+	route.DestinationCidrBlock = aws.String("0.0.0.0/0")
+	return []ec2_types.RouteTable{{Routes: []ec2_types.Route{route}}}
+}
+
+func TestDiagnose_HealthyInstance_AllChecksPass(t *testing.T) {
+	clients := DoctorClients{
+		EC2: &fakeDoctorEC2{
+			instance:    *profiledInstance(),
+			subnetRoute: defaultRouteTable(ec2_types.Route{NatGatewayId: aws.String("nat-1")}),
+		},
+		IAM: &fakeIAM{roles: []string{"web-role"}, policies: map[string][]string{"web-role": {managedInstanceCorePolicy}}},
+		SSM: newAgentInfoClient(ssm_types.InstanceInformation{
+			InstanceId:       aws.String("i-0aaaaaaaa"),
+			PingStatus:       ssm_types.PingStatusOnline,
+			LastPingDateTime: aws.Time(time.Now()),
+			IsLatestVersion:  aws.Bool(true),
+		}),
+	}
+
+	checks, err := Diagnose(context.Background(), clients, "us-east-1", "i-0aaaaaaaa")
+
+	require.NoError(t, err)
+	require.Len(t, checks, 5)
+	for _, c := range checks {
+		assert.Equal(t, CheckOK, c.Status, "%s: %s", c.Name, c.Detail)
+	}
+}
+
+func TestCheckInstanceState_Stopped_Fails(t *testing.T) {
+	inst := profiledInstance()
+	inst.State.Name = ec2_types.InstanceStateNameStopped
+
+	check := checkInstanceState(inst)
+
+	assert.Equal(t, CheckFail, check.Status)
+	assert.Contains(t, check.Detail, "stopped")
+}
+
+func TestCheckInstanceProfile_Missing_Fails(t *testing.T) {
+	inst := profiledInstance()
+	inst.IamInstanceProfile = nil
+
+	assert.Equal(t, CheckFail, checkInstanceProfile(inst).Status)
+	assert.Equal(t, CheckSkip, checkManagedPolicy(context.Background(), &fakeIAM{}, inst).Status)
+}
+
+func TestCheckManagedPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		iam  *fakeIAM
+		want CheckStatus
+	}{
+		{name: "core policy attached", iam: &fakeIAM{roles: []string{"r"}, policies: map[string][]string{"r": {"ReadOnlyAccess", managedInstanceCorePolicy}}}, want: CheckOK},
+		{name: "deprecated policy", iam: &fakeIAM{roles: []string{"r"}, policies: map[string][]string{"r": {deprecatedSSMRolePolicy}}}, want: CheckWarn},
+		{name: "policy missing", iam: &fakeIAM{roles: []string{"r"}, policies: map[string][]string{"r": {"ReadOnlyAccess"}}}, want: CheckFail},
+		{name: "profile without role", iam: &fakeIAM{}, want: CheckFail},
+		{name: "access denied", iam: &fakeIAM{err: fmt.Errorf("AccessDenied")}, want: CheckSkip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := checkManagedPolicy(context.Background(), tt.iam, profiledInstance())
+
+			assert.Equal(t, tt.want, check.Status, check.Detail)
+		})
+	}
+}
+
+func TestCheckNetworkPath(t *testing.T) {
+	allEndpoints := []ec2_types.VpcEndpoint{
+		{ServiceName: aws.String("com.amazonaws.us-east-1.ssm"), State: ec2_types.StateAvailable},
+		{ServiceName: aws.String("com.amazonaws.us-east-1.ssmmessages"), State: ec2_types.StateAvailable},
+		{ServiceName: aws.String("com.amazonaws.us-east-1.ec2messages"), State: ec2_types.StateAvailable},
+	}
+
+	tests := []struct {
+		name       string
+		ec2        *fakeDoctorEC2
+		publicIP   string
+		want       CheckStatus
+		wantDetail string
+	}{
+		{name: "all endpoints", ec2: &fakeDoctorEC2{endpoints: allEndpoints}, want: CheckOK},
+		{name: "nat gateway", ec2: &fakeDoctorEC2{subnetRoute: defaultRouteTable(ec2_types.Route{NatGatewayId: aws.String("nat-1")})}, want: CheckOK, wantDetail: "nat-1"},
+		{name: "main route table fallback", ec2: &fakeDoctorEC2{mainRoute: defaultRouteTable(ec2_types.Route{TransitGatewayId: aws.String("tgw-1")})}, want: CheckOK, wantDetail: "tgw-1"},
+		{name: "igw with public ip", ec2: &fakeDoctorEC2{subnetRoute: defaultRouteTable(ec2_types.Route{GatewayId: aws.String("igw-1")})}, publicIP: "1.2.3.4", want: CheckOK},
+		{name: "igw without public ip", ec2: &fakeDoctorEC2{subnetRoute: defaultRouteTable(ec2_types.Route{GatewayId: aws.String("igw-1")})}, want: CheckFail, wantDetail: "no public IP"},
+		{name: "blackhole route", ec2: &fakeDoctorEC2{subnetRoute: defaultRouteTable(ec2_types.Route{NatGatewayId: aws.String("nat-1"), State: ec2_types.RouteStateBlackhole})}, want: CheckFail},
+		{name: "partial endpoints no route", ec2: &fakeDoctorEC2{endpoints: allEndpoints[:1]}, want: CheckFail, wantDetail: "ssmmessages, ec2messages"},
+		{name: "api error", ec2: &fakeDoctorEC2{err: fmt.Errorf("UnauthorizedOperation")}, want: CheckSkip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := profiledInstance()
+			if tt.publicIP != "" {
+				inst.PublicIpAddress = aws.String(tt.publicIP)
+			}
+
+			check := checkNetworkPath(context.Background(), tt.ec2, "us-east-1", inst)
+
+			assert.Equal(t, tt.want, check.Status, check.Detail)
+			assert.Contains(t, check.Detail, tt.wantDetail)
+		})
+	}
+}
+
+func TestCheckAgentPing(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	info := func(status ssm_types.PingStatus, lastPing time.Time, latest bool) *mockSSMDescribeInstanceInfoAPI {
+		return newAgentInfoClient(ssm_types.InstanceInformation{
+			InstanceId:       aws.String("i-0aaaaaaaa"),
+			PingStatus:       status,
+			AgentVersion:     aws.String("3.3.40.0"),
+			LastPingDateTime: aws.Time(lastPing),
+			IsLatestVersion:  aws.Bool(latest),
+		})
+	}
+
+	tests := []struct {
+		name   string
+		client *mockSSMDescribeInstanceInfoAPI
+		want   CheckStatus
+	}{
+		{name: "online", client: info(ssm_types.PingStatusOnline, now.Add(-time.Minute), true), want: CheckOK},
+		{name: "stale ping", client: info(ssm_types.PingStatusOnline, now.Add(-time.Hour), true), want: CheckWarn},
+		{name: "outdated agent", client: info(ssm_types.PingStatusOnline, now.Add(-time.Minute), false), want: CheckWarn},
+		{name: "connection lost", client: info(ssm_types.PingStatusConnectionLost, now.Add(-time.Hour), true), want: CheckFail},
+		{name: "not registered", client: newAgentInfoClient(), want: CheckFail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := checkAgentPing(context.Background(), tt.client, "i-0aaaaaaaa", now)
+
+			assert.Equal(t, tt.want, check.Status, check.Detail)
+		})
+	}
+}
+
+func TestFindUnmanagedInstances_MockClients_ReturnsUnregistered(t *testing.T) {
+	ssmClient, _ := newFleetClients(fakeFleet{id: "i-0aaaaaaaa"})
+	_, ec2Client := newFleetClients(fakeFleet{id: "i-0aaaaaaaa"}, fakeFleet{id: "i-0bbbbbbbb"})
+
+	unmanaged, err := FindUnmanagedInstances(context.Background(), ssmClient, ec2Client)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"i-0bbbbbbbb"}, targetIDs(unmanaged))
+}
+
+func TestInstanceProfileName_WithPath_ReturnsLastSegment(t *testing.T) {
+	assert.Equal(t, "web-profile", instanceProfileName("arn:aws:iam::123456789012:instance-profile/path/web-profile"))
+}
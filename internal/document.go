@@ -0,0 +1,347 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const (
+	// DocumentOwnerAll lists documents of every owner.
+	DocumentOwnerAll = "All"
+
+	stringListParameter = "StringList"
+)
+
+type (
+	// CommandDocument is an SSM Command document with its declared parameters and steps.
+	CommandDocument struct {
+		Name        string
+		Description string
+		Parameters  []DocumentParameter // in declaration order
+		Steps       []string            // step names, or plugin names of schema 1.2 documents
+	}
+
+	// DocumentParameter is a parameter declared by a Command document.
+	DocumentParameter struct {
+		Name          string
+		Type          string
+		Description   string
+		Default       []string
+		HasDefault    bool
+		AllowedValues []string
+	}
+
+	// documentContent is the part of a document's JSON content gossm reads.
+	documentContent struct {
+		Description string                  `json:"description"`
+		Parameters  json.RawMessage         `json:"parameters"`
+		MainSteps   []struct{ Name string } `json:"mainSteps"`
+		Runtime     json.RawMessage         `json:"runtimeConfig"`
+	}
+
+	documentParameterContent struct {
+		Type          string          `json:"type"`
+		Description   string          `json:"description"`
+		Default       json.RawMessage `json:"default"`
+		AllowedValues []any           `json:"allowedValues"`
+	}
+)
+
+// ListCommandDocuments returns the Command documents of owner sorted by name. owner is
+// Self, Amazon, Private, Public, ThirdParty or All.
+func ListCommandDocuments(ctx context.Context, client SSMDocumentAPI, owner string) ([]ssm_types.DocumentIdentifier, error) {
+	timer := StartTimer("SSM ListDocuments API")
+	defer timer.Stop()
+
+	filters := []ssm_types.DocumentKeyValuesFilter{{Key: aws.String("DocumentType"), Values: []string{string(ssm_types.DocumentTypeCommand)}}}
+	if !strings.EqualFold(owner, DocumentOwnerAll) {
+		filters = append(filters, ssm_types.DocumentKeyValuesFilter{Key: aws.String("Owner"), Values: []string{owner}})
+	}
+
+	var docs []ssm_types.DocumentIdentifier
+	input := &ssm.ListDocumentsInput{Filters: filters, MaxResults: aws.Int32(maxOutputResults)}
+	for {
+		output, err := client.ListDocuments(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, output.DocumentIdentifiers...)
+		if output.NextToken == nil {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+	sort.Slice(docs, func(i, j int) bool { return aws.ToString(docs[i].Name) < aws.ToString(docs[j].Name) })
+	return docs, nil
+}
+
+// GetCommandDocument reads the parameters and steps of a Command document.
+func GetCommandDocument(ctx context.Context, client SSMDocumentAPI, name string) (*CommandDocument, error) {
+	timer := StartTimer("SSM GetDocument API")
+	defer timer.Stop()
+
+	output, err := client.GetDocument(ctx, &ssm.GetDocumentInput{
+		Name:           aws.String(name),
+		DocumentFormat: ssm_types.DocumentFormatJson,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.DocumentType != ssm_types.DocumentTypeCommand {
+		return nil, fmt.Errorf("document %s is a %s document, not a Command document", name, output.DocumentType)
+	}
+	return parseCommandDocument(aws.ToString(output.Name), aws.ToString(output.Content))
+}
+
+// parseCommandDocument parses the JSON content of a Command document.
+func parseCommandDocument(name, content string) (*CommandDocument, error) {
+	var c documentContent
+	if err := json.Unmarshal([]byte(content), &c); err != nil {
+		return nil, fmt.Errorf("cannot read document %s: %w", name, err)
+	}
+	doc := &CommandDocument{Name: name, Description: c.Description}
+
+	names, err := orderedKeys(c.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read parameters of document %s: %w", name, err)
+	}
+	var params map[string]documentParameterContent
+	if len(names) > 0 {
+		if err := json.Unmarshal(c.Parameters, &params); err != nil {
+			return nil, fmt.Errorf("cannot read parameters of document %s: %w", name, err)
+		}
+	}
+	for _, n := range names {
+		p := params[n]
+		param := DocumentParameter{Name: n, Type: p.Type, Description: p.Description}
+		if len(p.Default) > 0 {
+			param.Default, param.HasDefault = parameterValues(p.Default), true
+		}
+		for _, v := range p.AllowedValues {
+			param.AllowedValues = append(param.AllowedValues, fmt.Sprint(v))
+		}
+		doc.Parameters = append(doc.Parameters, param)
+	}
+
+	for _, step := range c.MainSteps {
+		doc.Steps = append(doc.Steps, step.Name)
+	}
+	if len(doc.Steps) == 0 {
+		// schema 1.2 documents name their plugins in runtimeConfig
+		if doc.Steps, err = orderedKeys(c.Runtime); err != nil {
+			return nil, fmt.Errorf("cannot read steps of document %s: %w", name, err)
+		}
+	}
+	return doc, nil
+}
+
+// orderedKeys returns the keys of a JSON object in the order they are written.
+func orderedKeys(raw json.RawMessage) ([]string, error) {
+	if len(bytes.TrimSpace(raw)) == 0 || string(bytes.TrimSpace(raw)) == "null" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// parameterValues converts a JSON parameter value to SendCommand parameter values. Lists
+// become one value per item; maps and lists of maps are passed as JSON.
+func parameterValues(raw json.RawMessage) []string {
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		values := make([]string, 0, len(list))
+		for _, item := range list {
+			values = append(values, scalarValue(item))
+		}
+		return values
+	}
+	return []string{scalarValue(raw)}
+}
+
+// scalarValue returns a JSON string without quotes, and any other JSON value as written.
+func scalarValue(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(bytes.TrimSpace(raw))
+}
+
+// Parameter returns the declared parameter with the name.
+func (d *CommandDocument) Parameter(name string) (DocumentParameter, bool) {
+	for _, p := range d.Parameters {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return DocumentParameter{}, false
+}
+
+// ParseParams parses key=value parameter flags. A StringList parameter may be given
+// several times to pass several values.
+func (d *CommandDocument) ParseParams(kvs []string) (map[string][]string, error) {
+	params := make(map[string][]string, len(kvs))
+	for _, kv := range kvs {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid parameter %q (must be name=value)", kv)
+		}
+		p, ok := d.Parameter(name)
+		if !ok {
+			return nil, fmt.Errorf("document %s has no parameter %q (parameters: %s)", d.Name, name, strings.Join(d.parameterNames(), ", "))
+		}
+		if len(p.AllowedValues) > 0 && !slices.Contains(p.AllowedValues, value) {
+			return nil, fmt.Errorf("invalid value %q for parameter %s (must be one of %s)", value, name, strings.Join(p.AllowedValues, ", "))
+		}
+		if _, seen := params[name]; seen && p.Type != stringListParameter {
+			return nil, fmt.Errorf("parameter %s is given more than once but is not a StringList", name)
+		}
+		params[name] = append(params[name], value)
+	}
+	return params, nil
+}
+
+// Missing returns the names of required parameters, those without a default, that params
+// does not set.
+func (d *CommandDocument) Missing(params map[string][]string) []string {
+	var missing []string
+	for _, p := range d.Parameters {
+		if _, ok := params[p.Name]; !ok && !p.HasDefault {
+			missing = append(missing, p.Name)
+		}
+	}
+	return missing
+}
+
+func (d *CommandDocument) parameterNames() []string {
+	names := make([]string, 0, len(d.Parameters))
+	for _, p := range d.Parameters {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// AskDocument asks you which selects a document.
+func AskDocument(docs []ssm_types.DocumentIdentifier) (string, error) {
+	if len(docs) == 0 {
+		return "", fmt.Errorf("not found Command documents")
+	}
+	options := make([]string, 0, len(docs))
+	for _, d := range docs {
+		options = append(options, aws.ToString(d.Name))
+	}
+
+	var name string
+	prompt := &survey.Select{
+		Message: "Choose a document:",
+		Options: options,
+		Description: func(value string, index int) string {
+			return aws.ToString(docs[index].Owner)
+		},
+	}
+	if err := survey.AskOne(prompt, &name, survey.WithIcons(func(icons *survey.IconSet) {
+		icons.SelectFocus.Format = "green+hb"
+	}), survey.WithPageSize(20)); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// AskDocumentParameters asks for the value of every parameter that params does not set.
+// Values equal to the parameter's default are left out so SSM applies the default.
+func AskDocumentParameters(doc *CommandDocument, params map[string][]string) (map[string][]string, error) {
+	result := make(map[string][]string, len(doc.Parameters))
+	for k, v := range params {
+		result[k] = v
+	}
+
+	for _, p := range doc.Parameters {
+		if _, ok := result[p.Name]; ok {
+			continue
+		}
+		message := fmt.Sprintf("%s (%s):", p.Name, p.Type)
+		var opts []survey.AskOpt
+		if !p.HasDefault {
+			opts = append(opts, survey.WithValidator(survey.Required))
+		}
+
+		var values []string
+		switch {
+		case len(p.AllowedValues) > 0:
+			var value string
+			prompt := &survey.Select{Message: message, Options: p.AllowedValues, Help: p.Description}
+			if p.HasDefault && len(p.Default) == 1 && slices.Contains(p.AllowedValues, p.Default[0]) {
+				prompt.Default = p.Default[0]
+			}
+			if err := survey.AskOne(prompt, &value, opts...); err != nil {
+				return nil, err
+			}
+			values = []string{value}
+		case p.Type == stringListParameter:
+			var value string
+			prompt := &survey.Multiline{Message: message + " one value per line", Default: strings.Join(p.Default, "\n"), Help: p.Description}
+			if err := survey.AskOne(prompt, &value, opts...); err != nil {
+				return nil, err
+			}
+			values = strings.Split(strings.TrimRight(value, "\n"), "\n")
+		default:
+			var value string
+			prompt := &survey.Input{Message: message, Default: strings.Join(p.Default, ","), Help: p.Description}
+			if err := survey.AskOne(prompt, &value, opts...); err != nil {
+				return nil, err
+			}
+			values = []string{value}
+		}
+
+		if p.HasDefault && slices.Equal(values, p.Default) {
+			continue
+		}
+		result[p.Name] = values
+	}
+	return result, nil
+}
+
+// StepInvocationInputs returns one input per step of every input, since GetCommandInvocation
+// needs a step name for documents with several steps. Inputs are returned as they are for
+// documents with a single step.
+func StepInvocationInputs(inputs []*ssm.GetCommandInvocationInput, steps []string) []*ssm.GetCommandInvocationInput {
+	if len(steps) <= 1 {
+		return inputs
+	}
+	expanded := make([]*ssm.GetCommandInvocationInput, 0, len(inputs)*len(steps))
+	for _, input := range inputs {
+		for _, step := range steps {
+			expanded = append(expanded, &ssm.GetCommandInvocationInput{
+				CommandId:  input.CommandId,
+				InstanceId: input.InstanceId,
+				PluginName: aws.String(step),
+			})
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const patchDocument = `{
+  "schemaVersion": "2.2",
+  "description": "Patch and reboot",
+  "parameters": {
+    "operation": {"type": "String", "default": "Scan", "allowedValues": ["Scan", "Install"]},
+    "packages": {"type": "StringList", "description": "packages to update", "default": ["kernel", "openssl"]},
+    "rebootAfter": {"type": "Boolean", "default": true},
+    "ticket": {"type": "String"}
+  },
+  "mainSteps": [
+    {"action": "aws:runShellScript", "name": "patch"},
+    {"action": "aws:runShellScript", "name": "reboot"}
+  ]
+}`
+
+// fakeDocuments serves documents from memory.
+type fakeDocuments struct {
+	pages   [][]string
+	inputs  []*ssm.ListDocumentsInput
+	content map[string]string
+	docType ssm_types.DocumentType
+}
+
+func (f *fakeDocuments) ListDocuments(ctx context.Context, params *ssm.ListDocumentsInput, optFns ...func(*ssm.Options)) (*ssm.ListDocumentsOutput, error) {
+	f.inputs = append(f.inputs, params)
+	page := len(f.inputs) - 1
+	output := &ssm.ListDocumentsOutput{}
+	for _, name := range f.pages[page] {
+		output.DocumentIdentifiers = append(output.DocumentIdentifiers, ssm_types.DocumentIdentifier{Name: aws.String(name)})
+	}
+	if page < len(f.pages)-1 {
+		output.NextToken = aws.String("next")
+	}
+	return output, nil
+}
+
+func (f *fakeDocuments) GetDocument(ctx context.Context, params *ssm.GetDocumentInput, optFns ...func(*ssm.Options)) (*ssm.GetDocumentOutput, error) {
+	return &ssm.GetDocumentOutput{Name: params.Name, DocumentType: f.docType, Content: aws.String(f.content[aws.ToString(params.Name)])}, nil
+}
+
+func TestParseCommandDocument_KeepsDeclarationOrder(t *testing.T) {
+	doc, err := parseCommandDocument("Acme-Patch", patchDocument)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Patch and reboot", doc.Description)
+	assert.Equal(t, []string{"operation", "packages", "rebootAfter", "ticket"}, doc.parameterNames())
+	assert.Equal(t, []string{"patch", "reboot"}, doc.Steps)
+
+	packages, _ := doc.Parameter("packages")
+	assert.Equal(t, []string{"kernel", "openssl"}, packages.Default)
+	reboot, _ := doc.Parameter("rebootAfter")
+	assert.Equal(t, []string{"true"}, reboot.Default)
+	operation, _ := doc.Parameter("operation")
+	assert.Equal(t, []string{"Scan", "Install"}, operation.AllowedValues)
+	ticket, _ := doc.Parameter("ticket")
+	assert.False(t, ticket.HasDefault)
+}
+
+func TestParseCommandDocument_Schema12_UsesPluginNames(t *testing.T) {
+	doc, err := parseCommandDocument("Legacy", `{"schemaVersion": "1.2", "runtimeConfig": {"aws:runShellScript": {"properties": []}}}`)
+	require.NoError(t, err)
+
+	assert.Empty(t, doc.Parameters)
+	assert.Equal(t, []string{"aws:runShellScript"}, doc.Steps)
+}
+
+func TestCommandDocument_ParseParams(t *testing.T) {
+	doc, err := parseCommandDocument("Acme-Patch", patchDocument)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		kvs     []string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{name: "values", kvs: []string{"operation=Install", "ticket=OPS-1"}, want: map[string][]string{"operation": {"Install"}, "ticket": {"OPS-1"}}},
+		{name: "string list repeated", kvs: []string{"packages=curl", "packages=git"}, want: map[string][]string{"packages": {"curl", "git"}}},
+		{name: "value with equals", kvs: []string{"ticket=a=b"}, want: map[string][]string{"ticket": {"a=b"}}},
+		{name: "unknown parameter", kvs: []string{"color=red"}, wantErr: true},
+		{name: "not allowed", kvs: []string{"operation=Remove"}, wantErr: true},
+		{name: "string repeated", kvs: []string{"ticket=1", "ticket=2"}, wantErr: true},
+		{name: "no equals", kvs: []string{"ticket"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := doc.ParseParams(tt.kvs)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCommandDocument_Missing_ReturnsRequiredParameters(t *testing.T) {
+	doc, err := parseCommandDocument("Acme-Patch", patchDocument)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"ticket"}, doc.Missing(map[string][]string{"operation": {"Install"}}))
+	assert.Empty(t, doc.Missing(map[string][]string{"ticket": {"OPS-1"}}))
+}
+
+func TestListCommandDocuments_PagesAndFiltersByOwner(t *testing.T) {
+	client := &fakeDocuments{pages: [][]string{{"Zeta"}, {"Alpha"}}}
+
+	docs, err := ListCommandDocuments(context.Background(), client, "Self")
+	require.NoError(t, err)
+	allClient := &fakeDocuments{pages: [][]string{{"Alpha"}}}
+	_, err = ListCommandDocuments(context.Background(), allClient, "all")
+	require.NoError(t, err)
+
+	require.Len(t, docs, 2)
+	assert.Equal(t, "Alpha", aws.ToString(docs[0].Name))
+	require.Len(t, client.inputs, 2)
+	assert.Equal(t, "next", aws.ToString(client.inputs[1].NextToken))
+	assert.Len(t, client.inputs[0].Filters, 2)
+	assert.Len(t, allClient.inputs[0].Filters, 1)
+}
+
+func TestGetCommandDocument_NotCommand_ReturnsError(t *testing.T) {
+	client := &fakeDocuments{docType: ssm_types.DocumentTypeAutomation, content: map[string]string{"Acme-Patch": patchDocument}}
+
+	_, err := GetCommandDocument(context.Background(), client, "Acme-Patch")
+
+	assert.ErrorContains(t, err, "not a Command document")
+}
+
+func TestStepInvocationInputs(t *testing.T) {
+	inputs := []*ssm.GetCommandInvocationInput{
+		{CommandId: aws.String("cmd-1"), InstanceId: aws.String("i-0aaaaaaaa")},
+		{CommandId: aws.String("cmd-1"), InstanceId: aws.String("i-0bbbbbbbb")},
+	}
+
+	single := StepInvocationInputs(inputs, []string{"patch"})
+	several := StepInvocationInputs(inputs, []string{"patch", "reboot"})
+
+	assert.Equal(t, inputs, single)
+	require.Len(t, several, 4)
+	assert.Equal(t, "i-0aaaaaaaa", aws.ToString(several[1].InstanceId))
+	assert.Equal(t, "reboot", aws.ToString(several[1].PluginName))
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwl_types "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/fatih/color"
+)
+
+const (
+	// followDrainPolls bounds how often the log streams of a finished invocation are read
+	// for output that reached CloudWatch after the final status.
+	followDrainPolls = 5
+)
+
+type (
+	// logTail reads new events of one log stream on every poll.
+	logTail struct {
+		logs   CloudWatchLogsGetLogEventsAPI
+		stream string
+		next   *string
+	}
+
+	// invocationTail prints the stdout and stderr log streams of one invocation as they grow.
+	invocationTail struct {
+		prefix   string
+		stdout   *logTail
+		stderr   *logTail
+		streamed bool
+	}
+)
+
+// poll returns the messages written to the stream since the last poll. A stream that does
+// not exist yet has no messages.
+func (t *logTail) poll(ctx context.Context) ([]string, error) {
+	var messages []string
+	for {
+		output, err := t.logs.GetLogEvents(ctx, &cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  aws.String(shellScriptLogGroup),
+			LogStreamName: aws.String(t.stream),
+			StartFromHead: aws.Bool(true),
+			NextToken:     t.next,
+		})
+		var notFound *cwl_types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return messages, nil
+		}
+		if err != nil {
+			return messages, err
+		}
+		for _, event := range output.Events {
+			messages = append(messages, aws.ToString(event.Message))
+		}
+		// the forward token stays the same once the end of the stream is reached
+		done := output.NextForwardToken == nil || aws.ToString(output.NextForwardToken) == aws.ToString(t.next)
+		if output.NextForwardToken != nil {
+			t.next = output.NextForwardToken
+		}
+		if done || len(output.Events) == 0 {
+			return messages, nil
+		}
+	}
+}
+
+// newInvocationTail returns the tail of an invocation whose lines are printed after prefix.
+func newInvocationTail(logs CloudWatchLogsGetLogEventsAPI, commandID, instanceID, prefix string) *invocationTail {
+	return &invocationTail{
+		prefix: prefix,
+		stdout: &logTail{logs: logs, stream: LogStreamName(commandID, instanceID, "stdout")},
+		stderr: &logTail{logs: logs, stream: LogStreamName(commandID, instanceID, "stderr")},
+	}
+}
+
+// print prints the lines written since the last call and returns how many there were.
+// A nil tail prints nothing.
+func (t *invocationTail) print(ctx context.Context) int {
+	if t == nil {
+		return 0
+	}
+	n := 0
+	for _, tail := range []*logTail{t.stdout, t.stderr} {
+		lines, err := tail.poll(ctx)
+		if err != nil {
+			DebugLog("cannot read log stream %s: %v", tail.stream, err)
+		}
+		for _, line := range lines {
+			if tail == t.stderr {
+				line = color.RedString(line)
+			}
+			fmt.Printf("%s %s\n", t.prefix, line)
+		}
+		n += len(lines)
+	}
+	if n > 0 {
+		t.streamed = true
+	}
+	return n
+}
+
+// drain prints the output that reaches CloudWatch after the invocation finished, polling
+// every second on clk, and reports whether any output was streamed. A nil tail reports false.
+func (t *invocationTail) drain(ctx context.Context, clk clock) bool {
+	if t == nil {
+		return false
+	}
+	for i := 0; i < followDrainPolls; i++ {
+		if t.print(ctx) == 0 && i > 0 {
+			break
+		}
+		if !sleep(ctx, clk, time.Second) {
+			break
+		}
+	}
+	return t.streamed
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwl_types "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// growingLogs is a log stream the test appends to. It does not exist until created is set.
+type growingLogs struct {
+	messages []string
+	created  bool
+}
+
+func (g *growingLogs) GetLogEvents(ctx context.Context, params *cloudwatchlogs.GetLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	if !g.created {
+		return nil, &cwl_types.ResourceNotFoundException{}
+	}
+	// the token is as long as the number of messages read so far
+	seen := len(aws.ToString(params.NextToken))
+	output := &cloudwatchlogs.GetLogEventsOutput{NextForwardToken: aws.String(strings.Repeat("f", len(g.messages)))}
+	for _, msg := range g.messages[seen:] {
+		output.Events = append(output.Events, cwl_types.OutputLogEvent{Message: aws.String(msg)})
+	}
+	return output, nil
+}
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	orig := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = orig }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestLogTail_Poll_ReturnsOnlyNewMessages(t *testing.T) {
+	logs := &growingLogs{}
+	tail := &logTail{logs: logs, stream: "s"}
+	ctx := context.Background()
+	poll := func() []string {
+		lines, err := tail.poll(ctx)
+		require.NoError(t, err)
+		return lines
+	}
+
+	notCreated := poll()
+	logs.created, logs.messages = true, []string{"one"}
+	first := poll()
+	logs.messages = append(logs.messages, "two", "three")
+	second := poll()
+	caughtUp := poll()
+
+	assert.Nil(t, notCreated)
+	assert.Equal(t, []string{"one"}, first)
+	assert.Equal(t, []string{"two", "three"}, second)
+	assert.Nil(t, caughtUp)
+}
+
+func TestPrintCommandInvocation_Follow_DoesNotRepeatStreamedOutput(t *testing.T) {
+	polls := 0
+	mock := &mockSSMCommandAPI{
+		getCommandInvocationFunc: func(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+			return &ssm.GetCommandInvocationOutput{Status: ssm_types.CommandInvocationStatusSuccess, StandardOutputContent: aws.String("inline")}, nil
+		},
+		listCommandInvocationsFunc: func(ctx context.Context, params *ssm.ListCommandInvocationsInput, optFns ...func(*ssm.Options)) (*ssm.ListCommandInvocationsOutput, error) {
+			polls++
+			status := ssm_types.CommandInvocationStatusInProgress
+			if polls >= 2 {
+				status = ssm_types.CommandInvocationStatusSuccess
+			}
+			return listedAs(status, "i-0aaaaaaaa")(ctx, params, optFns...)
+		},
+	}
+	logs := &growingLogs{messages: []string{"streamed"}, created: true}
+
+	out := captureStdout(t, func() {
+		PrintCommandInvocation(context.Background(), mock, []*ssm.GetCommandInvocationInput{
+			{CommandId: aws.String("cmd-1"), InstanceId: aws.String("i-0aaaaaaaa")},
+		}, WatchOptions{Follow: logs, clock: &fakeClock{}})
+	})
+
+	assert.Contains(t, out, "streamed")
+	assert.NotContains(t, out, "inline")
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/fatih/color"
+)
+
+type (
+	// ResultGroup is a set of invocations with the same status and output.
+	ResultGroup struct {
+		resultKey
+		Results []InvocationResult
+	}
+
+	// resultKey is what invocations of one ResultGroup have in common.
+	resultKey struct {
+		Status        ssm_types.CommandInvocationStatus
+		StatusDetails string
+		ResponseCode  int32
+		Skipped       string
+		Err           string
+		Stdout        string
+		Stderr        string
+	}
+)
+
+// GroupResults groups results by status, exit code and output, the largest group first.
+func GroupResults(results []InvocationResult) []ResultGroup {
+	var groups []ResultGroup
+	index := make(map[resultKey]int)
+	for _, r := range results {
+		key := resultKey{
+			Status:        r.Status,
+			StatusDetails: r.StatusDetails,
+			ResponseCode:  r.ResponseCode,
+			Skipped:       r.Skipped,
+			Stdout:        r.Stdout,
+			Stderr:        r.Stderr,
+		}
+		if r.Err != nil {
+			key.Err = r.Err.Error()
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, ResultGroup{resultKey: key})
+		}
+		groups[i].Results = append(groups[i].Results, r)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].Results) > len(groups[j].Results) })
+	return groups
+}
+
+// PrintResultGroups prints each group's output once, after the instances it came from.
+// With diff, the groups after the first show how their output differs from the first's.
+// Groups that did not run the command have no output to compare.
+func PrintResultGroups(groups []ResultGroup, diff bool) {
+	for i, g := range groups {
+		fmt.Printf("%s %d instance(s): %s\n", color.CyanString("[group %d/%d]", i+1, len(groups)), len(g.Results), g.describe())
+		fmt.Printf("  %s\n", g.instances())
+		if diff && i > 0 && groups[0].ran() && g.ran() {
+			printGroupDiff(groups[0], g, i+1)
+			continue
+		}
+		printGroupOutput(g)
+	}
+}
+
+// describe returns the status of the group as the watch prints it.
+func (g ResultGroup) describe() string {
+	switch {
+	case g.Err != "":
+		return color.RedString("error") + " " + g.Err
+	case g.Skipped != "":
+		return color.YellowString(g.Skipped)
+	case g.Status == ssm_types.CommandInvocationStatusSuccess:
+		return color.GreenString("success")
+	default:
+		return fmt.Sprintf("%s status: %s, exit code: %d", color.RedString("failed"), color.RedString(g.StatusDetails), g.ResponseCode)
+	}
+}
+
+// instances lists the instances of the group with their names.
+func (g ResultGroup) instances() string {
+	names := make([]string, 0, len(g.Results))
+	for _, r := range g.Results {
+		name := color.YellowString(r.InstanceID)
+		if r.Name != "" {
+			name += fmt.Sprintf(" (%s)", color.CyanString(r.Name))
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// ran reports whether the invocations of the group ran the command, so that it has output.
+func (g ResultGroup) ran() bool {
+	return g.Err == "" && g.Skipped == ""
+}
+
+// printGroupOutput prints the output of a group like the watch prints an invocation's.
+func printGroupOutput(g ResultGroup) {
+	if !g.ran() {
+		return
+	}
+	if g.Status == ssm_types.CommandInvocationStatusSuccess {
+		stdout := g.Stdout
+		if stdout == "" {
+			stdout = "(no output)"
+		}
+		fmt.Printf("%s\n", stdout)
+		return
+	}
+	if g.Stdout != "" {
+		fmt.Printf("stdout: %s\n", g.Stdout)
+	}
+	if g.Stderr != "" {
+		fmt.Printf("stderr: %s\n", color.RedString(g.Stderr))
+	}
+	if g.Stdout == "" && g.Stderr == "" {
+		fmt.Printf("(no output)\n")
+	}
+}
+
+// printGroupDiff prints the unified diff of the output of group n against the majority group.
+func printGroupDiff(majority, g ResultGroup, n int) {
+	same := true
+	for _, stream := range []struct{ name, from, to string }{
+		{"stdout", majority.Stdout, g.Stdout},
+		{"stderr", majority.Stderr, g.Stderr},
+	} {
+		diff := unifiedDiff(stream.from, stream.to, fmt.Sprintf("group 1 %s", stream.name), fmt.Sprintf("group %d %s", n, stream.name))
+		if diff == "" {
+			continue
+		}
+		same = false
+		for i, line := range splitLines(diff) {
+			switch {
+			case i < 2: // the --- and +++ headers
+				fmt.Println(line)
+			case strings.HasPrefix(line, "@@"):
+				fmt.Println(color.CyanString(line))
+			case strings.HasPrefix(line, "-"):
+				fmt.Println(color.RedString(line))
+			case strings.HasPrefix(line, "+"):
+				fmt.Println(color.GreenString(line))
+			default:
+				fmt.Println(line)
+			}
+		}
+	}
+	if same {
+		fmt.Println("(same output as group 1)")
+	}
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func groupedResult(instanceID string, status ssm_types.CommandInvocationStatus, code int32, stdout string) InvocationResult {
+	return InvocationResult{InstanceID: instanceID, Status: status, ResponseCode: code, Stdout: stdout}
+}
+
+func groupInstanceIDs(g ResultGroup) []string {
+	ids := make([]string, 0, len(g.Results))
+	for _, r := range g.Results {
+		ids = append(ids, r.InstanceID)
+	}
+	return ids
+}
+
+func TestGroupResults_LargestGroupFirst(t *testing.T) {
+	success := ssm_types.CommandInvocationStatusSuccess
+	results := []InvocationResult{
+		groupedResult("i-1", success, 0, "ID=amzn\nVERSION=2\n"),
+		groupedResult("i-2", success, 0, "ID=amzn\nVERSION=2023\n"),
+		groupedResult("i-3", success, 0, "ID=amzn\nVERSION=2023\n"),
+		groupedResult("i-4", ssm_types.CommandInvocationStatusFailed, 1, "ID=amzn\nVERSION=2023\n"),
+		{InstanceID: "i-5", ResponseCode: -1, Err: errors.New("throttled")},
+		groupedResult("i-6", success, 0, "ID=amzn\nVERSION=2023\n"),
+	}
+
+	groups := GroupResults(results)
+
+	require.Len(t, groups, 4)
+	assert.Equal(t, []string{"i-2", "i-3", "i-6"}, groupInstanceIDs(groups[0]))
+	assert.Equal(t, "ID=amzn\nVERSION=2023\n", groups[0].Stdout)
+	assert.Equal(t, []string{"i-1"}, groupInstanceIDs(groups[1]))
+	assert.Equal(t, []string{"i-4"}, groupInstanceIDs(groups[2]))
+	assert.Equal(t, int32(1), groups[2].ResponseCode)
+	assert.Equal(t, "throttled", groups[3].Err)
+}
+
+func TestPrintResultGroups_Diff_ShowsOutliersAgainstMajority(t *testing.T) {
+	success := ssm_types.CommandInvocationStatusSuccess
+	groups := GroupResults([]InvocationResult{
+		groupedResult("i-1", success, 0, "ID=amzn\nVERSION=2023\n"),
+		groupedResult("i-2", success, 0, "ID=amzn\nVERSION=2023\n"),
+		groupedResult("i-3", success, 0, "ID=amzn\nVERSION=2\n"),
+	})
+
+	grouped := captureStdout(t, func() { PrintResultGroups(groups, false) })
+	diffed := captureStdout(t, func() { PrintResultGroups(groups, true) })
+
+	assert.Contains(t, grouped, "2 instance(s)")
+	assert.Contains(t, grouped, "VERSION=2\n")
+	assert.Contains(t, diffed, "--- group 1 stdout\n+++ group 2 stdout\n")
+	assert.Contains(t, diffed, "-VERSION=2023")
+	assert.Contains(t, diffed, "+VERSION=2\n")
+}
+
+func TestPrintResultGroups_Diff_SkippedGroupIsNotDiffed(t *testing.T) {
+	success := ssm_types.CommandInvocationStatusSuccess
+	groups := GroupResults([]InvocationResult{
+		groupedResult("i-1", success, 0, "ID=amzn\nVERSION=2023\n"),
+		groupedResult("i-2", success, 0, "ID=amzn\nVERSION=2023\n"),
+		{InstanceID: "i-3", Status: ssm_types.CommandInvocationStatusCancelled, ResponseCode: -1, Skipped: "cancelled"},
+	})
+
+	diffed := captureStdout(t, func() { PrintResultGroups(groups, true) })
+
+	assert.Contains(t, diffed, "1 instance(s): cancelled")
+	assert.NotContains(t, diffed, "-ID=amzn")
+	assert.NotContains(t, diffed, "--- group 1")
+}
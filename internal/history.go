@@ -0,0 +1,280 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2_types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	// issuerCommentPrefix starts the comment gossm gives every command it sends, so that
+	// ListCommands, which does not record who sent a command, can tell our commands apart.
+	issuerCommentPrefix = "gossm "
+	// maxCommentLength is the longest comment SendCommand accepts.
+	maxCommentLength = 100
+	// maxFilterValues is the most values DescribeInstances accepts in one filter.
+	maxFilterValues = 200
+)
+
+type (
+	// HistoryOptions selects the commands ListCommandHistory returns.
+	HistoryOptions struct {
+		// Comment keeps only the commands with this comment, see IssuerComment. Empty keeps all.
+		Comment string
+		// Since keeps only the commands requested after it. Zero keeps all.
+		Since time.Time
+		// Limit is the most commands returned. Zero returns all.
+		Limit int
+	}
+
+	// RetryPlan is how exec retry resends earlier commands: their document, parameters and
+	// send options, to the instances where they did not succeed.
+	RetryPlan struct {
+		Document    string
+		Parameters  map[string][]string
+		Options     SendCommandOptions
+		Steps       []string // step names, for watching documents with several steps
+		InstanceIDs []string
+	}
+)
+
+// IssuerComment returns the comment that marks commands sent by the caller's identity.
+func IssuerComment(ctx context.Context, client STSCallerIdentityAPI) (string, error) {
+	output, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return issuerComment(aws.ToString(output.Arn)), nil
+}
+
+// issuerComment returns the comment for an identity ARN, cut to the length SendCommand accepts.
+func issuerComment(arn string) string {
+	comment := issuerCommentPrefix + arn
+	if len(comment) > maxCommentLength {
+		comment = comment[:maxCommentLength]
+	}
+	return comment
+}
+
+// ListCommandHistory returns the commands of the account and region, most recent first.
+func ListCommandHistory(ctx context.Context, client SSMCommandHistoryAPI, opts HistoryOptions) ([]ssm_types.Command, error) {
+	timer := StartTimer("SSM ListCommands API")
+	defer timer.Stop()
+
+	input := &ssm.ListCommandsInput{}
+	if !opts.Since.IsZero() {
+		input.Filters = []ssm_types.CommandFilter{{
+			Key:   ssm_types.CommandFilterKeyInvokedAfter,
+			Value: aws.String(opts.Since.UTC().Format(time.RFC3339)),
+		}}
+	}
+
+	var commands []ssm_types.Command
+	for {
+		output, err := client.ListCommands(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range output.Commands {
+			if opts.Comment == "" || aws.ToString(c.Comment) == opts.Comment {
+				commands = append(commands, c)
+			}
+		}
+		if output.NextToken == nil || (opts.Limit > 0 && len(commands) >= opts.Limit) {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+
+	sort.SliceStable(commands, func(i, j int) bool {
+		return aws.ToTime(commands[i].RequestedDateTime).After(aws.ToTime(commands[j].RequestedDateTime))
+	})
+	if opts.Limit > 0 && len(commands) > opts.Limit {
+		commands = commands[:opts.Limit]
+	}
+	return commands, nil
+}
+
+// CommandText returns what a command ran: the commands of a shell script, or the document
+// name for other documents.
+func CommandText(c ssm_types.Command) string {
+	if aws.ToString(c.DocumentName) == ShellScriptDocument {
+		return strings.Join(c.Parameters["commands"], "; ")
+	}
+	return aws.ToString(c.DocumentName)
+}
+
+// LookupCommand returns a command sent earlier, with its document, parameters and send
+// options such as its output bucket.
+func LookupCommand(ctx context.Context, client SSMCommandHistoryAPI, commandID string) (ssm_types.Command, error) {
+	output, err := client.ListCommands(ctx, &ssm.ListCommandsInput{CommandId: aws.String(commandID)})
+	if err != nil {
+		return ssm_types.Command{}, err
+	}
+	if len(output.Commands) == 0 {
+		return ssm_types.Command{}, fmt.Errorf("command %s not found; it may be in another region or older than 30 days", commandID)
+	}
+	return output.Commands[0], nil
+}
+
+// CommandInvocationInputs returns one GetCommandInvocation input per instance a command was
+// sent to, and one per step for documents with several steps, in order of instance ID. It
+// also returns the document the command ran.
+func CommandInvocationInputs(ctx context.Context, client SSMCommandHistoryAPI, commandID string) ([]*ssm.GetCommandInvocationInput, string, error) {
+	timer := StartTimer("SSM ListCommandInvocations API")
+	defer timer.Stop()
+
+	invocations, err := listCommandInvocations(ctx, client, commandID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var (
+		inputs   []*ssm.GetCommandInvocationInput
+		document string
+	)
+	for _, inv := range invocations {
+		document = aws.ToString(inv.DocumentName)
+		instanceInput := &ssm.GetCommandInvocationInput{CommandId: aws.String(commandID), InstanceId: inv.InstanceId}
+		inputs = append(inputs, StepInvocationInputs([]*ssm.GetCommandInvocationInput{instanceInput}, invocationSteps(inv))...)
+	}
+	return inputs, document, nil
+}
+
+// PlanRetry looks up commands sent earlier, such as the batches of one exec, and returns
+// how to resend them to the instances where they failed, timed out or were undeliverable.
+// The commands must run the same document.
+func PlanRetry(ctx context.Context, client SSMCommandHistoryAPI, commandIDs []string) (*RetryPlan, error) {
+	if len(commandIDs) == 0 {
+		return nil, fmt.Errorf("no command to retry")
+	}
+	timer := StartTimer("PlanRetry")
+	defer timer.Stop()
+
+	var plan *RetryPlan
+	seen := make(map[string]bool)
+	for _, id := range commandIDs {
+		c, err := LookupCommand(ctx, client, id)
+		if err != nil {
+			return nil, err
+		}
+		if plan == nil {
+			plan = newRetryPlan(c)
+		} else if document := aws.ToString(c.DocumentName); document != plan.Document {
+			return nil, fmt.Errorf("command %s runs %s, not %s; retry commands of one document at a time", id, document, plan.Document)
+		}
+
+		invocations, err := listCommandInvocations(ctx, client, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, inv := range invocations {
+			instanceID := aws.ToString(inv.InstanceId)
+			if len(plan.Steps) == 0 {
+				plan.Steps = invocationSteps(inv)
+			}
+			if !retryable(inv) || seen[instanceID] {
+				continue
+			}
+			seen[instanceID] = true
+			plan.InstanceIDs = append(plan.InstanceIDs, instanceID)
+		}
+	}
+	sort.Strings(plan.InstanceIDs)
+	return plan, nil
+}
+
+// newRetryPlan returns a plan that resends c as it was sent, to no instances yet.
+func newRetryPlan(c ssm_types.Command) *RetryPlan {
+	params := make(map[string][]string, len(c.Parameters))
+	for k, v := range c.Parameters {
+		params[k] = v
+	}
+	return &RetryPlan{
+		Document:   aws.ToString(c.DocumentName),
+		Parameters: params,
+		Options: SendCommandOptions{
+			MaxConcurrency:  aws.ToString(c.MaxConcurrency),
+			MaxErrors:       aws.ToString(c.MaxErrors),
+			DeliveryTimeout: time.Duration(aws.ToInt32(c.TimeoutSeconds)) * time.Second,
+			OutputS3Bucket:  aws.ToString(c.OutputS3BucketName),
+		},
+	}
+}
+
+// retryable reports whether an invocation failed, timed out or could not be delivered.
+func retryable(inv ssm_types.CommandInvocation) bool {
+	switch inv.Status {
+	case ssm_types.CommandInvocationStatusFailed, ssm_types.CommandInvocationStatusTimedOut:
+		return true
+	}
+	return aws.ToString(inv.StatusDetails) == "Undeliverable"
+}
+
+// listCommandInvocations returns the invocations of a command with their steps, in order
+// of instance ID.
+func listCommandInvocations(ctx context.Context, client SSMCommandHistoryAPI, commandID string) ([]ssm_types.CommandInvocation, error) {
+	input := &ssm.ListCommandInvocationsInput{CommandId: aws.String(commandID), Details: true}
+	var invocations []ssm_types.CommandInvocation
+	for {
+		output, err := client.ListCommandInvocations(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		invocations = append(invocations, output.CommandInvocations...)
+		if output.NextToken == nil {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+	sort.Slice(invocations, func(i, j int) bool {
+		return aws.ToString(invocations[i].InstanceId) < aws.ToString(invocations[j].InstanceId)
+	})
+	return invocations, nil
+}
+
+// invocationSteps returns the step names of an invocation.
+func invocationSteps(inv ssm_types.CommandInvocation) []string {
+	steps := make([]string, 0, len(inv.CommandPlugins))
+	for _, p := range inv.CommandPlugins {
+		steps = append(steps, aws.ToString(p.Name))
+	}
+	return steps
+}
+
+// InstanceNames returns the Name tag of each instance that still exists, keyed by instance ID.
+func InstanceNames(ctx context.Context, client EC2DescribeInstancesAPI, ids []string) (map[string]string, error) {
+	names := make(map[string]string, len(ids))
+	for start := 0; start < len(ids); start += maxFilterValues {
+		// a filter rather than InstanceIds, which fails for instances terminated since
+		input := &ec2.DescribeInstancesInput{Filters: []ec2_types.Filter{{
+			Name:   aws.String("instance-id"),
+			Values: ids[start:min(start+maxFilterValues, len(ids))],
+		}}}
+		for {
+			output, err := client.DescribeInstances(ctx, input)
+			if err != nil {
+				return nil, err
+			}
+			for _, rv := range output.Reservations {
+				for _, inst := range rv.Instances {
+					names[aws.ToString(inst.InstanceId)] = getInstanceName(inst.Tags)
+				}
+			}
+			if output.NextToken == nil {
+				break
+			}
+			input.NextToken = output.NextToken
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,263 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2_types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHistory serves commands and invocations one page at a time and records the inputs.
+// Commands and invocations looked up by command ID are served from byID and invocationsByID.
+type fakeHistory struct {
+	commandPages    [][]ssm_types.Command
+	invocationPages [][]ssm_types.CommandInvocation
+	byID            map[string]ssm_types.Command
+	invocationsByID map[string][]ssm_types.CommandInvocation
+	listInputs      []*ssm.ListCommandsInput
+}
+
+func (f *fakeHistory) ListCommands(ctx context.Context, params *ssm.ListCommandsInput, optFns ...func(*ssm.Options)) (*ssm.ListCommandsOutput, error) {
+	f.listInputs = append(f.listInputs, params)
+	if params.CommandId != nil {
+		c, ok := f.byID[aws.ToString(params.CommandId)]
+		if !ok {
+			return &ssm.ListCommandsOutput{}, nil
+		}
+		return &ssm.ListCommandsOutput{Commands: []ssm_types.Command{c}}, nil
+	}
+	page := len(aws.ToString(params.NextToken))
+	output := &ssm.ListCommandsOutput{Commands: f.commandPages[page]}
+	if page+1 < len(f.commandPages) {
+		output.NextToken = aws.String(strings.Repeat("n", page+1))
+	}
+	return output, nil
+}
+
+func (f *fakeHistory) ListCommandInvocations(ctx context.Context, params *ssm.ListCommandInvocationsInput, optFns ...func(*ssm.Options)) (*ssm.ListCommandInvocationsOutput, error) {
+	if f.invocationsByID != nil {
+		return &ssm.ListCommandInvocationsOutput{CommandInvocations: f.invocationsByID[aws.ToString(params.CommandId)]}, nil
+	}
+	page := len(aws.ToString(params.NextToken))
+	output := &ssm.ListCommandInvocationsOutput{CommandInvocations: f.invocationPages[page]}
+	if page+1 < len(f.invocationPages) {
+		output.NextToken = aws.String(strings.Repeat("n", page+1))
+	}
+	return output, nil
+}
+
+func historyCommand(id, comment string, requested time.Time) ssm_types.Command {
+	return ssm_types.Command{CommandId: aws.String(id), Comment: aws.String(comment), RequestedDateTime: aws.Time(requested)}
+}
+
+func commandIDsOf(commands []ssm_types.Command) []string {
+	ids := make([]string, 0, len(commands))
+	for _, c := range commands {
+		ids = append(ids, aws.ToString(c.CommandId))
+	}
+	return ids
+}
+
+func TestListCommandHistory(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	pages := [][]ssm_types.Command{
+		{historyCommand("c1", "gossm me", base), historyCommand("c3", "gossm other", base.Add(2*time.Hour))},
+		{historyCommand("c2", "gossm me", base.Add(time.Hour)), historyCommand("c4", "", base.Add(3*time.Hour))},
+	}
+
+	tests := []struct {
+		name  string
+		opts  HistoryOptions
+		want  []string
+		pages int
+	}{
+		{"all, most recent first", HistoryOptions{}, []string{"c4", "c3", "c2", "c1"}, 2},
+		{"by comment", HistoryOptions{Comment: "gossm me"}, []string{"c2", "c1"}, 2},
+		{"limit stops paging", HistoryOptions{Limit: 2}, []string{"c3", "c1"}, 1},
+		{"limit with comment", HistoryOptions{Comment: "gossm me", Limit: 1}, []string{"c1"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeHistory{commandPages: pages}
+			commands, err := ListCommandHistory(context.Background(), client, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, commandIDsOf(commands))
+			assert.Len(t, client.listInputs, tt.pages)
+		})
+	}
+}
+
+func TestListCommandHistory_Since_FiltersByInvokedAfter(t *testing.T) {
+	client := &fakeHistory{commandPages: [][]ssm_types.Command{nil}}
+	since := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("JST", 9*60*60))
+
+	_, err := ListCommandHistory(context.Background(), client, HistoryOptions{Since: since})
+	require.NoError(t, err)
+
+	require.Len(t, client.listInputs[0].Filters, 1)
+	assert.Equal(t, ssm_types.CommandFilterKeyInvokedAfter, client.listInputs[0].Filters[0].Key)
+	assert.Equal(t, "2024-05-01T03:00:00Z", aws.ToString(client.listInputs[0].Filters[0].Value))
+}
+
+func TestIssuerComment(t *testing.T) {
+	assert.Equal(t, "gossm arn:aws:iam::123456789012:user/alice", issuerComment("arn:aws:iam::123456789012:user/alice"))
+
+	long := issuerComment("arn:aws:sts::123456789012:assumed-role/AWSReservedSSO_AdministratorAccess_0123456789abcdef/alice@example.com")
+	assert.Len(t, long, maxCommentLength)
+	assert.True(t, strings.HasPrefix(long, "gossm arn:aws:sts::123456789012:assumed-role/"))
+}
+
+func TestCommandText(t *testing.T) {
+	script := ssm_types.Command{
+		DocumentName: aws.String(ShellScriptDocument),
+		Parameters:   map[string][]string{"commands": {"uptime", "df -h"}},
+	}
+	assert.Equal(t, "uptime; df -h", CommandText(script))
+	assert.Equal(t, "AWS-ConfigureAWSPackage", CommandText(ssm_types.Command{DocumentName: aws.String("AWS-ConfigureAWSPackage")}))
+}
+
+func TestCommandInvocationInputs(t *testing.T) {
+	plugins := func(names ...string) []ssm_types.CommandPlugin {
+		var ps []ssm_types.CommandPlugin
+		for _, n := range names {
+			ps = append(ps, ssm_types.CommandPlugin{Name: aws.String(n)})
+		}
+		return ps
+	}
+	client := &fakeHistory{invocationPages: [][]ssm_types.CommandInvocation{
+		{{InstanceId: aws.String("i-2"), DocumentName: aws.String(ShellScriptDocument), CommandPlugins: plugins("aws:runShellScript")}},
+		{
+			{InstanceId: aws.String("i-3"), DocumentName: aws.String(ShellScriptDocument), CommandPlugins: plugins("install", "configure")},
+			{InstanceId: aws.String("i-1"), DocumentName: aws.String(ShellScriptDocument)},
+		},
+	}}
+
+	inputs, document, err := CommandInvocationInputs(context.Background(), client, "cmd-1")
+	require.NoError(t, err)
+	assert.Equal(t, ShellScriptDocument, document)
+
+	var got []string
+	for _, input := range inputs {
+		assert.Equal(t, "cmd-1", aws.ToString(input.CommandId))
+		got = append(got, aws.ToString(input.InstanceId)+"/"+aws.ToString(input.PluginName))
+	}
+	assert.Equal(t, []string{"i-1/", "i-2/", "i-3/install", "i-3/configure"}, got)
+}
+
+func invocation(instanceID string, status ssm_types.CommandInvocationStatus, details string) ssm_types.CommandInvocation {
+	return ssm_types.CommandInvocation{InstanceId: aws.String(instanceID), Status: status, StatusDetails: aws.String(details)}
+}
+
+func TestLookupCommand(t *testing.T) {
+	client := &fakeHistory{byID: map[string]ssm_types.Command{
+		"c1": {CommandId: aws.String("c1"), OutputS3BucketName: aws.String("logs")},
+	}}
+
+	c, err := LookupCommand(context.Background(), client, "c1")
+	require.NoError(t, err)
+	assert.Equal(t, "logs", aws.ToString(c.OutputS3BucketName))
+
+	_, err = LookupCommand(context.Background(), client, "c2")
+	assert.ErrorContains(t, err, "command c2 not found")
+}
+
+func TestPlanRetry(t *testing.T) {
+	script := ssm_types.Command{
+		DocumentName:       aws.String(ShellScriptDocument),
+		Parameters:         map[string][]string{"commands": {"deploy"}, "executionTimeout": {"600"}},
+		MaxConcurrency:     aws.String("10%"),
+		MaxErrors:          aws.String("5"),
+		TimeoutSeconds:     aws.Int32(120),
+		OutputS3BucketName: aws.String("logs"),
+	}
+	client := &fakeHistory{
+		byID: map[string]ssm_types.Command{"c1": script, "c2": script},
+		invocationsByID: map[string][]ssm_types.CommandInvocation{
+			"c1": {
+				invocation("i-1", ssm_types.CommandInvocationStatusSuccess, "Success"),
+				invocation("i-3", ssm_types.CommandInvocationStatusFailed, "Failed"),
+				invocation("i-2", ssm_types.CommandInvocationStatusTimedOut, "ExecutionTimedOut"),
+			},
+			"c2": {
+				invocation("i-4", ssm_types.CommandInvocationStatusFailed, "Undeliverable"),
+				invocation("i-5", ssm_types.CommandInvocationStatusCancelled, "Cancelled"),
+				invocation("i-6", ssm_types.CommandInvocationStatusInProgress, "InProgress"),
+			},
+		},
+	}
+
+	plan, err := PlanRetry(context.Background(), client, []string{"c1", "c2"})
+	require.NoError(t, err)
+
+	assert.Equal(t, ShellScriptDocument, plan.Document)
+	assert.Equal(t, script.Parameters, plan.Parameters)
+	assert.Equal(t, SendCommandOptions{MaxConcurrency: "10%", MaxErrors: "5", DeliveryTimeout: 2 * time.Minute, OutputS3Bucket: "logs"}, plan.Options)
+	assert.Equal(t, []string{"i-2", "i-3", "i-4"}, plan.InstanceIDs)
+
+	// the plan's parameters can be changed without changing the original command
+	plan.Parameters["commands"] = []string{"rollback"}
+	assert.Equal(t, []string{"deploy"}, script.Parameters["commands"])
+}
+
+func TestPlanRetry_Errors(t *testing.T) {
+	client := &fakeHistory{
+		byID: map[string]ssm_types.Command{
+			"c1": {DocumentName: aws.String(ShellScriptDocument)},
+			"c2": {DocumentName: aws.String("AWS-ConfigureAWSPackage")},
+		},
+		invocationsByID: map[string][]ssm_types.CommandInvocation{},
+	}
+
+	_, err := PlanRetry(context.Background(), client, []string{"c1", "missing"})
+	assert.ErrorContains(t, err, "command missing not found")
+
+	_, err = PlanRetry(context.Background(), client, []string{"c1", "c2"})
+	assert.ErrorContains(t, err, "runs AWS-ConfigureAWSPackage")
+
+	_, err = PlanRetry(context.Background(), client, nil)
+	assert.Error(t, err)
+}
+
+// fakeNameEC2 returns a Name tag for every instance in the filter except "i-gone".
+type fakeNameEC2 struct {
+	calls int
+}
+
+func (f *fakeNameEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	f.calls++
+	var instances []ec2_types.Instance
+	for _, id := range params.Filters[0].Values {
+		if id == "i-gone" {
+			continue
+		}
+		instances = append(instances, ec2_types.Instance{
+			InstanceId: aws.String(id),
+			Tags:       []ec2_types.Tag{{Key: aws.String("Name"), Value: aws.String("name-" + id)}},
+		})
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []ec2_types.Reservation{{Instances: instances}}}, nil
+}
+
+func TestInstanceNames(t *testing.T) {
+	ids := []string{"i-gone"}
+	for i := 0; i < maxFilterValues; i++ {
+		ids = append(ids, "i-"+strings.Repeat("a", i+1))
+	}
+	client := &fakeNameEC2{}
+
+	names, err := InstanceNames(context.Background(), client, ids)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, client.calls)
+	assert.Len(t, names, maxFilterValues)
+	assert.Equal(t, "name-i-a", names["i-a"])
+	assert.NotContains(t, names, "i-gone")
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// inlineOutputLimit is how many characters of each stream GetCommandInvocation returns.
+	inlineOutputLimit = 24000
+
+	// where AWS-RunShellScript output goes when CloudWatch output is enabled without a log group
+	shellScriptLogGroup = "/aws/ssm/" + ShellScriptDocument
+
+	// names of the runShellScript plugin in CloudWatch log streams and S3 keys
+	shellScriptStreamPlugin = "aws-runShellScript"
+	shellScriptS3Plugin     = "awsrunShellScript/0.awsrunShellScript"
+)
+
+// scriptOutputs are where the plugins of the script documents write their output.
+var scriptOutputs = map[string]scriptOutput{
+	ShellScriptDocument: {
+		logGroup:     shellScriptLogGroup,
+		streamPlugin: shellScriptStreamPlugin,
+		s3Plugin:     shellScriptS3Plugin,
+	},
+	PowerShellScriptDocument: {
+		logGroup:     "/aws/ssm/" + PowerShellScriptDocument,
+		streamPlugin: "aws-runPowerShellScript",
+		s3Plugin:     "awsrunPowerShellScript/0.awsrunPowerShellScript",
+	},
+}
+
+type (
+	// FullOutput fetches the complete stdout and stderr of an invocation when the inline
+	// output of GetCommandInvocation is truncated: from S3 when the command wrote its output
+	// to Bucket, otherwise from the CloudWatch Logs group of the document.
+	FullOutput struct {
+		Logs   CloudWatchLogsGetLogEventsAPI
+		S3     S3GetObjectAPI
+		Bucket string
+		// Document is the script document that ran the command; empty is AWS-RunShellScript.
+		Document string
+	}
+
+	// scriptOutput names the CloudWatch log group of a script document and its plugin in
+	// log streams and S3 keys.
+	scriptOutput struct {
+		logGroup     string
+		streamPlugin string
+		s3Plugin     string
+	}
+)
+
+// Truncated reports whether inline output may have been cut at the GetCommandInvocation limit.
+func Truncated(inline string) bool {
+	return utf8.RuneCountInString(inline) >= inlineOutputLimit
+}
+
+// Complete returns the full stream ("stdout" or "stderr") of the invocation when inline is
+// truncated, or inline itself when it is not.
+func (f *FullOutput) Complete(ctx context.Context, commandID, instanceID, stream, inline string) (string, error) {
+	if !Truncated(inline) {
+		return inline, nil
+	}
+	if f == nil {
+		return inline, fmt.Errorf("no CloudWatch Logs or S3 output configured")
+	}
+	if _, ok := f.output(); !ok {
+		return inline, fmt.Errorf("the full output of %s commands is in CloudWatch Logs group /aws/ssm/%s or the output bucket", f.Document, f.Document)
+	}
+	if f.Bucket != "" {
+		return f.fromS3(ctx, commandID, instanceID, stream)
+	}
+	return f.fromLogs(ctx, commandID, instanceID, stream)
+}
+
+// fromS3 reads a stream written to the output bucket.
+func (f *FullOutput) fromS3(ctx context.Context, commandID, instanceID, stream string) (string, error) {
+	where, _ := f.output()
+	key := strings.Join([]string{commandID, instanceID, where.s3Plugin, stream}, "/")
+	output, err := f.S3.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(f.Bucket), Key: aws.String(key)})
+	if err != nil {
+		return "", fmt.Errorf("s3://%s/%s: %w", f.Bucket, key, err)
+	}
+	defer output.Body.Close()
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return "", fmt.Errorf("s3://%s/%s: %w", f.Bucket, key, err)
+	}
+	return string(data), nil
+}
+
+// fromLogs reads every event of a stream in the log group of the document.
+func (f *FullOutput) fromLogs(ctx context.Context, commandID, instanceID, stream string) (string, error) {
+	where, _ := f.output()
+	streamName := strings.Join([]string{commandID, instanceID, where.streamPlugin, stream}, "/")
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(where.logGroup),
+		LogStreamName: aws.String(streamName),
+		StartFromHead: aws.Bool(true),
+	}
+
+	var b strings.Builder
+	for {
+		output, err := f.Logs.GetLogEvents(ctx, input)
+		if err != nil {
+			return "", fmt.Errorf("log stream %s: %w", streamName, err)
+		}
+		for _, event := range output.Events {
+			b.WriteString(aws.ToString(event.Message))
+			b.WriteString("\n")
+		}
+		// the forward token stays the same once the end of the stream is reached
+		if output.NextForwardToken == nil || aws.ToString(output.NextForwardToken) == aws.ToString(input.NextToken) {
+			break
+		}
+		input.NextToken = output.NextForwardToken
+	}
+	return b.String(), nil
+}
+
+// output returns where the document of the command writes its output, and false for
+// documents other than the script documents, whose plugins name their output by step.
+func (f *FullOutput) output() (scriptOutput, bool) {
+	if f.Document == "" {
+		return scriptOutputs[ShellScriptDocument], true
+	}
+	output, ok := scriptOutputs[f.Document]
+	return output, ok
+}
+
+// LogStreamName returns the CloudWatch log stream of an invocation's stdout or stderr.
+func LogStreamName(commandID, instanceID, stream string) string {
+	return strings.Join([]string{commandID, instanceID, shellScriptStreamPlugin, stream}, "/")
+}
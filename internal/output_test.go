@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwl_types "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLogs serves log events one page at a time and records the streams read.
+type fakeLogs struct {
+	pages   [][]string
+	streams []string
+}
+
+func (f *fakeLogs) GetLogEvents(ctx context.Context, params *cloudwatchlogs.GetLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	f.streams = append(f.streams, aws.ToString(params.LogGroupName)+":"+aws.ToString(params.LogStreamName))
+	page := 0
+	if params.NextToken != nil {
+		page = len(aws.ToString(params.NextToken))
+	}
+	output := &cloudwatchlogs.GetLogEventsOutput{NextForwardToken: aws.String(strings.Repeat("f", min(page+1, len(f.pages))))}
+	if page < len(f.pages) {
+		for _, msg := range f.pages[page] {
+			output.Events = append(output.Events, cwl_types.OutputLogEvent{Message: aws.String(msg)})
+		}
+	}
+	return output, nil
+}
+
+// fakeS3 returns the objects it holds.
+type fakeS3 struct {
+	objects map[string]string
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	body, ok := f.objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)]
+	if !ok {
+		return nil, assert.AnError
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+func TestFullOutput_Complete_NotTruncated_ReturnsInline(t *testing.T) {
+	logs := &fakeLogs{}
+	full := &FullOutput{Logs: logs}
+
+	got, err := full.Complete(context.Background(), "cmd-1", "i-0aaaaaaaa", "stdout", "short")
+
+	require.NoError(t, err)
+	assert.Equal(t, "short", got)
+	assert.Empty(t, logs.streams)
+}
+
+func TestFullOutput_Complete_MultibyteUnderLimit_ReturnsInline(t *testing.T) {
+	logs := &fakeLogs{}
+	full := &FullOutput{Logs: logs}
+	// fewer characters than the limit, but more bytes
+	inline := strings.Repeat("é", inlineOutputLimit-1)
+
+	got, err := full.Complete(context.Background(), "cmd-1", "i-0aaaaaaaa", "stdout", inline)
+
+	require.NoError(t, err)
+	assert.Equal(t, inline, got)
+	assert.Empty(t, logs.streams)
+}
+
+func TestFullOutput_Complete_Truncated_ReadsAllLogPages(t *testing.T) {
+	logs := &fakeLogs{pages: [][]string{{"line 1", "line 2"}, {"line 3"}}}
+	full := &FullOutput{Logs: logs}
+
+	got, err := full.Complete(context.Background(), "cmd-1", "i-0aaaaaaaa", "stderr", strings.Repeat("x", inlineOutputLimit))
+
+	require.NoError(t, err)
+	assert.Equal(t, "line 1\nline 2\nline 3\n", got)
+	assert.Equal(t, "/aws/ssm/AWS-RunShellScript:cmd-1/i-0aaaaaaaa/aws-runShellScript/stderr", logs.streams[0])
+}
+
+func TestFullOutput_Complete_PowerShell_ReadsItsLogGroup(t *testing.T) {
+	logs := &fakeLogs{pages: [][]string{{"line 1"}}}
+	full := &FullOutput{Logs: logs, Document: PowerShellScriptDocument}
+
+	_, err := full.Complete(context.Background(), "cmd-1", "i-0aaaaaaaa", "stdout", strings.Repeat("x", inlineOutputLimit))
+
+	require.NoError(t, err)
+	assert.Equal(t, "/aws/ssm/AWS-RunPowerShellScript:cmd-1/i-0aaaaaaaa/aws-runPowerShellScript/stdout", logs.streams[0])
+}
+
+func TestFullOutput_Complete_Bucket_ReadsFromS3(t *testing.T) {
+	full := &FullOutput{
+		S3:     &fakeS3{objects: map[string]string{"out/cmd-1/i-0aaaaaaaa/awsrunShellScript/0.awsrunShellScript/stdout": "everything"}},
+		Bucket: "out",
+	}
+
+	got, err := full.Complete(context.Background(), "cmd-1", "i-0aaaaaaaa", "stdout", strings.Repeat("x", inlineOutputLimit))
+
+	require.NoError(t, err)
+	assert.Equal(t, "everything", got)
+}
+
+func TestFullOutput_Complete_Unavailable_ReturnsError(t *testing.T) {
+	truncated := strings.Repeat("x", inlineOutputLimit)
+	var unconfigured *FullOutput
+	missing := &FullOutput{S3: &fakeS3{}, Bucket: "out"}
+
+	_, nilErr := unconfigured.Complete(context.Background(), "cmd-1", "i-0aaaaaaaa", "stdout", truncated)
+	_, s3Err := missing.Complete(context.Background(), "cmd-1", "i-0aaaaaaaa", "stdout", truncated)
+
+	assert.Error(t, nilErr)
+	assert.ErrorContains(t, s3Err, "s3://out/cmd-1/i-0aaaaaaaa")
+}
+
+func TestFullOutput_Complete_OtherDocument_ReturnsInlineWithError(t *testing.T) {
+	logs := &fakeLogs{pages: [][]string{{"line 1"}}}
+	full := &FullOutput{Logs: logs, Document: "AWS-RunPatchBaseline"}
+	truncated := strings.Repeat("x", inlineOutputLimit)
+
+	got, err := full.Complete(context.Background(), "cmd-1", "i-0aaaaaaaa", "stdout", truncated)
+
+	assert.ErrorContains(t, err, "/aws/ssm/AWS-RunPatchBaseline")
+	assert.Equal(t, truncated, got)
+	assert.Empty(t, logs.streams)
+}
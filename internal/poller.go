@@ -0,0 +1,384 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/fatih/color"
+)
+
+const (
+	// bounds of the interval between polls of the invocation statuses; it grows while
+	// nothing finishes or the API throttles, and starts over once an invocation finishes
+	minPollInterval = time.Second
+	maxPollInterval = 10 * time.Second
+
+	// outputFetchConcurrency is how many finished invocations are fetched and printed at once.
+	outputFetchConcurrency = 8
+	// outputFetchRetries is how often fetching a finished invocation is retried when it is
+	// throttled or not found yet.
+	outputFetchRetries = 5
+)
+
+type (
+	// clock waits for the invocation poller, so tests can run it without waiting.
+	clock interface {
+		After(d time.Duration) <-chan time.Time
+	}
+
+	realClock struct{}
+
+	// backoff is an exponential backoff with jitter between min and max.
+	backoff struct {
+		min, max time.Duration
+		cur      time.Duration
+	}
+
+	// watchedInvocation is one invocation watched by invocationPoller.
+	watchedInvocation struct {
+		input  *ssm.GetCommandInvocationInput
+		result *InvocationResult
+		prefix string
+		follow *invocationTail
+	}
+
+	// invocationPoller watches invocations of any number of commands with one
+	// ListCommandInvocations per command and poll, and fetches each invocation's output
+	// once it finished.
+	invocationPoller struct {
+		client SSMCommandAPI
+		opts   WatchOptions
+		clock  clock
+
+		// pending invocations by command ID and instance ID; an instance has one
+		// invocation per document step
+		pending map[string]map[string][]*watchedInvocation
+		missing map[string]int // polls an instance was not listed yet, by command/instance
+	}
+)
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// next returns the next wait, between half and all of the current interval, and doubles
+// the interval up to max.
+func (b *backoff) next() time.Duration {
+	if b.cur < b.min {
+		b.cur = b.min
+	}
+	d := b.cur
+	b.cur = min(b.cur*2, b.max)
+	return d/2 + rand.N(d/2+1)
+}
+
+// reset starts the backoff over at min.
+func (b *backoff) reset() {
+	b.cur = b.min
+}
+
+// sleep waits for d on clk and reports whether ctx is still active.
+func sleep(ctx context.Context, clk clock, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-clk.After(d):
+		return ctx.Err() == nil
+	}
+}
+
+// isThrottled reports whether err is an API throttling error.
+func isThrottled(err error) bool {
+	return retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary
+}
+
+// finished reports whether an invocation status is final.
+func finished(status ssm_types.CommandInvocationStatus) bool {
+	switch status {
+	case ssm_types.CommandInvocationStatusPending, ssm_types.CommandInvocationStatusInProgress,
+		ssm_types.CommandInvocationStatusDelayed, ssm_types.CommandInvocationStatusCancelling:
+		return false
+	}
+	return true
+}
+
+// newInvocationPoller returns a poller for inputs whose results are written to results.
+func newInvocationPoller(client SSMCommandAPI, inputs []*ssm.GetCommandInvocationInput, results []InvocationResult, opts WatchOptions) *invocationPoller {
+	p := &invocationPoller{
+		client:  client,
+		opts:    opts,
+		clock:   opts.clock,
+		pending: make(map[string]map[string][]*watchedInvocation),
+		missing: make(map[string]int),
+	}
+	if p.clock == nil {
+		p.clock = realClock{}
+	}
+	for i, input := range inputs {
+		result := &results[i]
+		result.InstanceID = aws.ToString(input.InstanceId)
+		result.CommandID = aws.ToString(input.CommandId)
+		result.ResponseCode = -1
+		result.Name = opts.NameMap[result.InstanceID]
+		result.Step = aws.ToString(input.PluginName)
+
+		tagName := "-"
+		if result.Name != "" {
+			tagName = result.Name
+		}
+		prefix := fmt.Sprintf("[%s][%s]", color.YellowString(result.InstanceID), color.CyanString(tagName))
+		if result.Step != "" {
+			prefix += fmt.Sprintf("[%s]", result.Step)
+		}
+		w := &watchedInvocation{input: input, result: result, prefix: prefix}
+		if opts.Follow != nil {
+			w.follow = newInvocationTail(opts.Follow, result.CommandID, result.InstanceID, prefix)
+		}
+
+		if p.pending[result.CommandID] == nil {
+			p.pending[result.CommandID] = make(map[string][]*watchedInvocation)
+		}
+		p.pending[result.CommandID][result.InstanceID] = append(p.pending[result.CommandID][result.InstanceID], w)
+	}
+	return p
+}
+
+// run polls until every invocation finished or ctx ends. Finished invocations are fetched
+// and printed concurrently with polling.
+func (p *invocationPoller) run(ctx context.Context) {
+	wg := new(sync.WaitGroup)
+	slots := make(chan struct{}, outputFetchConcurrency)
+	interval := &backoff{min: minPollInterval, max: maxPollInterval}
+
+	for len(p.pending) > 0 {
+		if !sleep(ctx, p.clock, interval.next()) {
+			p.stopPending(ctx)
+			break
+		}
+		p.printFollow(ctx)
+
+		for _, commandID := range SortedMapKeys(p.pending) {
+			done, err := p.poll(ctx, commandID)
+			if err != nil {
+				// throttling and ctx ending are retried by the next poll, or stop it
+				if ctx.Err() == nil && !isThrottled(err) {
+					p.fail(commandID, err)
+				}
+				DebugLog("cannot list invocations of %s: %v", commandID, err)
+				continue
+			}
+			if len(done) > 0 {
+				interval.reset()
+			}
+			for _, w := range done {
+				wg.Add(1)
+				slots <- struct{}{}
+				go func(w *watchedInvocation) {
+					defer func() { <-slots; wg.Done() }()
+					p.finish(ctx, w)
+				}(w)
+			}
+		}
+	}
+	wg.Wait()
+}
+
+// poll lists the invocations of a command and returns those of its pending instances that
+// finished, which are no longer pending.
+func (p *invocationPoller) poll(ctx context.Context, commandID string) ([]*watchedInvocation, error) {
+	statuses := make(map[string]ssm_types.CommandInvocationStatus)
+	input := &ssm.ListCommandInvocationsInput{CommandId: aws.String(commandID)}
+	for {
+		output, err := p.client.ListCommandInvocations(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, inv := range output.CommandInvocations {
+			statuses[aws.ToString(inv.InstanceId)] = inv.Status
+		}
+		if output.NextToken == nil {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+
+	var done []*watchedInvocation
+	instances := p.pending[commandID]
+	for _, instanceID := range SortedMapKeys(instances) {
+		status, listed := statuses[instanceID]
+		if !listed {
+			// invocations show up shortly after SendCommand, especially for commands sent by Targets
+			key := commandID + "/" + instanceID
+			if p.missing[key]++; p.missing[key] > invocationLookupRetries {
+				for _, w := range instances[instanceID] {
+					p.failInvocation(w, fmt.Errorf("no invocation of command %s found on %s", commandID, instanceID))
+				}
+				p.remove(commandID, instanceID)
+			}
+			continue
+		}
+		if finished(status) {
+			done = append(done, instances[instanceID]...)
+			p.remove(commandID, instanceID)
+		}
+	}
+	return done, nil
+}
+
+// finish fetches the output of a finished invocation and prints it. Invocations finish
+// concurrently, so the lines of one are printed at once to keep them together.
+func (p *invocationPoller) finish(ctx context.Context, w *watchedInvocation) {
+	result := w.result
+	header := "[%s]" + strings.ReplaceAll(w.prefix, "%", "%%")
+	var b strings.Builder
+	complete := func(stream, inline string) string {
+		text, err := p.opts.FullOutput.Complete(ctx, result.CommandID, result.InstanceID, stream, inline)
+		if err != nil {
+			fmt.Fprintln(&b, color.YellowString("[warn] %s %s is truncated to %d characters; cannot fetch the full output: %v", result.InstanceID, stream, utf8.RuneCountInString(inline), err))
+			return inline
+		}
+		return text
+	}
+
+	output, err := p.fetch(ctx, w.input)
+	if err != nil && ctx.Err() != nil {
+		printStopped(ctx, result.InstanceID)
+		result.Err = ctx.Err()
+		return
+	}
+	if err != nil {
+		p.failInvocation(w, err)
+		return
+	}
+	result.Status = output.Status
+	result.StatusDetails = aws.ToString(output.StatusDetails)
+	result.ResponseCode = output.ResponseCode
+	result.StartTime = aws.ToString(output.ExecutionStartDateTime)
+	result.EndTime = aws.ToString(output.ExecutionEndDateTime)
+	result.Elapsed = aws.ToString(output.ExecutionElapsedTime)
+	defer func() { fmt.Print(b.String()) }()
+
+	// output already streamed by --follow, or shown by the caller, is not printed here
+	streamed := w.follow.drain(ctx, p.clock) || p.opts.HideOutput
+	result.Stdout = complete("stdout", aws.ToString(output.StandardOutputContent))
+	result.Stderr = complete("stderr", aws.ToString(output.StandardErrorContent))
+
+	if output.Status == ssm_types.CommandInvocationStatusSuccess {
+		if streamed {
+			fmt.Fprintf(&b, header+"\n", color.GreenString("success"))
+			return
+		}
+		stdout := result.Stdout
+		if stdout == "" {
+			stdout = "(no output)"
+		}
+		fmt.Fprintf(&b, header+"\n%s\n", color.GreenString("success"), stdout)
+		return
+	}
+
+	if reason := skippedReason(output); reason != "" {
+		fmt.Fprintf(&b, header+"\n", color.YellowString(reason))
+		result.Skipped = reason
+		return
+	}
+
+	fmt.Fprintf(&b, header+" status: %s, exit code: %d\n", color.RedString("failed"), color.RedString(result.StatusDetails), output.ResponseCode)
+	if streamed {
+		return
+	}
+
+	// Show both stdout and stderr for failed commands
+	if result.Stdout != "" {
+		fmt.Fprintf(&b, "stdout: %s\n", result.Stdout)
+	}
+	if result.Stderr != "" {
+		fmt.Fprintf(&b, "stderr: %s\n", color.RedString(result.Stderr))
+	}
+	if result.Stdout == "" && result.Stderr == "" {
+		fmt.Fprintf(&b, "(no output)\n")
+	}
+}
+
+// fetch returns a finished invocation, retrying with backoff while it is throttled, not
+// found or not final yet.
+func (p *invocationPoller) fetch(ctx context.Context, input *ssm.GetCommandInvocationInput) (*ssm.GetCommandInvocationOutput, error) {
+	wait := &backoff{min: minPollInterval, max: maxPollInterval}
+	for attempt := 0; ; attempt++ {
+		output, err := p.client.GetCommandInvocation(ctx, input)
+		var notYet *ssm_types.InvocationDoesNotExist
+		retryable := isThrottled(err) || errors.As(err, &notYet) || (err == nil && !finished(output.Status))
+		if !retryable || attempt >= outputFetchRetries {
+			if err == nil && !finished(output.Status) {
+				return nil, fmt.Errorf("invocation is still %s after it finished", output.Status)
+			}
+			return output, err
+		}
+		if !sleep(ctx, p.clock, wait.next()) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// printFollow prints the output the pending invocations streamed since the last poll.
+func (p *invocationPoller) printFollow(ctx context.Context) {
+	if p.opts.Follow == nil {
+		return
+	}
+	for _, commandID := range SortedMapKeys(p.pending) {
+		instances := p.pending[commandID]
+		for _, instanceID := range SortedMapKeys(instances) {
+			for _, w := range instances[instanceID] {
+				w.follow.print(ctx)
+			}
+		}
+	}
+}
+
+// fail ends every pending invocation of a command with err.
+func (p *invocationPoller) fail(commandID string, err error) {
+	instances := p.pending[commandID]
+	for _, instanceID := range SortedMapKeys(instances) {
+		for _, w := range instances[instanceID] {
+			p.failInvocation(w, err)
+		}
+	}
+	delete(p.pending, commandID)
+}
+
+// failInvocation ends an invocation with err.
+func (p *invocationPoller) failInvocation(w *watchedInvocation, err error) {
+	color.Red("[err] %s %v", w.result.InstanceID, err)
+	w.result.Err = err
+}
+
+// stopPending ends every pending invocation because ctx ended.
+func (p *invocationPoller) stopPending(ctx context.Context) {
+	for _, commandID := range SortedMapKeys(p.pending) {
+		instances := p.pending[commandID]
+		for _, instanceID := range SortedMapKeys(instances) {
+			for _, w := range instances[instanceID] {
+				printStopped(ctx, w.result.InstanceID)
+				w.result.Err = ctx.Err()
+			}
+		}
+	}
+	clear(p.pending)
+}
+
+// remove stops watching an instance of a command.
+func (p *invocationPoller) remove(commandID, instanceID string) {
+	delete(p.pending[commandID], instanceID)
+	if len(p.pending[commandID]) == 0 {
+		delete(p.pending, commandID)
+	}
+}
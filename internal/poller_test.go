@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock fires at once and records how long it was asked to wait.
+type fakeClock struct {
+	mu     sync.Mutex
+	waited []time.Duration
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waited = append(c.waited, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+func (c *fakeClock) waits() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.waited...)
+}
+
+// throttlingError is an API error with the code SSM returns when it throttles requests.
+type throttlingError struct{}
+
+func (throttlingError) Error() string     { return "ThrottlingException: Rate exceeded" }
+func (throttlingError) ErrorCode() string { return "ThrottlingException" }
+
+// fakeFleetCommands serves the invocations of several commands, which finish after a number
+// of polls, and counts the calls made.
+type fakeFleetCommands struct {
+	mu          sync.Mutex
+	instances   map[string][]string // instance IDs by command ID
+	finishAfter int                 // polls of a command before its invocations succeed
+	throttle    int                 // calls of each API throttled before answering
+	fail        bool                // invocations fail, with stdout and stderr
+	lists       map[string]int
+	gets        map[string]int
+}
+
+func (f *fakeFleetCommands) ListCommandInvocations(ctx context.Context, params *ssm.ListCommandInvocationsInput, optFns ...func(*ssm.Options)) (*ssm.ListCommandInvocationsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	commandID := aws.ToString(params.CommandId)
+	f.lists[commandID]++
+	if f.lists[commandID] <= f.throttle {
+		return nil, throttlingError{}
+	}
+	status := ssm_types.CommandInvocationStatusInProgress
+	if f.lists[commandID]-f.throttle > f.finishAfter {
+		status = ssm_types.CommandInvocationStatusSuccess
+	}
+
+	// pages of 50 invocations, the token being the offset
+	ids := f.instances[commandID]
+	start := 0
+	if params.NextToken != nil {
+		fmt.Sscan(aws.ToString(params.NextToken), &start)
+	}
+	end := min(start+50, len(ids))
+	output := &ssm.ListCommandInvocationsOutput{}
+	for _, id := range ids[start:end] {
+		output.CommandInvocations = append(output.CommandInvocations, ssm_types.CommandInvocation{InstanceId: aws.String(id), Status: status})
+	}
+	if end < len(ids) {
+		output.NextToken = aws.String(fmt.Sprint(end))
+	}
+	return output, nil
+}
+
+func (f *fakeFleetCommands) GetCommandInvocation(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := aws.ToString(params.CommandId) + "/" + aws.ToString(params.InstanceId)
+	f.gets[key]++
+	if f.gets[key] <= f.throttle {
+		return nil, throttlingError{}
+	}
+	if f.fail {
+		return &ssm.GetCommandInvocationOutput{Status: ssm_types.CommandInvocationStatusFailed, StatusDetails: aws.String("Failed"), ResponseCode: 1, StandardOutputContent: params.InstanceId, StandardErrorContent: params.InstanceId}, nil
+	}
+	return &ssm.GetCommandInvocationOutput{Status: ssm_types.CommandInvocationStatusSuccess, StandardOutputContent: params.InstanceId}, nil
+}
+
+func (f *fakeFleetCommands) SendCommand(ctx context.Context, params *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error) {
+	return nil, assert.AnError
+}
+
+func (f *fakeFleetCommands) CancelCommand(ctx context.Context, params *ssm.CancelCommandInput, optFns ...func(*ssm.Options)) (*ssm.CancelCommandOutput, error) {
+	return nil, assert.AnError
+}
+
+// newFakeFleetCommands returns commands cmd-0, cmd-1, ... over n instances in batches of 50,
+// and the inputs to watch them.
+func newFakeFleetCommands(n int) (*fakeFleetCommands, []*ssm.GetCommandInvocationInput) {
+	f := &fakeFleetCommands{instances: make(map[string][]string), lists: make(map[string]int), gets: make(map[string]int)}
+	var inputs []*ssm.GetCommandInvocationInput
+	for i := 0; i < n; i++ {
+		commandID := fmt.Sprintf("cmd-%d", i/50)
+		instanceID := fmt.Sprintf("i-%04d", i)
+		f.instances[commandID] = append(f.instances[commandID], instanceID)
+		inputs = append(inputs, &ssm.GetCommandInvocationInput{CommandId: aws.String(commandID), InstanceId: aws.String(instanceID)})
+	}
+	return f, inputs
+}
+
+func TestPrintCommandInvocation_PollsEachCommandOnce(t *testing.T) {
+	client, inputs := newFakeFleetCommands(120)
+	client.finishAfter = 3
+	clk := &fakeClock{}
+
+	var results []InvocationResult
+	captureStdout(t, func() {
+		results = PrintCommandInvocation(context.Background(), client, inputs, WatchOptions{clock: clk})
+	})
+
+	require.Len(t, results, 120)
+	for i, r := range results {
+		assert.True(t, r.Succeeded(), r.InstanceID)
+		assert.Equal(t, aws.ToString(inputs[i].InstanceId), r.Stdout)
+	}
+	// 4 polls of one ListCommandInvocations per command, and one GetCommandInvocation per instance
+	assert.Equal(t, map[string]int{"cmd-0": 4, "cmd-1": 4, "cmd-2": 4}, client.lists)
+	assert.Len(t, client.gets, 120)
+	for key, n := range client.gets {
+		assert.Equal(t, 1, n, key)
+	}
+}
+
+func TestPrintCommandInvocation_Concurrent_PrintsEachInvocationTogether(t *testing.T) {
+	client, inputs := newFakeFleetCommands(200)
+	client.fail = true
+
+	out := captureStdout(t, func() {
+		PrintCommandInvocation(context.Background(), client, inputs, WatchOptions{clock: &fakeClock{}})
+	})
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 600)
+	for i := 0; i < len(lines); i += 3 {
+		// the header names the instance whose stdout and stderr follow it
+		instanceID := strings.TrimPrefix(lines[i+1], "stdout: ")
+		assert.Contains(t, lines[i], instanceID)
+		assert.Equal(t, "stderr: "+instanceID, lines[i+2])
+	}
+}
+
+func TestPrintCommandInvocation_Throttled_BacksOffAndRetries(t *testing.T) {
+	client, inputs := newFakeFleetCommands(3)
+	client.throttle = 3
+	clk := &fakeClock{}
+
+	var results []InvocationResult
+	out := captureStdout(t, func() {
+		results = PrintCommandInvocation(context.Background(), client, inputs, WatchOptions{clock: clk})
+	})
+
+	for _, r := range results {
+		assert.True(t, r.Succeeded(), r.InstanceID)
+	}
+	assert.NotContains(t, out, "[err]")
+	assert.Equal(t, 4, client.lists["cmd-0"])
+	for key, n := range client.gets {
+		assert.Equal(t, 4, n, key)
+	}
+
+	// the polls wait longer while throttled: at least half of 1s, 2s, 4s and 8s
+	waits := clk.waits()
+	require.GreaterOrEqual(t, len(waits), 4)
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		assert.GreaterOrEqual(t, waits[i], want/2, "wait %d", i)
+		assert.LessOrEqual(t, waits[i], want, "wait %d", i)
+	}
+}
+
+func TestPrintCommandInvocation_NeverListed_GivesUp(t *testing.T) {
+	mock := &mockSSMCommandAPI{
+		listCommandInvocationsFunc: listedAs(ssm_types.CommandInvocationStatusSuccess),
+	}
+	clk := &fakeClock{}
+
+	var results []InvocationResult
+	captureStdout(t, func() {
+		results = PrintCommandInvocation(context.Background(), mock, []*ssm.GetCommandInvocationInput{
+			{CommandId: aws.String("cmd-1"), InstanceId: aws.String("i-0aaaaaaaa")},
+		}, WatchOptions{clock: clk})
+	})
+
+	require.Len(t, results, 1)
+	assert.ErrorContains(t, results[0].Err, "no invocation of command cmd-1 found on i-0aaaaaaaa")
+	assert.Len(t, clk.waits(), invocationLookupRetries+1)
+}
+
+func TestPrintCommandInvocation_ListFails_FailsThatCommandOnly(t *testing.T) {
+	mock := &mockSSMCommandAPI{
+		listCommandInvocationsFunc: func(ctx context.Context, params *ssm.ListCommandInvocationsInput, optFns ...func(*ssm.Options)) (*ssm.ListCommandInvocationsOutput, error) {
+			if aws.ToString(params.CommandId) == "cmd-denied" {
+				return nil, assert.AnError
+			}
+			return listedAs(ssm_types.CommandInvocationStatusSuccess, "i-0bbbbbbbb")(ctx, params, optFns...)
+		},
+		getCommandInvocationFunc: func(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+			return &ssm.GetCommandInvocationOutput{Status: ssm_types.CommandInvocationStatusSuccess}, nil
+		},
+	}
+
+	var results []InvocationResult
+	captureStdout(t, func() {
+		results = PrintCommandInvocation(context.Background(), mock, []*ssm.GetCommandInvocationInput{
+			{CommandId: aws.String("cmd-denied"), InstanceId: aws.String("i-0aaaaaaaa")},
+			{CommandId: aws.String("cmd-ok"), InstanceId: aws.String("i-0bbbbbbbb")},
+		}, WatchOptions{clock: &fakeClock{}})
+	})
+
+	assert.ErrorIs(t, results[0].Err, assert.AnError)
+	assert.True(t, results[1].Succeeded())
+}
+
+func TestPrintCommandInvocation_Steps_FetchedOnceInstanceFinished(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		fetched []string
+	)
+	mock := &mockSSMCommandAPI{
+		listCommandInvocationsFunc: listedAs(ssm_types.CommandInvocationStatusFailed, "i-0aaaaaaaa"),
+		getCommandInvocationFunc: func(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			fetched = append(fetched, aws.ToString(params.PluginName))
+			if aws.ToString(params.PluginName) == "configure" {
+				return &ssm.GetCommandInvocationOutput{Status: ssm_types.CommandInvocationStatusFailed, StatusDetails: aws.String("Failed"), ResponseCode: 2}, nil
+			}
+			return &ssm.GetCommandInvocationOutput{Status: ssm_types.CommandInvocationStatusSuccess}, nil
+		},
+	}
+	inputs := StepInvocationInputs([]*ssm.GetCommandInvocationInput{
+		{CommandId: aws.String("cmd-1"), InstanceId: aws.String("i-0aaaaaaaa")},
+	}, []string{"install", "configure"})
+
+	var results []InvocationResult
+	captureStdout(t, func() {
+		results = PrintCommandInvocation(context.Background(), mock, inputs, WatchOptions{clock: &fakeClock{}})
+	})
+
+	assert.ElementsMatch(t, []string{"install", "configure"}, fetched)
+	require.Len(t, results, 2)
+	assert.True(t, results[0].Succeeded())
+	assert.Equal(t, int32(2), results[1].ResponseCode)
+}
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{min: time.Second, max: 4 * time.Second}
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		d := b.next()
+		assert.GreaterOrEqual(t, d, want/2)
+		assert.LessOrEqual(t, d, want)
+	}
+
+	b.reset()
+	assert.LessOrEqual(t, b.next(), time.Second)
+}
+
+func TestIsThrottled(t *testing.T) {
+	assert.True(t, isThrottled(throttlingError{}))
+	assert.True(t, isThrottled(fmt.Errorf("list: %w", throttlingError{})))
+	assert.False(t, isThrottled(&ssm_types.InvocationDoesNotExist{}))
+	assert.False(t, isThrottled(nil))
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const resultsSummaryFile = "summary.json"
+
+type (
+	// resultSummary is the summary.json entry of one invocation.
+	resultSummary struct {
+		InstanceID    string `json:"instanceId"`
+		Name          string `json:"name,omitempty"`
+		CommandID     string `json:"commandId"`
+		Step          string `json:"step,omitempty"`
+		Status        string `json:"status"`
+		StatusDetails string `json:"statusDetails,omitempty"`
+		ResponseCode  int32  `json:"responseCode"`
+		StartTime     string `json:"startTime,omitempty"`
+		EndTime       string `json:"endTime,omitempty"`
+		Elapsed       string `json:"elapsed,omitempty"`
+		StdoutFile    string `json:"stdoutFile,omitempty"`
+		StderrFile    string `json:"stderrFile,omitempty"`
+		Error         string `json:"error,omitempty"`
+	}
+)
+
+// WriteResults writes the stdout and stderr of every invocation with a final status to
+// <instance>-<name>.stdout and .stderr in dir, and a summary.json of all invocations.
+func WriteResults(dir string, results []InvocationResult) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	summaries := make([]resultSummary, 0, len(results))
+	for _, r := range results {
+		summary := resultSummary{
+			InstanceID:    r.InstanceID,
+			Name:          r.Name,
+			CommandID:     r.CommandID,
+			Step:          r.Step,
+			Status:        string(r.Status),
+			StatusDetails: r.StatusDetails,
+			ResponseCode:  r.ResponseCode,
+			StartTime:     r.StartTime,
+			EndTime:       r.EndTime,
+			Elapsed:       r.Elapsed,
+		}
+		if r.Err != nil {
+			summary.Error = r.Err.Error()
+		}
+		if r.Status != "" {
+			base := resultFileBase(r)
+			summary.StdoutFile, summary.StderrFile = base+".stdout", base+".stderr"
+			if err := os.WriteFile(filepath.Join(dir, summary.StdoutFile), []byte(r.Stdout), 0644); err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(dir, summary.StderrFile), []byte(r.Stderr), 0644); err != nil {
+				return err
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+
+	data, err := json.MarshalIndent(summaries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, resultsSummaryFile), append(data, '\n'), 0644)
+}
+
+// resultFileBase returns the file name, without extension, of an invocation's output:
+// the instance ID followed by its name and step when set, safe to use as a file name.
+func resultFileBase(r InvocationResult) string {
+	parts := []string{r.InstanceID}
+	for _, p := range []string{r.Name, r.Step} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	safe := func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-' {
+			return c
+		}
+		return '_'
+	}
+	return strings.Map(safe, strings.Join(parts, "-"))
+}
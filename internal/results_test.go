@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteResults_WritesOutputAndSummary(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "results")
+	results := []InvocationResult{
+		{
+			InstanceID: "i-1", Name: "web-1", CommandID: "cmd-1",
+			Status: ssm_types.CommandInvocationStatusSuccess, StatusDetails: "Success",
+			Stdout: "ok\n", StartTime: "2024-01-01T00:00:00Z", EndTime: "2024-01-01T00:00:02Z", Elapsed: "PT2S",
+		},
+		{
+			InstanceID: "i-2", CommandID: "cmd-1",
+			Status: ssm_types.CommandInvocationStatusFailed, ResponseCode: 3, Stderr: "boom\n",
+		},
+		{InstanceID: "i-3", CommandID: "cmd-1", ResponseCode: -1, Err: errors.New("throttled")},
+	}
+
+	require.NoError(t, WriteResults(dir, results))
+
+	stdout, err := os.ReadFile(filepath.Join(dir, "i-1-web-1.stdout"))
+	require.NoError(t, err)
+	assert.Equal(t, "ok\n", string(stdout))
+	stderr, err := os.ReadFile(filepath.Join(dir, "i-2.stderr"))
+	require.NoError(t, err)
+	assert.Equal(t, "boom\n", string(stderr))
+	assert.NoFileExists(t, filepath.Join(dir, "i-3.stdout"))
+
+	data, err := os.ReadFile(filepath.Join(dir, "summary.json"))
+	require.NoError(t, err)
+	var summaries []resultSummary
+	require.NoError(t, json.Unmarshal(data, &summaries))
+	require.Len(t, summaries, 3)
+	assert.Equal(t, resultSummary{
+		InstanceID: "i-1", Name: "web-1", CommandID: "cmd-1", Status: "Success", StatusDetails: "Success",
+		StartTime: "2024-01-01T00:00:00Z", EndTime: "2024-01-01T00:00:02Z", Elapsed: "PT2S",
+		StdoutFile: "i-1-web-1.stdout", StderrFile: "i-1-web-1.stderr",
+	}, summaries[0])
+	assert.Equal(t, int32(3), summaries[1].ResponseCode)
+	assert.Equal(t, "throttled", summaries[2].Error)
+	assert.Empty(t, summaries[2].StdoutFile)
+}
+
+func TestResultFileBase(t *testing.T) {
+	tests := []struct {
+		name   string
+		result InvocationResult
+		want   string
+	}{
+		{"instance only", InvocationResult{InstanceID: "i-1"}, "i-1"},
+		{"with name", InvocationResult{InstanceID: "i-1", Name: "web-1"}, "i-1-web-1"},
+		{"with step", InvocationResult{InstanceID: "i-1", Name: "web", Step: "install"}, "i-1-web-install"},
+		{"unsafe characters", InvocationResult{InstanceID: "i-1", Name: "web/prod api:1"}, "i-1-web_prod_api_1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, resultFileBase(tt.result))
+		})
+	}
+}
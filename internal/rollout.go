@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+type (
+	// RolloutOptions controls how Rollout steps through its batches.
+	RolloutOptions struct {
+		// BatchSize is how many targets run the command at a time.
+		BatchSize int
+		// Pause is how long to wait after a healthy batch before the next one.
+		Pause time.Duration
+
+		clock clock // waits for Pause; nil uses the real clock
+	}
+
+	// RolloutStep runs the command, or its health check, on one batch of targets. An error
+	// aborts the rollout.
+	RolloutStep func(ctx context.Context, batch []*Target) error
+)
+
+// Rollout runs a command on successive batches of targets. After each batch the health
+// step, if any, checks the batch. The rollout stops at the first batch whose command or
+// health check fails, or once ctx ends, and shows which targets are done, failed and
+// still pending.
+func Rollout(ctx context.Context, targets []*Target, opts RolloutOptions, run, health RolloutStep) error {
+	if opts.BatchSize < 1 {
+		return fmt.Errorf("invalid batch size %d (must be at least 1)", opts.BatchSize)
+	}
+	clk := opts.clock
+	if clk == nil {
+		clk = realClock{}
+	}
+
+	var batches [][]*Target
+	for start := 0; start < len(targets); start += opts.BatchSize {
+		batches = append(batches, targets[start:min(start+opts.BatchSize, len(targets))])
+	}
+
+	for i, batch := range batches {
+		if ctx.Err() != nil {
+			printRolloutAborted(batches, i, false, "it was stopped")
+			return fmt.Errorf("rollout aborted before batch %d/%d: %w", i+1, len(batches), ctx.Err())
+		}
+		fmt.Printf("%s batch %d/%d: %s\n", color.GreenString("[rollout]"), i+1, len(batches), listTargets(batch))
+		if err := run(ctx, batch); err != nil {
+			printRolloutAborted(batches, i, true, stepFailure(ctx, "the command failed"))
+			return fmt.Errorf("rollout aborted at batch %d/%d: %w", i+1, len(batches), err)
+		}
+		if health != nil {
+			fmt.Printf("%s batch %d/%d\n", color.GreenString("[health]"), i+1, len(batches))
+			if err := health(ctx, batch); err != nil {
+				printRolloutAborted(batches, i, true, stepFailure(ctx, "the health check failed"))
+				return fmt.Errorf("rollout aborted at batch %d/%d: health check: %w", i+1, len(batches), err)
+			}
+		}
+		if i < len(batches)-1 && opts.Pause > 0 {
+			fmt.Printf("%s waiting %s before the next batch\n", color.GreenString("[rollout]"), opts.Pause)
+			if !sleep(ctx, clk, opts.Pause) {
+				printRolloutAborted(batches, i+1, false, "it was stopped")
+				return fmt.Errorf("rollout aborted before batch %d/%d: %w", i+2, len(batches), ctx.Err())
+			}
+		}
+	}
+	fmt.Printf("%s done: %d instance(s) in %d batch(es)\n", color.GreenString("[rollout]"), len(targets), len(batches))
+	return nil
+}
+
+// stepFailure returns why a batch failed: reason, or that the rollout was stopped while
+// the batch ran.
+func stepFailure(ctx context.Context, reason string) string {
+	if ctx.Err() != nil {
+		return "it was stopped"
+	}
+	return reason
+}
+
+// printRolloutAborted shows the targets of the batches before next as done and the rest as
+// pending. With failed, the batch at next failed and its targets are shown apart; without,
+// the rollout stopped before it.
+func printRolloutAborted(batches [][]*Target, next int, failed bool, reason string) {
+	var done, pending []*Target
+	for _, batch := range batches[:next] {
+		done = append(done, batch...)
+	}
+	rest := batches[next:]
+	if failed {
+		rest = rest[1:]
+	}
+	for _, batch := range rest {
+		pending = append(pending, batch...)
+	}
+	fmt.Printf("%s aborted because %s\n", color.RedString("[rollout]"), reason)
+	fmt.Printf("  done (%d): %s\n", len(done), listTargets(done))
+	if failed {
+		fmt.Printf("  failed batch (%d): %s\n", len(batches[next]), listTargets(batches[next]))
+	}
+	fmt.Printf("  pending (%d): %s\n", len(pending), listTargets(pending))
+}
+
+// listTargets lists the instance IDs of targets, or "-" when there are none.
+func listTargets(targets []*Target) string {
+	if len(targets) == 0 {
+		return "-"
+	}
+	ids := make([]string, 0, len(targets))
+	for _, t := range targets {
+		ids = append(ids, t.Name)
+	}
+	return strings.Join(ids, ", ")
+}
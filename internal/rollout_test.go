@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rolloutTargets(ids ...string) []*Target {
+	targets := make([]*Target, 0, len(ids))
+	for _, id := range ids {
+		targets = append(targets, &Target{Name: id})
+	}
+	return targets
+}
+
+// recordBatches returns a step that records the batches it ran on and fails on the
+// batch that contains failOn.
+func recordBatches(ran *[][]string, failOn string) RolloutStep {
+	return func(ctx context.Context, batch []*Target) error {
+		ids := targetIDs(batch)
+		*ran = append(*ran, ids)
+		for _, id := range ids {
+			if id == failOn {
+				return errors.New("failed on " + id)
+			}
+		}
+		return nil
+	}
+}
+
+func TestRollout_RunsBatchesWithHealthChecksAndPauses(t *testing.T) {
+	var ran, checked [][]string
+	clk := &fakeClock{}
+	opts := RolloutOptions{BatchSize: 2, Pause: 30 * time.Second, clock: clk}
+
+	var err error
+	out := captureStdout(t, func() {
+		err = Rollout(context.Background(), rolloutTargets("i-1", "i-2", "i-3", "i-4", "i-5"), opts, recordBatches(&ran, ""), recordBatches(&checked, ""))
+	})
+
+	require.NoError(t, err)
+	want := [][]string{{"i-1", "i-2"}, {"i-3", "i-4"}, {"i-5"}}
+	assert.Equal(t, want, ran)
+	assert.Equal(t, want, checked)
+	assert.Equal(t, []time.Duration{30 * time.Second, 30 * time.Second}, clk.waits())
+	assert.Contains(t, out, "batch 3/3: i-5")
+	assert.Contains(t, out, "done: 5 instance(s) in 3 batch(es)")
+}
+
+func TestRollout_FailedBatch_StopsAndListsPending(t *testing.T) {
+	var ran [][]string
+	targets := rolloutTargets("i-1", "i-2", "i-3", "i-4", "i-5")
+
+	var err error
+	out := captureStdout(t, func() {
+		err = Rollout(context.Background(), targets, RolloutOptions{BatchSize: 2, clock: &fakeClock{}}, recordBatches(&ran, "i-3"), nil)
+	})
+
+	require.ErrorContains(t, err, "rollout aborted at batch 2/3: failed on i-3")
+	assert.Equal(t, [][]string{{"i-1", "i-2"}, {"i-3", "i-4"}}, ran)
+	assert.Contains(t, out, "done (2): i-1, i-2")
+	assert.Contains(t, out, "failed batch (2): i-3, i-4")
+	assert.Contains(t, out, "pending (1): i-5")
+}
+
+func TestRollout_FailedHealthCheck_StopsBeforeNextBatch(t *testing.T) {
+	var ran, checked [][]string
+	cause := &exitStatus{}
+
+	var err error
+	captureStdout(t, func() {
+		err = Rollout(context.Background(), rolloutTargets("i-1", "i-2"), RolloutOptions{BatchSize: 1}, recordBatches(&ran, ""), func(ctx context.Context, batch []*Target) error {
+			checked = append(checked, []string{batch[0].Name})
+			return cause
+		})
+	})
+
+	require.ErrorContains(t, err, "rollout aborted at batch 1/2: health check")
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, [][]string{{"i-1"}}, ran)
+	assert.Equal(t, [][]string{{"i-1"}}, checked)
+}
+
+func TestRollout_InvalidBatchSize_ReturnsError(t *testing.T) {
+	err := Rollout(context.Background(), rolloutTargets("i-1"), RolloutOptions{}, recordBatches(new([][]string), ""), nil)
+	assert.ErrorContains(t, err, "invalid batch size")
+}
+
+// exitStatus is an error the caller of Rollout must still find in the returned error.
+type exitStatus struct{}
+
+func (*exitStatus) Error() string { return "exit status 1" }
+
+func TestRollout_StoppedDuringPause_ListsRanBatchesAsDone(t *testing.T) {
+	var ran [][]string
+	ctx, cancel := context.WithCancel(context.Background())
+	run := func(ctx context.Context, batch []*Target) error {
+		err := recordBatches(&ran, "")(ctx, batch)
+		cancel() // stop during the pause after the first batch
+		return err
+	}
+
+	var err error
+	out := captureStdout(t, func() {
+		err = Rollout(ctx, rolloutTargets("i-1", "i-2", "i-3", "i-4", "i-5"), RolloutOptions{BatchSize: 2, Pause: time.Hour}, run, nil)
+	})
+
+	require.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "rollout aborted before batch 2/3")
+	assert.Equal(t, [][]string{{"i-1", "i-2"}}, ran)
+	assert.Contains(t, out, "done (2): i-1, i-2")
+	assert.NotContains(t, out, "failed batch")
+	assert.Contains(t, out, "pending (3): i-3, i-4, i-5")
+}
+
+// blockingClock never fires, and sends the waits it is asked for to waiting.
+type blockingClock struct {
+	waiting chan time.Duration
+}
+
+func (c blockingClock) After(d time.Duration) <-chan time.Time {
+	c.waiting <- d
+	return nil
+}
+
+func TestRollout_CancelledWhileSleeping_ListsPendingBatches(t *testing.T) {
+	var ran [][]string
+	ctx, cancel := context.WithCancel(context.Background())
+	clk := blockingClock{waiting: make(chan time.Duration)}
+	go func() {
+		<-clk.waiting
+		cancel()
+	}()
+
+	var err error
+	out := captureStdout(t, func() {
+		err = Rollout(ctx, rolloutTargets("i-1", "i-2", "i-3"), RolloutOptions{BatchSize: 1, Pause: time.Minute, clock: clk}, recordBatches(&ran, ""), nil)
+	})
+
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, [][]string{{"i-1"}}, ran)
+	assert.Contains(t, out, "waiting 1m0s before the next batch")
+	assert.Contains(t, out, "done (1): i-1")
+	assert.Contains(t, out, "pending (2): i-2, i-3")
+}
+
+func TestRollout_StoppedWithoutPause_DoesNotStartNextBatch(t *testing.T) {
+	var ran [][]string
+	ctx, cancel := context.WithCancel(context.Background())
+	health := func(ctx context.Context, batch []*Target) error {
+		cancel() // stopped once the first batch is healthy
+		return nil
+	}
+
+	var err error
+	out := captureStdout(t, func() {
+		err = Rollout(ctx, rolloutTargets("i-1", "i-2"), RolloutOptions{BatchSize: 1}, recordBatches(&ran, ""), health)
+	})
+
+	require.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "rollout aborted before batch 2/2")
+	assert.Equal(t, [][]string{{"i-1"}}, ran)
+	assert.Contains(t, out, "pending (1): i-2")
+}
@@ -0,0 +1,237 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const (
+	// maxCommandParameterSize is the largest commands parameter gossm sends; SSM rejects
+	// SendCommand requests whose parameters exceed 64 KB.
+	maxCommandParameterSize = 64 * 1024
+
+	// defaultScriptInterpreter runs scripts without a shebang line.
+	defaultScriptInterpreter = "sh"
+)
+
+// envName matches the names a shell accepts for environment variables.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// CommandEnv is the user, working directory and environment variables a command runs with
+// instead of those of the SSM agent, which runs commands as root or SYSTEM.
+type CommandEnv struct {
+	User    string
+	WorkDir string
+	Env     map[string]string
+}
+
+// ScriptCommand returns a shell command that writes script to a temporary file on the
+// instance, runs it with args and removes it. The script runs with the interpreter of its
+// shebang line, or sh without one, so it also works where /tmp is mounted noexec.
+func ScriptCommand(name string, script []byte, args []string) (string, error) {
+	if len(script) == 0 {
+		return "", fmt.Errorf("script %s is empty", name)
+	}
+
+	words := append([]string{`"$f"`}, quoteArgs(args)...)
+	command := strings.Join([]string{
+		`f=$(mktemp)`,
+		`trap 'rm -f "$f"' EXIT`,
+		`echo ` + base64.StdEncoding.EncodeToString(script) + ` | base64 -d > "$f"`,
+		scriptInterpreter(script) + " " + strings.Join(words, " "),
+	}, " && ")
+
+	if len(command) > maxCommandParameterSize {
+		return "", fmt.Errorf("script %s is too large to send: %d bytes once encoded, the SSM limit is %d bytes (about %d bytes of script); copy it to the instances another way, for example from S3",
+			name, len(command), maxCommandParameterSize, maxCommandParameterSize*3/4)
+	}
+	return command, nil
+}
+
+// scriptInterpreter returns the interpreter named by the script's shebang line.
+func scriptInterpreter(script []byte) string {
+	if !bytes.HasPrefix(script, []byte("#!")) {
+		return defaultScriptInterpreter
+	}
+	line, _, _ := bytes.Cut(script[2:], []byte("\n"))
+	if interpreter := strings.TrimSpace(string(line)); interpreter != "" {
+		return interpreter
+	}
+	return defaultScriptInterpreter
+}
+
+// shellQuote quotes s as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,@+%", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quoteArgs quotes each argument with shellQuote.
+func quoteArgs(args []string) []string {
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		quoted = append(quoted, shellQuote(a))
+	}
+	return quoted
+}
+
+// ParseEnv parses NAME=value assignments into environment variables.
+func ParseEnv(assignments []string) (map[string]string, error) {
+	env := make(map[string]string, len(assignments))
+	for _, a := range assignments {
+		name, value, ok := strings.Cut(a, "=")
+		if !ok || !envName.MatchString(name) {
+			return nil, fmt.Errorf("invalid variable %q (want NAME=value)", a)
+		}
+		env[name] = value
+	}
+	return env, nil
+}
+
+// ScriptDocument returns the document that runs commands on targets: AWS-RunPowerShellScript
+// when they all run Windows, otherwise AWS-RunShellScript. Targets mixing both are an error,
+// as one command cannot run in both shells.
+func ScriptDocument(targets []*Target) (string, error) {
+	windows := 0
+	for _, t := range targets {
+		if t.Windows() {
+			windows++
+		}
+	}
+	switch windows {
+	case 0:
+		return ShellScriptDocument, nil
+	case len(targets):
+		return PowerShellScriptDocument, nil
+	default:
+		return "", fmt.Errorf("%d of %d targets run Windows; send commands to Windows and Linux instances separately", windows, len(targets))
+	}
+}
+
+// Windows reports whether the target runs Windows, from its EC2 platform or SSM agent.
+func (t *Target) Windows() bool {
+	return t.PlatformType == string(ssm_types.PlatformTypeWindows) ||
+		strings.Contains(t.PlatformDetails, "Windows") || strings.Contains(t.PlatformName, "Windows")
+}
+
+// DescribePlatforms looks up the SSM agent information of targets without a platform, such
+// as targets given by instance ID, so ScriptDocument can tell which of them run Windows.
+// Instances SSM does not know keep no platform.
+func DescribePlatforms(ctx context.Context, client SSMDescribeInstanceInfoAPI, targets []*Target) error {
+	missing := make(map[string]*Target)
+	var ids []string
+	for _, t := range targets {
+		if t.PlatformType == "" && t.PlatformName == "" && t.PlatformDetails == "" && missing[t.Name] == nil {
+			missing[t.Name] = t
+			ids = append(ids, t.Name)
+		}
+	}
+
+	for start := 0; start < len(ids); start += maxOutputResults {
+		input := &ssm.DescribeInstanceInformationInput{
+			Filters: []ssm_types.InstanceInformationStringFilter{
+				{Key: aws.String("InstanceIds"), Values: ids[start:min(start+maxOutputResults, len(ids))]},
+			},
+			MaxResults: aws.Int32(maxOutputResults),
+		}
+		for {
+			output, err := client.DescribeInstanceInformation(ctx, input)
+			if err != nil {
+				return fmt.Errorf("cannot look up the platform of the targets: %w", err)
+			}
+			for _, info := range output.InstanceInformationList {
+				if t := missing[aws.ToString(info.InstanceId)]; t != nil {
+					setAgentInfo(t, info)
+				}
+			}
+			if aws.ToString(output.NextToken) == "" {
+				break
+			}
+			input.NextToken = output.NextToken
+		}
+	}
+	return nil
+}
+
+// Parameters returns the parameters of document, AWS-RunShellScript or
+// AWS-RunPowerShellScript, that run command as the user, in the working directory and with
+// the environment of e.
+func (e CommandEnv) Parameters(document, command string) (map[string][]string, error) {
+	if e.User != "" && (strings.HasPrefix(e.User, "-") || strings.ContainsAny(e.User, " \t\n")) {
+		return nil, fmt.Errorf("invalid user %q", e.User)
+	}
+	switch document {
+	case ShellScriptDocument:
+		command = e.shellCommand(command)
+	case PowerShellScriptDocument:
+		if e.User != "" {
+			return nil, fmt.Errorf("cannot run commands as another user on Windows instances")
+		}
+		command = e.powerShellCommand(command)
+	default:
+		return nil, fmt.Errorf("cannot set the user or environment of %s commands", document)
+	}
+	params := ShellScriptParameters(command)
+	if e.WorkDir != "" {
+		params["workingDirectory"] = []string{e.WorkDir}
+	}
+	return params, nil
+}
+
+// shellCommand exports the environment before command and runs both with sudo as the user.
+// sudo keeps the working directory, and -n makes it fail instead of asking for a password.
+func (e CommandEnv) shellCommand(command string) string {
+	if len(e.Env) > 0 {
+		command = exportEnv(e.Env) + "\n" + command
+	}
+	if e.User != "" {
+		command = "sudo -n -H -u " + shellQuote(e.User) + " -- sh -c " + shellQuote(command)
+	}
+	return command
+}
+
+// powerShellCommand sets the environment before command.
+func (e CommandEnv) powerShellCommand(command string) string {
+	lines := make([]string, 0, len(e.Env)+1)
+	for _, name := range SortedMapKeys(e.Env) {
+		lines = append(lines, "$env:"+name+" = "+powerShellQuote(e.Env[name]))
+	}
+	return strings.Join(append(lines, command), "\n")
+}
+
+// exportEnv returns the shell command that exports env, in order of name so commands come out
+// the same every time.
+func exportEnv(env map[string]string) string {
+	vars := make([]string, 0, len(env))
+	for _, name := range SortedMapKeys(env) {
+		vars = append(vars, name+"="+shellQuote(env[name]))
+	}
+	return "export " + strings.Join(vars, " ")
+}
+
+// powerShellQuote quotes s as a verbatim PowerShell string. PowerShell also ends single-quoted
+// strings at typographic single quotes, so those are doubled as well.
+func powerShellQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range s {
+		if strings.ContainsRune("'\u2018\u2019\u201a\u201b", r) {
+			b.WriteRune(r)
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runLocally runs a generated command in the local shell, as the instance would.
+func runLocally(t *testing.T, command string) (string, int) {
+	t.Helper()
+	out, err := exec.Command("sh", "-c", command).CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return string(out), exitErr.ExitCode()
+	}
+	require.NoError(t, err)
+	return string(out), 0
+}
+
+func TestScriptCommand_RunsScriptWithArgs(t *testing.T) {
+	script := "#!/bin/sh\nprintf '%s|' \"$@\"\necho\nexit 3\n"
+
+	command, err := ScriptCommand("test.sh", []byte(script), []string{"plain", "two words", "it's", "$HOME"})
+	require.NoError(t, err)
+	out, code := runLocally(t, command)
+
+	assert.Equal(t, "plain|two words|it's|$HOME|\n", out)
+	assert.Equal(t, 3, code)
+}
+
+func TestScriptCommand_NoShebang_RunsWithSh(t *testing.T) {
+	command, err := ScriptCommand("test.sh", []byte("echo hello\n"), nil)
+	require.NoError(t, err)
+	out, code := runLocally(t, command)
+
+	assert.Equal(t, "hello\n", out)
+	assert.Zero(t, code)
+}
+
+func TestScriptCommand_Errors(t *testing.T) {
+	_, emptyErr := ScriptCommand("empty.sh", nil, nil)
+	_, largeErr := ScriptCommand("large.sh", []byte(strings.Repeat("x", maxCommandParameterSize)), nil)
+
+	assert.ErrorContains(t, emptyErr, "empty")
+	assert.ErrorContains(t, largeErr, "too large")
+}
+
+func TestScriptInterpreter(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{script: "#!/bin/bash -e\necho", want: "/bin/bash -e"},
+		{script: "#!/usr/bin/env python3\r\nprint(1)", want: "/usr/bin/env python3"},
+		{script: "echo", want: "sh"},
+		{script: "#!\necho", want: "sh"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, scriptInterpreter([]byte(tt.script)))
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "v1.2.3", want: "v1.2.3"},
+		{in: "--dry-run", want: "--dry-run"},
+		{in: "", want: "''"},
+		{in: "two words", want: "'two words'"},
+		{in: "it's", want: `'it'\''s'`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			assert.Equal(t, tt.want, shellQuote(tt.in))
+		})
+	}
+}
+
+func TestCommandEnv_Parameters_Shell(t *testing.T) {
+	env := CommandEnv{WorkDir: "/srv/app", Env: map[string]string{"MSG": "it's $HOME", "A": "1"}}
+
+	params, err := env.Parameters(ShellScriptDocument, `echo "$A $MSG"`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/srv/app"}, params["workingDirectory"])
+	require.Len(t, params["commands"], 1)
+	out, code := runLocally(t, params["commands"][0])
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "1 it's $HOME\n", out)
+
+	env.User = "app"
+	params, err = env.Parameters(ShellScriptDocument, "whoami")
+	require.NoError(t, err)
+	assert.Equal(t, `sudo -n -H -u app -- sh -c 'export A=1 MSG='\''it'\''\'\'''\''s $HOME'\''
+whoami'`, params["commands"][0])
+}
+
+func TestCommandEnv_Parameters_PowerShell(t *testing.T) {
+	env := CommandEnv{WorkDir: `C:\app`, Env: map[string]string{"GREETING": "it's ‘here’"}}
+
+	params, err := env.Parameters(PowerShellScriptDocument, "Get-ChildItem")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"$env:GREETING = 'it''s ‘‘here’’'\nGet-ChildItem"}, params["commands"])
+	assert.Equal(t, []string{`C:\app`}, params["workingDirectory"])
+
+	plain, err := CommandEnv{}.Parameters(PowerShellScriptDocument, "Get-ChildItem")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"commands": {"Get-ChildItem"}}, plain)
+}
+
+func TestCommandEnv_Parameters_Errors(t *testing.T) {
+	_, windowsUser := CommandEnv{User: "app"}.Parameters(PowerShellScriptDocument, "whoami")
+	_, optionUser := CommandEnv{User: "-s"}.Parameters(ShellScriptDocument, "whoami")
+	_, otherDocument := CommandEnv{}.Parameters("AWS-ConfigureAWSPackage", "whoami")
+
+	assert.ErrorContains(t, windowsUser, "Windows")
+	assert.ErrorContains(t, optionUser, "invalid user")
+	assert.ErrorContains(t, otherDocument, "AWS-ConfigureAWSPackage")
+}
+
+func TestParseEnv(t *testing.T) {
+	env, err := ParseEnv([]string{"A=1", "URL=https://x/?a=b", "EMPTY="})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"A": "1", "URL": "https://x/?a=b", "EMPTY": ""}, env)
+
+	_, err = ParseEnv([]string{"A-B=1"})
+	assert.ErrorContains(t, err, `invalid variable "A-B=1"`)
+}
+
+func TestScriptDocument(t *testing.T) {
+	linux := &Target{Name: "i-1", PlatformDetails: "Linux/UNIX"}
+	windows := &Target{Name: "i-2", PlatformDetails: "Windows"}
+	windowsAgent := &Target{Name: "i-3", PlatformName: "Microsoft Windows Server 2022 Datacenter"}
+
+	doc, err := ScriptDocument([]*Target{linux, {Name: "i-4"}})
+	require.NoError(t, err)
+	assert.Equal(t, ShellScriptDocument, doc)
+
+	doc, err = ScriptDocument([]*Target{windows, windowsAgent})
+	require.NoError(t, err)
+	assert.Equal(t, PowerShellScriptDocument, doc)
+
+	_, err = ScriptDocument([]*Target{linux, windows})
+	assert.ErrorContains(t, err, "1 of 2 targets run Windows")
+}
+
+func TestDescribePlatforms_WindowsGivenByID_RunsPowerShell(t *testing.T) {
+	ssmClient, ec2Client := newFleetClients(
+		fakeFleet{id: "i-0aaaaaaaa", platform: ssm_types.PlatformTypeLinux},
+		fakeFleet{id: "i-0bbbbbbbb", platform: ssm_types.PlatformTypeWindows},
+		fakeFleet{id: "i-0cccccccc", platform: ssm_types.PlatformTypeWindows},
+	)
+	resolver := NewTargetResolver(nil, nil, ssmClient, ec2Client)
+	targets, err := resolver.Resolve(context.Background(), []string{"i-0bbbbbbbb", "i-0cccccccc"})
+	require.NoError(t, err)
+
+	require.NoError(t, DescribePlatforms(context.Background(), ssmClient, targets))
+	doc, err := ScriptDocument(targets)
+
+	require.NoError(t, err)
+	assert.Equal(t, PowerShellScriptDocument, doc)
+	assert.Equal(t, "Windows", targets[0].PlatformType)
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// Spinner shows the progress of a long running operation on stderr.
+// When stderr is not a terminal, each message is printed once instead.
+type Spinner struct {
+	mu      sync.Mutex
+	message string
+	tty     bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// StartSpinner starts a spinner with the given message.
+func StartSpinner(message string) *Spinner {
+	s := &Spinner{
+		message: message,
+		tty:     term.IsTerminal(int(os.Stderr.Fd())),
+		done:    make(chan struct{}),
+	}
+	if !s.tty {
+		fmt.Fprintln(os.Stderr, color.YellowString(message))
+		return s
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			s.mu.Lock()
+			fmt.Fprintf(os.Stderr, "\r\033[K%s %s", color.YellowString(spinnerFrames[i%len(spinnerFrames)]), s.message)
+			s.mu.Unlock()
+			select {
+			case <-s.done:
+				fmt.Fprint(os.Stderr, "\r\033[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return s
+}
+
+// Update replaces the spinner message.
+func (s *Spinner) Update(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.message = message
+	if !s.tty {
+		fmt.Fprintln(os.Stderr, color.YellowString(message))
+	}
+}
+
+// Stop stops the spinner and clears its line.
+func (s *Spinner) Stop() {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	close(s.done)
+	s.wg.Wait()
+}
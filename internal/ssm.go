@@ -2,11 +2,15 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -23,6 +27,34 @@ import (
 
 const (
 	maxOutputResults = 50
+
+	// ShellScriptDocument runs shell commands on Linux instances.
+	ShellScriptDocument = "AWS-RunShellScript"
+	// PowerShellScriptDocument runs PowerShell commands on Windows instances.
+	PowerShellScriptDocument = "AWS-RunPowerShellScript"
+
+	// SendCommand limits
+	maxCommandInstanceIDs = 50
+	maxCommandTargets     = 5
+
+	// delivery and execution timeout bounds of SendCommand and AWS-RunShellScript
+	defaultDeliveryTimeout = 60 * time.Second
+	minDeliveryTimeout     = 30 * time.Second
+	maxDeliveryTimeout     = 30 * 24 * time.Hour
+	maxExecutionTimeout    = 48 * time.Hour
+
+	// invocationLookupRetries is how many polls an invocation may be missing before giving up;
+	// invocations show up shortly after SendCommand, especially for commands sent by Targets.
+	invocationLookupRetries = 10
+	// targetLookupRetries is how many times SendCommand looks for the instances of a command
+	// sent by Targets before it watches the instances resolved beforehand.
+	targetLookupRetries = 3
+)
+
+var (
+	// values accepted by SendCommand MaxConcurrency and MaxErrors: a count or a percentage
+	maxConcurrencyPattern = regexp.MustCompile(`^([1-9][0-9]*|[1-9][0-9]?%|100%)$`)
+	maxErrorsPattern      = regexp.MustCompile(`^([0-9]|[1-9][0-9]+|[0-9]%|[1-9][0-9]%|100%)$`)
 )
 
 var (
@@ -46,14 +78,141 @@ type (
 		Tags          map[string]string
 		PublicDomain  string
 		PrivateDomain string
-		displayKey    string // internal use for display formatting
+		State         string // EC2 instance state, such as running or stopped
+
+		// EC2 instance metadata from DescribeInstances
+		PrivateIP          string
+		PublicIP           string
+		InstanceType       string
+		AvailabilityZone   string
+		VpcID              string
+		SubnetID           string
+		LaunchTime         time.Time
+		IAMInstanceProfile string // instance profile name
+		PlatformDetails    string // such as Linux/UNIX or Windows
+		Architecture       string // such as x86_64 or arm64
+
+		// SSM agent health from DescribeInstanceInformation
+		PingStatus       string
+		AgentVersion     string
+		PlatformName     string
+		PlatformType     string // such as Linux or Windows
+		LastPingDateTime time.Time
+		IsLatestVersion  bool
+
+		displayKey string // internal use for display formatting
+	}
+
+	// FindOptions controls which instances FindInstances returns.
+	FindOptions struct {
+		// OnlineOnly keeps only instances whose SSM agent ping status is Online.
+		// Stopped instances included by IncludeStopped are kept regardless.
+		OnlineOnly bool
+		// IncludeStopped also returns stopped instances that are registered with SSM.
+		IncludeStopped bool
+		// IncludeUnmanaged also returns instances that are not registered with SSM, for
+		// diagnosing them.
+		IncludeUnmanaged bool
 	}
 
 	Region struct {
 		Name string
 	}
+
+	// SendCommandOptions controls how SendCommand addresses its targets.
+	SendCommandOptions struct {
+		// Selector is the tag selector the targets were resolved from, if any.
+		// A tag-only selector is sent as SSM Targets rather than instance IDs.
+		Selector *Selector
+
+		// MaxConcurrency and MaxErrors are passed to every SendCommand as a count ("10")
+		// or a percentage ("25%") of the command's targets. Empty uses the SSM defaults.
+		MaxConcurrency string
+		MaxErrors      string
+
+		// DeliveryTimeout is how long SSM tries to deliver the command to an instance
+		// (60s when zero). ExecutionTimeout bounds how long the script may run once
+		// delivered (the document default of one hour when zero).
+		DeliveryTimeout  time.Duration
+		ExecutionTimeout time.Duration
+
+		// OutputS3Bucket additionally stores the complete output in this bucket.
+		OutputS3Bucket string
+
+		// Comment is shown with the command in SSM, see IssuerComment.
+		Comment string
+
+		clock clock // waits for SSM to list the instances of a command sent by Targets; nil uses the real clock
+	}
+
+	// InvocationResult is the outcome of a command on one instance.
+	InvocationResult struct {
+		InstanceID    string
+		Name          string // Name tag of the instance, if any
+		CommandID     string
+		Step          string                            // document step, set for documents with several steps
+		Status        ssm_types.CommandInvocationStatus // empty when the final status is unknown
+		StatusDetails string
+		ResponseCode  int32  // exit code of the script, -1 when it did not run to completion
+		Skipped       string // why SSM did not complete the command, see skippedReason
+		Err           error  // set when the invocation could not be watched to the end
+
+		// complete output and execution times as reported by SSM, set once Status is
+		Stdout    string
+		Stderr    string
+		StartTime string
+		EndTime   string
+		Elapsed   string
+	}
+
+	// WatchOptions controls how PrintCommandInvocation shows invocations.
+	WatchOptions struct {
+		// NameMap maps instance IDs to their display names (may be nil).
+		NameMap map[string]string
+		// FullOutput fetches output truncated by GetCommandInvocation; a warning is shown
+		// when it is nil or fails.
+		FullOutput *FullOutput
+		// Follow, when set, streams each invocation's CloudWatch log output while it runs.
+		Follow CloudWatchLogsGetLogEventsAPI
+		// HideOutput prints only the status of each invocation, for callers that show the
+		// output of the results themselves, see PrintResultGroups.
+		HideOutput bool
+
+		clock clock // waits between polls; nil uses the real clock
+	}
+
+	// CommandBatch is one command sent by SendCommand and the instances it covers.
+	CommandBatch struct {
+		CommandID   string
+		InstanceIDs []string
+		Selector    *Selector // set when the command was sent by SSM Targets
+	}
 )
 
+// Validate checks MaxConcurrency and MaxErrors before any command is sent.
+func (o SendCommandOptions) Validate() error {
+	if o.MaxConcurrency != "" && !maxConcurrencyPattern.MatchString(o.MaxConcurrency) {
+		return fmt.Errorf("invalid max concurrency %q (must be a count of at least 1 or a percentage like 10%%)", o.MaxConcurrency)
+	}
+	if o.MaxErrors != "" && !maxErrorsPattern.MatchString(o.MaxErrors) {
+		return fmt.Errorf("invalid max errors %q (must be a count or a percentage like 10%%)", o.MaxErrors)
+	}
+	if o.DeliveryTimeout != 0 && (o.DeliveryTimeout < minDeliveryTimeout || o.DeliveryTimeout > maxDeliveryTimeout) {
+		return fmt.Errorf("invalid delivery timeout %s (must be between %s and %s)", o.DeliveryTimeout, minDeliveryTimeout, maxDeliveryTimeout)
+	}
+	if o.ExecutionTimeout < 0 || o.ExecutionTimeout > maxExecutionTimeout || (o.ExecutionTimeout > 0 && o.ExecutionTimeout < time.Second) {
+		return fmt.Errorf("invalid execution timeout %s (must be between 1s and %s)", o.ExecutionTimeout, maxExecutionTimeout)
+	}
+	return nil
+}
+
+// KnownRegions returns the AWS regions gossm offers when they cannot be looked up.
+func KnownRegions() []string {
+	regions := make([]string, len(defaultAwsRegions))
+	copy(regions, defaultAwsRegions)
+	return regions
+}
+
 // AskRegion asks you which selects a region.
 func AskRegion(ctx context.Context, client EC2DescribeRegionsAPI) (*Region, error) {
 	var regions []string
@@ -87,12 +246,32 @@ func AskRegion(ctx context.Context, client EC2DescribeRegionsAPI) (*Region, erro
 }
 
 // AskTarget asks you which selects an instance.
-func AskTarget(ctx context.Context, ssmClient SSMDescribeInstanceInfoAPI, ec2Client EC2DescribeInstancesAPI) (*Target, error) {
-	table, err := FindInstances(ctx, ssmClient, ec2Client)
+func AskTarget(ctx context.Context, ssmClient SSMDescribeInstanceInfoAPI, ec2Client EC2DescribeInstancesAPI, opts FindOptions) (*Target, error) {
+	table, err := FindInstances(ctx, ssmClient, ec2Client, opts)
 	if err != nil {
 		return nil, err
 	}
+	return selectTarget(table)
+}
+
+// ChooseTarget returns the target when there is only one, otherwise asks you which selects one of them.
+func ChooseTarget(targets []*Target) (*Target, error) {
+	if len(targets) == 1 {
+		return targets[0], nil
+	}
+	table := make(map[string]*Target, len(targets))
+	for _, t := range targets {
+		key := t.displayKey
+		if key == "" {
+			key = t.Name
+		}
+		table[key] = t
+	}
+	return selectTarget(table)
+}
 
+// selectTarget prompts for one target of the table.
+func selectTarget(table map[string]*Target) (*Target, error) {
 	options := make([]string, 0, len(table))
 	for k := range table {
 		options = append(options, k)
@@ -118,8 +297,8 @@ func AskTarget(ctx context.Context, ssmClient SSMDescribeInstanceInfoAPI, ec2Cli
 }
 
 // AskMultiTarget asks you to select multiple instances.
-func AskMultiTarget(ctx context.Context, ssmClient SSMDescribeInstanceInfoAPI, ec2Client EC2DescribeInstancesAPI) ([]*Target, error) {
-	table, err := FindInstances(ctx, ssmClient, ec2Client)
+func AskMultiTarget(ctx context.Context, ssmClient SSMDescribeInstanceInfoAPI, ec2Client EC2DescribeInstancesAPI, opts FindOptions) ([]*Target, error) {
+	table, err := FindInstances(ctx, ssmClient, ec2Client, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -164,106 +343,206 @@ func PrintReadyMulti(cmd, region string, targets []*Target) {
 }
 
 // FindInstances returns all of instances-map with running state.
-func FindInstances(ctx context.Context, ssmClient SSMDescribeInstanceInfoAPI, ec2Client EC2DescribeInstancesAPI) (map[string]*Target, error) {
+func FindInstances(ctx context.Context, ssmClient SSMDescribeInstanceInfoAPI, ec2Client EC2DescribeInstancesAPI, opts FindOptions) (map[string]*Target, error) {
 	timer := StartTimer("FindInstances")
 	defer timer.Stop()
 
+	states := []string{string(ec2_types.InstanceStateNameRunning)}
+	if opts.IncludeStopped {
+		states = append(states, string(ec2_types.InstanceStateNameStopped))
+	}
+	ssmInstances, ec2Instances, err := describeFleet(ctx, ssmClient, ec2Client, states)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build result: only instances with SSM connected, unless unmanaged ones are asked for
+	result := make(map[string]*Target)
+	for instanceID, target := range ec2Instances {
+		info, connected := ssmInstances[instanceID]
+		if !connected && !opts.IncludeUnmanaged {
+			continue
+		}
+		if connected {
+			setAgentInfo(target, info)
+		}
+		stopped := target.State == string(ec2_types.InstanceStateNameStopped)
+		if opts.OnlineOnly && !stopped && target.PingStatus != string(ssm_types.PingStatusOnline) {
+			continue
+		}
+		target.displayKey = fmt.Sprintf("%s\t(%s)%s", target.TagName, instanceID, agentMarker(target))
+		result[target.displayKey] = target
+	}
+
+	return result, nil
+}
+
+// FindUnmanagedInstances returns running instances that are not registered with SSM,
+// which FindInstances leaves out.
+func FindUnmanagedInstances(ctx context.Context, ssmClient SSMDescribeInstanceInfoAPI, ec2Client EC2DescribeInstancesAPI) ([]*Target, error) {
+	ssmInstances, ec2Instances, err := describeFleet(ctx, ssmClient, ec2Client, []string{string(ec2_types.InstanceStateNameRunning)})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Target, 0)
+	for instanceID, target := range ec2Instances {
+		if _, managed := ssmInstances[instanceID]; !managed {
+			result = append(result, target)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// describeFleet fetches SSM instance information and EC2 instances in the given states in parallel,
+// both keyed by instance ID.
+func describeFleet(ctx context.Context, ssmClient SSMDescribeInstanceInfoAPI, ec2Client EC2DescribeInstancesAPI, states []string) (map[string]ssm_types.InstanceInformation, map[string]*Target, error) {
 	var (
-		ssmInstanceIDs []string
-		ec2Instances   = make(map[string]*Target)
+		ssmInstances   map[string]ssm_types.InstanceInformation
+		ec2Instances   map[string]*Target
 		ssmErr, ec2Err error
 		wg             sync.WaitGroup
 	)
 
-	// Fetch SSM-connected instances in parallel
-	wg.Add(1)
+	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		ssmTimer := StartTimer("SSM DescribeInstanceInformation")
-		defer ssmTimer.Stop()
-
-		input := &ssm.DescribeInstanceInformationInput{MaxResults: aws.Int32(maxOutputResults)}
-
-		for {
-			output, err := ssmClient.DescribeInstanceInformation(ctx, input)
-			if err != nil {
-				ssmErr = err
-				return
-			}
-			for _, inst := range output.InstanceInformationList {
-				ssmInstanceIDs = append(ssmInstanceIDs, aws.ToString(inst.InstanceId))
-			}
-			if output.NextToken == nil {
-				break
-			}
-			input.NextToken = output.NextToken
-		}
+		ssmInstances, ssmErr = describeInstanceInformation(ctx, ssmClient, nil)
 	}()
-
-	// Fetch EC2 instances in parallel
-	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		ec2Timer := StartTimer("EC2 DescribeInstances")
-		defer ec2Timer.Stop()
-
-		input := &ec2.DescribeInstancesInput{
-			Filters: []ec2_types.Filter{
-				{Name: aws.String("instance-state-name"), Values: []string{"running"}},
-			},
-		}
-
-		for {
-			output, err := ec2Client.DescribeInstances(ctx, input)
-			if err != nil {
-				ec2Err = err
-				return
-			}
-			for _, rv := range output.Reservations {
-				for _, inst := range rv.Instances {
-					instanceID := aws.ToString(inst.InstanceId)
-					name := getInstanceName(inst.Tags)
-					tags := getInstanceTags(inst.Tags)
-					ec2Instances[instanceID] = &Target{
-						Name:          instanceID,
-						TagName:       name,
-						Tags:          tags,
-						PublicDomain:  aws.ToString(inst.PublicDnsName),
-						PrivateDomain: aws.ToString(inst.PrivateDnsName),
-						displayKey:    fmt.Sprintf("%s\t(%s)", name, instanceID),
-					}
-				}
-			}
-			if output.NextToken == nil {
-				break
-			}
-			input.NextToken = output.NextToken
-		}
+		ec2Instances, ec2Err = describeEC2Instances(ctx, ec2Client, states)
 	}()
-
 	wg.Wait()
 
 	if ssmErr != nil {
-		return nil, ssmErr
+		return nil, nil, ssmErr
 	}
 	if ec2Err != nil {
-		return nil, ec2Err
+		return nil, nil, ec2Err
 	}
+	return ssmInstances, ec2Instances, nil
+}
+
+// describeInstanceInformation returns SSM instance information keyed by instance ID,
+// optionally filtered by the given filters.
+func describeInstanceInformation(ctx context.Context, ssmClient SSMDescribeInstanceInfoAPI, filters []ssm_types.InstanceInformationStringFilter) (map[string]ssm_types.InstanceInformation, error) {
+	timer := StartTimer("SSM DescribeInstanceInformation")
+	defer timer.Stop()
 
-	// Build result: only instances with SSM connected
-	ssmSet := make(map[string]struct{}, len(ssmInstanceIDs))
-	for _, id := range ssmInstanceIDs {
-		ssmSet[id] = struct{}{}
+	instances := make(map[string]ssm_types.InstanceInformation)
+	input := &ssm.DescribeInstanceInformationInput{
+		MaxResults: aws.Int32(maxOutputResults),
+		Filters:    filters,
 	}
 
-	result := make(map[string]*Target)
-	for instanceID, target := range ec2Instances {
-		if _, connected := ssmSet[instanceID]; connected {
-			result[target.displayKey] = target
+	for {
+		output, err := ssmClient.DescribeInstanceInformation(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, inst := range output.InstanceInformationList {
+			instances[aws.ToString(inst.InstanceId)] = inst
+		}
+		if output.NextToken == nil {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+	return instances, nil
+}
+
+// describeEC2Instances returns EC2 instances in the given states keyed by instance ID.
+func describeEC2Instances(ctx context.Context, ec2Client EC2DescribeInstancesAPI, states []string) (map[string]*Target, error) {
+	timer := StartTimer("EC2 DescribeInstances")
+	defer timer.Stop()
+
+	instances := make(map[string]*Target)
+	input := &ec2.DescribeInstancesInput{
+		Filters: []ec2_types.Filter{
+			{Name: aws.String("instance-state-name"), Values: states},
+		},
+	}
+
+	for {
+		output, err := ec2Client.DescribeInstances(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, rv := range output.Reservations {
+			for _, inst := range rv.Instances {
+				instances[aws.ToString(inst.InstanceId)] = newEC2Target(inst)
+			}
+		}
+		if output.NextToken == nil {
+			break
 		}
+		input.NextToken = output.NextToken
 	}
+	return instances, nil
+}
 
-	return result, nil
+// newEC2Target builds a target from an EC2 instance.
+func newEC2Target(inst ec2_types.Instance) *Target {
+	target := &Target{
+		Name:            aws.ToString(inst.InstanceId),
+		TagName:         getInstanceName(inst.Tags),
+		Tags:            getInstanceTags(inst.Tags),
+		PublicDomain:    aws.ToString(inst.PublicDnsName),
+		PrivateDomain:   aws.ToString(inst.PrivateDnsName),
+		State:           instanceState(inst),
+		PrivateIP:       aws.ToString(inst.PrivateIpAddress),
+		PublicIP:        aws.ToString(inst.PublicIpAddress),
+		InstanceType:    string(inst.InstanceType),
+		VpcID:           aws.ToString(inst.VpcId),
+		SubnetID:        aws.ToString(inst.SubnetId),
+		LaunchTime:      aws.ToTime(inst.LaunchTime),
+		PlatformDetails: aws.ToString(inst.PlatformDetails),
+		Architecture:    string(inst.Architecture),
+	}
+	if inst.Placement != nil {
+		target.AvailabilityZone = aws.ToString(inst.Placement.AvailabilityZone)
+	}
+	if inst.IamInstanceProfile != nil {
+		target.IAMInstanceProfile = instanceProfileName(aws.ToString(inst.IamInstanceProfile.Arn))
+	}
+	return target
+}
+
+// setAgentInfo copies SSM agent health onto the target.
+func setAgentInfo(target *Target, info ssm_types.InstanceInformation) {
+	target.PingStatus = string(info.PingStatus)
+	target.AgentVersion = aws.ToString(info.AgentVersion)
+	target.PlatformName = aws.ToString(info.PlatformName)
+	target.PlatformType = string(info.PlatformType)
+	target.LastPingDateTime = aws.ToTime(info.LastPingDateTime)
+	target.IsLatestVersion = aws.ToBool(info.IsLatestVersion)
+}
+
+// instanceState returns the state name of an EC2 instance.
+func instanceState(inst ec2_types.Instance) string {
+	if inst.State == nil {
+		return ""
+	}
+	return string(inst.State.Name)
+}
+
+// agentMarker returns a picker suffix flagging stopped instances and unhealthy or outdated SSM agents.
+func agentMarker(t *Target) string {
+	var marks []string
+	if t.State != "" && t.State != string(ec2_types.InstanceStateNameRunning) {
+		marks = append(marks, t.State)
+	} else if t.PingStatus != "" && t.PingStatus != string(ssm_types.PingStatusOnline) {
+		marks = append(marks, t.PingStatus)
+	}
+	if t.AgentVersion != "" && !t.IsLatestVersion {
+		marks = append(marks, "agent outdated")
+	}
+	if len(marks) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\t[%s]", strings.Join(marks, ", "))
 }
 
 // getInstanceName extracts the Name tag value from EC2 instance tags.
@@ -373,99 +652,234 @@ func DeleteStartSession(ctx context.Context, client SSMSessionAPI, input *ssm.Te
 	return err
 }
 
-// SendCommand send commands to instance targets.
-func SendCommand(ctx context.Context, client SSMCommandAPI, targets []*Target, command string) (*ssm.SendCommandOutput, error) {
+// SendCommand sends a Command document with params to instance targets.
+// Instance IDs are sent in batches of at most 50, the SendCommand limit. When opts.Selector
+// is a tag-only selector the command is sent once by SSM Targets instead, which has no limit;
+// its batch then lists the instances SSM runs it on, see targetedInstances.
+// It returns one batch per command sent.
+func SendCommand(ctx context.Context, client SSMCommandAPI, targets []*Target, document string, params map[string][]string, opts SendCommandOptions) ([]CommandBatch, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
 	timer := StartTimer("SSM SendCommand API")
 	defer timer.Stop()
 
-	// only support to linux (window = "AWS-RunPowerShellScript")
-	docName := "AWS-RunShellScript"
-
 	ids := make([]string, 0, len(targets))
 	for _, t := range targets {
 		ids = append(ids, t.Name)
 	}
 
+	if ssmTargets := commandTargets(opts.Selector); ssmTargets != nil {
+		input := newSendCommandInput(document, params, opts)
+		input.Targets = ssmTargets
+		output, err := client.SendCommand(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		commandID := aws.ToString(output.Command.CommandId)
+		return []CommandBatch{{CommandID: commandID, InstanceIDs: targetedInstances(ctx, client, commandID, ids, opts.clock), Selector: opts.Selector}}, nil
+	}
+
+	total := (len(ids) + maxCommandInstanceIDs - 1) / maxCommandInstanceIDs
+	batches := make([]CommandBatch, 0, total)
+	for start := 0; start < len(ids); start += maxCommandInstanceIDs {
+		batch := ids[start:min(start+maxCommandInstanceIDs, len(ids))]
+		input := newSendCommandInput(document, params, opts)
+		input.InstanceIds = batch
+		output, err := client.SendCommand(ctx, input)
+		if err != nil {
+			if len(batches) > 0 {
+				return batches, fmt.Errorf("sent %d of %d batches: %w", len(batches), total, err)
+			}
+			return nil, err
+		}
+		batches = append(batches, CommandBatch{CommandID: aws.ToString(output.Command.CommandId), InstanceIDs: batch})
+	}
+	return batches, nil
+}
+
+// targetedInstances returns the instances a command sent by Targets runs on: ids, which
+// matched the targets when they were resolved, and then any instance SSM lists for the
+// command besides, such as one that joined the group or gained the tag since. SSM lists
+// the invocations shortly after the command is sent; until it does, ids are returned.
+func targetedInstances(ctx context.Context, client SSMCommandAPI, commandID string, ids []string, clk clock) []string {
+	if clk == nil {
+		clk = realClock{}
+	}
+	wait := &backoff{min: minPollInterval, max: maxPollInterval}
+	for attempt := 0; ; attempt++ {
+		listed, err := listInvocationInstances(ctx, client, commandID)
+		if err != nil {
+			DebugLog("cannot list the instances of %s: %v", commandID, err)
+			return ids
+		}
+		if len(listed) > 0 {
+			return mergeInstanceIDs(ids, listed)
+		}
+		if attempt >= targetLookupRetries || !sleep(ctx, clk, wait.next()) {
+			return ids
+		}
+	}
+}
+
+// listInvocationInstances returns the IDs of the instances a command has invocations on.
+func listInvocationInstances(ctx context.Context, client SSMCommandAPI, commandID string) ([]string, error) {
+	input := &ssm.ListCommandInvocationsInput{CommandId: aws.String(commandID)}
+	var ids []string
+	for {
+		output, err := client.ListCommandInvocations(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, inv := range output.CommandInvocations {
+			ids = append(ids, aws.ToString(inv.InstanceId))
+		}
+		if output.NextToken == nil {
+			return ids, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// mergeInstanceIDs returns ids followed by the sorted IDs of listed that are not in ids.
+func mergeInstanceIDs(ids, listed []string) []string {
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		seen[id] = true
+	}
+	var added []string
+	for _, id := range listed {
+		if !seen[id] {
+			seen[id] = true
+			added = append(added, id)
+		}
+	}
+	sort.Strings(added)
+	return append(slices.Clone(ids), added...)
+}
+
+// ShellScriptParameters returns the AWS-RunShellScript parameters that run command.
+func ShellScriptParameters(command string) map[string][]string {
+	return map[string][]string{"commands": {command}}
+}
+
+// newSendCommandInput returns the SendCommand input for a document, without targets.
+func newSendCommandInput(document string, params map[string][]string, opts SendCommandOptions) *ssm.SendCommandInput {
+	parameters := make(map[string][]string, len(params)+1)
+	for k, v := range params {
+		parameters[k] = v
+	}
+	deliveryTimeout := opts.DeliveryTimeout
+	if deliveryTimeout == 0 {
+		deliveryTimeout = defaultDeliveryTimeout
+	}
 	input := &ssm.SendCommandInput{
-		DocumentName:   &docName,
-		InstanceIds:    ids,
-		TimeoutSeconds: aws.Int32(60),
+		DocumentName:   aws.String(document),
+		TimeoutSeconds: aws.Int32(int32(deliveryTimeout / time.Second)),
 		CloudWatchOutputConfig: &ssm_types.CloudWatchOutputConfig{
 			CloudWatchOutputEnabled: true,
 		},
-		Parameters: map[string][]string{"commands": {command}},
+		Parameters: parameters,
+	}
+	if opts.MaxConcurrency != "" {
+		input.MaxConcurrency = aws.String(opts.MaxConcurrency)
+	}
+	if opts.MaxErrors != "" {
+		input.MaxErrors = aws.String(opts.MaxErrors)
+	}
+	if opts.OutputS3Bucket != "" {
+		input.OutputS3BucketName = aws.String(opts.OutputS3Bucket)
+	}
+	if opts.Comment != "" {
+		input.Comment = aws.String(opts.Comment)
+	}
+	if opts.ExecutionTimeout > 0 {
+		input.Parameters["executionTimeout"] = []string{strconv.Itoa(int(opts.ExecutionTimeout / time.Second))}
 	}
+	return input
+}
 
-	return client.SendCommand(ctx, input)
+// skippedReason returns why SSM did not complete the command on an instance, or "" when it
+// did. Invocations are cancelled once MaxErrors is exceeded or by CancelCommand, and
+// undeliverable when the instance could not be reached.
+func skippedReason(output *ssm.GetCommandInvocationOutput) string {
+	switch {
+	case output.Status == ssm_types.CommandInvocationStatusCancelled:
+		return "cancelled"
+	case aws.ToString(output.StatusDetails) == "Undeliverable":
+		return "undeliverable"
+	default:
+		return ""
+	}
 }
 
-// PrintCommandInvocation watches command invocations.
-// nameMap maps instance IDs to their display names (may be nil).
-func PrintCommandInvocation(ctx context.Context, client SSMCommandAPI, inputs []*ssm.GetCommandInvocationInput, nameMap map[string]string) {
+// commandTargets converts a tag-only selector to SSM Targets. It returns nil when the
+// selector is nil, has other terms, or has more tags than SendCommand accepts.
+func commandTargets(sel *Selector) []ssm_types.Target {
+	if sel == nil || len(sel.Tags) == 0 || len(sel.Tags) > maxCommandTargets || len(sel.Fields) > 0 || sel.SelectsGroupMembers() {
+		return nil
+	}
+	targets := make([]ssm_types.Target, 0, len(sel.Tags))
+	for _, k := range SortedMapKeys(sel.Tags) {
+		targets = append(targets, ssm_types.Target{Key: aws.String(tagPrefix + k), Values: []string{sel.Tags[k]}})
+	}
+	return targets
+}
 
-	wg := new(sync.WaitGroup)
-	for _, input := range inputs {
-		wg.Add(1)
-		go func(input *ssm.GetCommandInvocationInput) {
-			defer wg.Done()
-			instanceID := aws.ToString(input.InstanceId)
-			tagName := "-"
-			if nameMap != nil {
-				if v := nameMap[instanceID]; v != "" {
-					tagName = v
-				}
-			}
-			header := fmt.Sprintf("[%%s][%s][%s]", color.YellowString(instanceID), color.CyanString(tagName))
-
-			for {
-				// Check for context cancellation to prevent goroutine leak
-				select {
-				case <-ctx.Done():
-					color.Yellow("[canceled] %s", instanceID)
-					return
-				default:
-				}
-
-				time.Sleep(1 * time.Second)
-				output, err := client.GetCommandInvocation(ctx, input)
-				if err != nil {
-					color.Red("[err] %v", err)
-					return
-				}
-				status := strings.ToLower(string(output.Status))
-				switch status {
-				case "pending", "inprogress", "delayed":
-					continue
-				case "success":
-					stdout := aws.ToString(output.StandardOutputContent)
-					if stdout == "" {
-						stdout = "(no output)"
-					}
-					fmt.Printf(header+"\n%s\n", color.GreenString("success"), stdout)
-					return
-				default:
-					// Show both stdout and stderr for failed commands
-					stdout := aws.ToString(output.StandardOutputContent)
-					stderr := aws.ToString(output.StandardErrorContent)
-					statusDetail := aws.ToString(output.StatusDetails)
-
-					fmt.Printf(header+" status: %s\n", color.RedString("failed"), color.RedString(statusDetail))
-					if stdout != "" {
-						fmt.Printf("stdout: %s\n", stdout)
-					}
-					if stderr != "" {
-						fmt.Printf("stderr: %s\n", color.RedString(stderr))
-					}
-					if stdout == "" && stderr == "" {
-						fmt.Printf("(no output)\n")
-					}
-					return
-				}
-			}
-		}(input)
+// InvocationInputs returns one GetCommandInvocation input per instance of every batch.
+func InvocationInputs(batches []CommandBatch) []*ssm.GetCommandInvocationInput {
+	var inputs []*ssm.GetCommandInvocationInput
+	for _, b := range batches {
+		for _, id := range b.InstanceIDs {
+			inputs = append(inputs, &ssm.GetCommandInvocationInput{
+				CommandId:  aws.String(b.CommandID),
+				InstanceId: aws.String(id),
+			})
+		}
 	}
+	return inputs
+}
 
-	wg.Wait()
+// PrintCommandInvocation watches command invocations, which may belong to different commands,
+// and returns their results in the order of inputs. The statuses of each command are polled
+// together, and an invocation's output is fetched once it finished.
+func PrintCommandInvocation(ctx context.Context, client SSMCommandAPI, inputs []*ssm.GetCommandInvocationInput, opts WatchOptions) []InvocationResult {
+	results := make([]InvocationResult, len(inputs))
+	newInvocationPoller(client, inputs, results, opts).run(ctx)
+
+	var skipped []string
+	for _, r := range results {
+		if r.Skipped != "" {
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", r.InstanceID, r.Skipped))
+		}
+	}
+	if len(skipped) > 0 {
+		sort.Strings(skipped)
+		fmt.Printf("%s %d instance(s) did not complete the command: %s\n",
+			color.YellowString("[skipped]"), len(skipped), strings.Join(skipped, ", "))
+	}
+	return results
+}
+
+// Succeeded reports whether the command ran successfully on the instance.
+func (r InvocationResult) Succeeded() bool {
+	return r.Err == nil && r.Status == ssm_types.CommandInvocationStatusSuccess
+}
+
+// TimedOut reports whether the command timed out on the instance, or the caller stopped
+// waiting for it.
+func (r InvocationResult) TimedOut() bool {
+	return r.Status == ssm_types.CommandInvocationStatusTimedOut || errors.Is(r.Err, context.DeadlineExceeded)
+}
+
+// printStopped reports an invocation that is no longer watched because ctx ended.
+func printStopped(ctx context.Context, instanceID string) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		color.Yellow("[timeout] %s still running", instanceID)
+		return
+	}
+	color.Yellow("[canceled] %s", instanceID)
 }
 
 func PrintReady(cmd, region, target string) {
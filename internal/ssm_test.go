@@ -2,11 +2,14 @@ package internal
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2_types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
 	"github.com/stretchr/testify/assert"
@@ -22,7 +25,7 @@ func TestFindInstances_ValidConfig_ReturnsNoError(t *testing.T) {
 	ssmClient := ssm.NewFromConfig(cfg)
 	ec2Client := ec2.NewFromConfig(cfg)
 
-	_, err = FindInstances(context.Background(), ssmClient, ec2Client)
+	_, err = FindInstances(context.Background(), ssmClient, ec2Client, FindOptions{})
 
 	assert.NoError(t, err)
 }
@@ -71,6 +74,9 @@ func TestPrintReadyMulti_SingleTarget_DoesNotPanic(t *testing.T) {
 type mockSSMCommandAPI struct {
 	getCommandInvocationFunc func(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error)
 	sendCommandFunc          func(ctx context.Context, params *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error)
+	cancelCommandFunc        func(ctx context.Context, params *ssm.CancelCommandInput, optFns ...func(*ssm.Options)) (*ssm.CancelCommandOutput, error)
+
+	listCommandInvocationsFunc func(ctx context.Context, params *ssm.ListCommandInvocationsInput, optFns ...func(*ssm.Options)) (*ssm.ListCommandInvocationsOutput, error)
 }
 
 func (m *mockSSMCommandAPI) SendCommand(ctx context.Context, params *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error) {
@@ -81,6 +87,25 @@ func (m *mockSSMCommandAPI) GetCommandInvocation(ctx context.Context, params *ss
 	return m.getCommandInvocationFunc(ctx, params, optFns...)
 }
 
+func (m *mockSSMCommandAPI) ListCommandInvocations(ctx context.Context, params *ssm.ListCommandInvocationsInput, optFns ...func(*ssm.Options)) (*ssm.ListCommandInvocationsOutput, error) {
+	return m.listCommandInvocationsFunc(ctx, params, optFns...)
+}
+
+// listedAs returns a ListCommandInvocations that lists instanceIDs with status.
+func listedAs(status ssm_types.CommandInvocationStatus, instanceIDs ...string) func(ctx context.Context, params *ssm.ListCommandInvocationsInput, optFns ...func(*ssm.Options)) (*ssm.ListCommandInvocationsOutput, error) {
+	return func(ctx context.Context, params *ssm.ListCommandInvocationsInput, optFns ...func(*ssm.Options)) (*ssm.ListCommandInvocationsOutput, error) {
+		output := &ssm.ListCommandInvocationsOutput{}
+		for _, id := range instanceIDs {
+			output.CommandInvocations = append(output.CommandInvocations, ssm_types.CommandInvocation{CommandId: params.CommandId, InstanceId: aws.String(id), Status: status})
+		}
+		return output, nil
+	}
+}
+
+func (m *mockSSMCommandAPI) CancelCommand(ctx context.Context, params *ssm.CancelCommandInput, optFns ...func(*ssm.Options)) (*ssm.CancelCommandOutput, error) {
+	return m.cancelCommandFunc(ctx, params, optFns...)
+}
+
 func TestPrintCommandInvocation_Success_PrintsNameTag(t *testing.T) {
 	mock := &mockSSMCommandAPI{
 		getCommandInvocationFunc: func(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
@@ -90,6 +115,7 @@ func TestPrintCommandInvocation_Success_PrintsNameTag(t *testing.T) {
 				StandardOutputContent: aws.String("hello"),
 			}, nil
 		},
+		listCommandInvocationsFunc: listedAs(ssm_types.CommandInvocationStatusSuccess, "i-0abc123", "i-0def456"),
 	}
 
 	inputs := []*ssm.GetCommandInvocationInput{
@@ -102,7 +128,7 @@ func TestPrintCommandInvocation_Success_PrintsNameTag(t *testing.T) {
 	}
 
 	assert.NotPanics(t, func() {
-		PrintCommandInvocation(context.Background(), mock, inputs, nameMap)
+		PrintCommandInvocation(context.Background(), mock, inputs, WatchOptions{NameMap: nameMap})
 	})
 }
 
@@ -115,6 +141,7 @@ func TestPrintCommandInvocation_NilNameMap_DoesNotPanic(t *testing.T) {
 				StandardOutputContent: aws.String("ok"),
 			}, nil
 		},
+		listCommandInvocationsFunc: listedAs(ssm_types.CommandInvocationStatusSuccess, "i-0abc123"),
 	}
 
 	inputs := []*ssm.GetCommandInvocationInput{
@@ -122,7 +149,7 @@ func TestPrintCommandInvocation_NilNameMap_DoesNotPanic(t *testing.T) {
 	}
 
 	assert.NotPanics(t, func() {
-		PrintCommandInvocation(context.Background(), mock, inputs, nil)
+		PrintCommandInvocation(context.Background(), mock, inputs, WatchOptions{})
 	})
 }
 
@@ -136,6 +163,7 @@ func TestPrintCommandInvocation_Failed_DoesNotPanic(t *testing.T) {
 				StandardErrorContent: aws.String("command not found"),
 			}, nil
 		},
+		listCommandInvocationsFunc: listedAs(ssm_types.CommandInvocationStatusFailed, "i-0abc123"),
 	}
 
 	inputs := []*ssm.GetCommandInvocationInput{
@@ -144,7 +172,7 @@ func TestPrintCommandInvocation_Failed_DoesNotPanic(t *testing.T) {
 	nameMap := map[string]string{"i-0abc123": "my-server"}
 
 	assert.NotPanics(t, func() {
-		PrintCommandInvocation(context.Background(), mock, inputs, nameMap)
+		PrintCommandInvocation(context.Background(), mock, inputs, WatchOptions{NameMap: nameMap})
 	})
 }
 
@@ -180,3 +208,504 @@ func TestFormatTags_SingleTag_ReturnsJSON(t *testing.T) {
 	expected := "{\n    Env = \"staging\"\n}"
 	assert.Equal(t, expected, result)
 }
+
+// mockSSMDescribeInstanceInfoAPI implements SSMDescribeInstanceInfoAPI for testing.
+type mockSSMDescribeInstanceInfoAPI struct {
+	describeInstanceInformationFunc func(ctx context.Context, params *ssm.DescribeInstanceInformationInput, optFns ...func(*ssm.Options)) (*ssm.DescribeInstanceInformationOutput, error)
+}
+
+func (m *mockSSMDescribeInstanceInfoAPI) DescribeInstanceInformation(ctx context.Context, params *ssm.DescribeInstanceInformationInput, optFns ...func(*ssm.Options)) (*ssm.DescribeInstanceInformationOutput, error) {
+	return m.describeInstanceInformationFunc(ctx, params, optFns...)
+}
+
+// mockEC2DescribeInstancesAPI implements EC2DescribeInstancesAPI for testing.
+type mockEC2DescribeInstancesAPI struct {
+	describeInstancesFunc func(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+}
+
+func (m *mockEC2DescribeInstancesAPI) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	return m.describeInstancesFunc(ctx, params, optFns...)
+}
+
+// fakeFleet is a test instance with SSM agent connected.
+type fakeFleet struct {
+	id       string
+	tags     map[string]string
+	ping     ssm_types.PingStatus        // defaults to Online
+	state    ec2_types.InstanceStateName // defaults to running
+	typ      ec2_types.InstanceType
+	platform ssm_types.PlatformType
+}
+
+// newFleetClients returns mock clients that report the given instances as running and SSM-connected.
+func newFleetClients(fleet ...fakeFleet) (*mockSSMDescribeInstanceInfoAPI, *mockEC2DescribeInstancesAPI) {
+	ssmClient := &mockSSMDescribeInstanceInfoAPI{
+		describeInstanceInformationFunc: func(ctx context.Context, params *ssm.DescribeInstanceInformationInput, optFns ...func(*ssm.Options)) (*ssm.DescribeInstanceInformationOutput, error) {
+			ids := map[string]bool{}
+			for _, filter := range params.Filters {
+				if aws.ToString(filter.Key) == "InstanceIds" {
+					for _, v := range filter.Values {
+						ids[v] = true
+					}
+				}
+			}
+			out := &ssm.DescribeInstanceInformationOutput{}
+			for _, f := range fleet {
+				if len(ids) > 0 && !ids[f.id] {
+					continue
+				}
+				ping := f.ping
+				if ping == "" {
+					ping = ssm_types.PingStatusOnline
+				}
+				out.InstanceInformationList = append(out.InstanceInformationList, ssm_types.InstanceInformation{
+					InstanceId:      aws.String(f.id),
+					PingStatus:      ping,
+					AgentVersion:    aws.String("3.3.40.0"),
+					IsLatestVersion: aws.Bool(true),
+					PlatformType:    f.platform,
+				})
+			}
+			return out, nil
+		},
+	}
+	ec2Client := &mockEC2DescribeInstancesAPI{
+		describeInstancesFunc: func(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+			states := map[string]bool{}
+			for _, filter := range params.Filters {
+				if aws.ToString(filter.Name) == "instance-state-name" {
+					for _, v := range filter.Values {
+						states[v] = true
+					}
+				}
+			}
+			instances := make([]ec2_types.Instance, 0, len(fleet))
+			for _, f := range fleet {
+				state := f.state
+				if state == "" {
+					state = ec2_types.InstanceStateNameRunning
+				}
+				if len(states) > 0 && !states[string(state)] {
+					continue
+				}
+				var tags []ec2_types.Tag
+				for k, v := range f.tags {
+					tags = append(tags, ec2_types.Tag{Key: aws.String(k), Value: aws.String(v)})
+				}
+				instances = append(instances, ec2_types.Instance{
+					InstanceId:   aws.String(f.id),
+					Tags:         tags,
+					State:        &ec2_types.InstanceState{Name: state},
+					InstanceType: f.typ,
+				})
+			}
+			return &ec2.DescribeInstancesOutput{
+				Reservations: []ec2_types.Reservation{{Instances: instances}},
+			}, nil
+		},
+	}
+	return ssmClient, ec2Client
+}
+
+func TestFindInstances_MockClients_ReturnsOnlySSMConnected(t *testing.T) {
+	ssmClient, _ := newFleetClients(fakeFleet{id: "i-0aaaaaaaa"})
+	_, ec2Client := newFleetClients(
+		fakeFleet{id: "i-0aaaaaaaa", tags: map[string]string{"Name": "web"}},
+		fakeFleet{id: "i-0bbbbbbbb", tags: map[string]string{"Name": "unmanaged"}},
+	)
+
+	table, err := FindInstances(context.Background(), ssmClient, ec2Client, FindOptions{})
+
+	require.NoError(t, err)
+	require.Len(t, table, 1)
+	for _, target := range table {
+		assert.Equal(t, "i-0aaaaaaaa", target.Name)
+		assert.Equal(t, "web", target.TagName)
+	}
+}
+
+func TestFindInstances_OnlineOnly_SkipsConnectionLost(t *testing.T) {
+	ssmClient, ec2Client := newFleetClients(
+		fakeFleet{id: "i-0aaaaaaaa", tags: map[string]string{"Name": "web"}},
+		fakeFleet{id: "i-0bbbbbbbb", tags: map[string]string{"Name": "lost"}, ping: ssm_types.PingStatusConnectionLost},
+	)
+
+	all, err := FindInstances(context.Background(), ssmClient, ec2Client, FindOptions{})
+	require.NoError(t, err)
+	online, err := FindInstances(context.Background(), ssmClient, ec2Client, FindOptions{OnlineOnly: true})
+	require.NoError(t, err)
+
+	assert.Len(t, all, 2)
+	assert.Contains(t, all, "lost\t(i-0bbbbbbbb)\t[ConnectionLost]")
+	require.Len(t, online, 1)
+	assert.Equal(t, "Online", online["web\t(i-0aaaaaaaa)"].PingStatus)
+	assert.Equal(t, "3.3.40.0", online["web\t(i-0aaaaaaaa)"].AgentVersion)
+}
+
+func TestAgentMarker(t *testing.T) {
+	tests := []struct {
+		name   string
+		target *Target
+		want   string
+	}{
+		{name: "healthy", target: &Target{PingStatus: "Online", AgentVersion: "3.3.40.0", IsLatestVersion: true}, want: ""},
+		{name: "unknown", target: &Target{}, want: ""},
+		{name: "connection lost", target: &Target{PingStatus: "ConnectionLost", AgentVersion: "3.3.40.0", IsLatestVersion: true}, want: "\t[ConnectionLost]"},
+		{name: "outdated", target: &Target{PingStatus: "Online", AgentVersion: "2.3.0.0"}, want: "\t[agent outdated]"},
+		{name: "both", target: &Target{PingStatus: "Inactive", AgentVersion: "2.3.0.0"}, want: "\t[Inactive, agent outdated]"},
+		{name: "stopped", target: &Target{State: "stopped", PingStatus: "ConnectionLost", AgentVersion: "3.3.40.0", IsLatestVersion: true}, want: "\t[stopped]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, agentMarker(tt.target))
+		})
+	}
+}
+
+func TestFindInstances_IncludeStopped_ReturnsStoppedInstances(t *testing.T) {
+	ssmClient, ec2Client := newFleetClients(
+		fakeFleet{id: "i-0aaaaaaaa", tags: map[string]string{"Name": "web"}},
+		fakeFleet{id: "i-0bbbbbbbb", tags: map[string]string{"Name": "dev"}, ping: ssm_types.PingStatusConnectionLost, state: ec2_types.InstanceStateNameStopped},
+	)
+
+	running, err := FindInstances(context.Background(), ssmClient, ec2Client, FindOptions{OnlineOnly: true})
+	require.NoError(t, err)
+	withStopped, err := FindInstances(context.Background(), ssmClient, ec2Client, FindOptions{OnlineOnly: true, IncludeStopped: true})
+	require.NoError(t, err)
+
+	assert.Len(t, running, 1)
+	require.Len(t, withStopped, 2)
+	assert.Equal(t, "stopped", withStopped["dev\t(i-0bbbbbbbb)\t[stopped]"].State)
+}
+
+func TestNewEC2Target_CopiesInstanceMetadata(t *testing.T) {
+	launched := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	inst := ec2_types.Instance{
+		InstanceId:         aws.String("i-0aaaaaaaa"),
+		Tags:               []ec2_types.Tag{{Key: aws.String("Name"), Value: aws.String("web")}},
+		State:              &ec2_types.InstanceState{Name: ec2_types.InstanceStateNameRunning},
+		PrivateIpAddress:   aws.String("10.0.0.1"),
+		PublicIpAddress:    aws.String("1.2.3.4"),
+		InstanceType:       ec2_types.InstanceTypeT3Micro,
+		Placement:          &ec2_types.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		VpcId:              aws.String("vpc-0aaa"),
+		SubnetId:           aws.String("subnet-0aaa"),
+		LaunchTime:         aws.Time(launched),
+		IamInstanceProfile: &ec2_types.IamInstanceProfile{Arn: aws.String("arn:aws:iam::123456789012:instance-profile/web-profile")},
+		PlatformDetails:    aws.String("Linux/UNIX"),
+		Architecture:       ec2_types.ArchitectureValuesArm64,
+	}
+
+	target := newEC2Target(inst)
+
+	assert.Equal(t, &Target{
+		Name:               "i-0aaaaaaaa",
+		TagName:            "web",
+		Tags:               map[string]string{},
+		State:              "running",
+		PrivateIP:          "10.0.0.1",
+		PublicIP:           "1.2.3.4",
+		InstanceType:       "t3.micro",
+		AvailabilityZone:   "us-east-1a",
+		VpcID:              "vpc-0aaa",
+		SubnetID:           "subnet-0aaa",
+		LaunchTime:         launched,
+		IAMInstanceProfile: "web-profile",
+		PlatformDetails:    "Linux/UNIX",
+		Architecture:       "arm64",
+	}, target)
+}
+
+func TestNewEC2Target_MissingMetadata_LeavesFieldsEmpty(t *testing.T) {
+	target := newEC2Target(ec2_types.Instance{InstanceId: aws.String("i-0aaaaaaaa")})
+
+	assert.Equal(t, "i-0aaaaaaaa", target.Name)
+	assert.Empty(t, target.AvailabilityZone)
+	assert.Empty(t, target.IAMInstanceProfile)
+	assert.True(t, target.LaunchTime.IsZero())
+}
+
+// recordingSendCommand records SendCommand inputs and returns sequential command IDs.
+func recordingSendCommand(inputs *[]*ssm.SendCommandInput, failAt int) *mockSSMCommandAPI {
+	return &mockSSMCommandAPI{
+		sendCommandFunc: func(ctx context.Context, params *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error) {
+			*inputs = append(*inputs, params)
+			if len(*inputs) == failAt {
+				return nil, assert.AnError
+			}
+			return &ssm.SendCommandOutput{Command: &ssm_types.Command{CommandId: aws.String(fmt.Sprintf("cmd-%d", len(*inputs)))}}, nil
+		},
+	}
+}
+
+func manyTargets(n int) []*Target {
+	targets := make([]*Target, 0, n)
+	for i := 0; i < n; i++ {
+		targets = append(targets, &Target{Name: fmt.Sprintf("i-%08d", i)})
+	}
+	return targets
+}
+
+func TestSendCommand_ManyTargets_SplitsIntoBatches(t *testing.T) {
+	var inputs []*ssm.SendCommandInput
+
+	batches, err := SendCommand(context.Background(), recordingSendCommand(&inputs, 0), manyTargets(120), ShellScriptDocument, ShellScriptParameters("uptime"), SendCommandOptions{})
+
+	require.NoError(t, err)
+	require.Len(t, inputs, 3)
+	assert.Len(t, inputs[0].InstanceIds, 50)
+	assert.Len(t, inputs[2].InstanceIds, 20)
+	assert.Empty(t, inputs[0].Targets)
+	assert.Equal(t, []string{"cmd-1", "cmd-2", "cmd-3"}, []string{batches[0].CommandID, batches[1].CommandID, batches[2].CommandID})
+	assert.Equal(t, "i-00000119", batches[2].InstanceIDs[19])
+	assert.Len(t, InvocationInputs(batches), 120)
+}
+
+func TestSendCommand_TagSelector_SendsByTargets(t *testing.T) {
+	var inputs []*ssm.SendCommandInput
+	sel := &Selector{Tags: map[string]string{"Role": "web", "Env": "prod"}}
+	client := recordingSendCommand(&inputs, 0)
+	client.listCommandInvocationsFunc = listedAs(ssm_types.CommandInvocationStatusPending, targetIDs(manyTargets(80))...)
+
+	batches, err := SendCommand(context.Background(), client, manyTargets(80), ShellScriptDocument, ShellScriptParameters("uptime"), SendCommandOptions{Selector: sel})
+
+	require.NoError(t, err)
+	require.Len(t, inputs, 1)
+	assert.Empty(t, inputs[0].InstanceIds)
+	assert.Equal(t, []ssm_types.Target{
+		{Key: aws.String("tag:Env"), Values: []string{"prod"}},
+		{Key: aws.String("tag:Role"), Values: []string{"web"}},
+	}, inputs[0].Targets)
+	require.Len(t, batches, 1)
+	assert.Len(t, batches[0].InstanceIDs, 80)
+	assert.Equal(t, sel, batches[0].Selector)
+}
+
+func TestSendCommand_TagSelector_WatchesInstancesThatMatchedLater(t *testing.T) {
+	var inputs []*ssm.SendCommandInput
+	client := recordingSendCommand(&inputs, 0)
+	client.listCommandInvocationsFunc = listedAs(ssm_types.CommandInvocationStatusPending, "i-0cccccccc", "i-0aaaaaaaa", "i-0bbbbbbbb")
+	targets := []*Target{{Name: "i-0bbbbbbbb"}, {Name: "i-0aaaaaaaa"}}
+
+	batches, err := SendCommand(context.Background(), client, targets, ShellScriptDocument, ShellScriptParameters("uptime"), SendCommandOptions{Selector: &Selector{Tags: map[string]string{"Role": "web"}}})
+
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, []string{"i-0bbbbbbbb", "i-0aaaaaaaa", "i-0cccccccc"}, batches[0].InstanceIDs)
+}
+
+func TestSendCommand_TagSelector_NotListedYet_WatchesResolvedInstances(t *testing.T) {
+	var inputs []*ssm.SendCommandInput
+	client := recordingSendCommand(&inputs, 0)
+	client.listCommandInvocationsFunc = listedAs(ssm_types.CommandInvocationStatusPending)
+	clk := &fakeClock{}
+
+	batches, err := SendCommand(context.Background(), client, manyTargets(2), ShellScriptDocument, ShellScriptParameters("uptime"), SendCommandOptions{Selector: &Selector{Tags: map[string]string{"Role": "web"}}, clock: clk})
+
+	require.NoError(t, err)
+	assert.Equal(t, targetIDs(manyTargets(2)), batches[0].InstanceIDs)
+	assert.Len(t, clk.waits(), targetLookupRetries)
+}
+
+func TestSendCommand_LaterBatchFails_ReturnsSentBatches(t *testing.T) {
+	var inputs []*ssm.SendCommandInput
+
+	batches, err := SendCommand(context.Background(), recordingSendCommand(&inputs, 2), manyTargets(120), ShellScriptDocument, ShellScriptParameters("uptime"), SendCommandOptions{})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sent 1 of 3 batches")
+	require.Len(t, batches, 1)
+	assert.Equal(t, "cmd-1", batches[0].CommandID)
+}
+
+func TestCommandTargets(t *testing.T) {
+	tests := []struct {
+		name string
+		sel  *Selector
+		want bool
+	}{
+		{name: "nil", sel: nil},
+		{name: "tags", sel: &Selector{Tags: map[string]string{"Role": "web"}}, want: true},
+		{name: "with field", sel: &Selector{Tags: map[string]string{"Role": "web"}, Fields: map[string]string{"az": "us-east-1a"}}},
+		{name: "asg", sel: &Selector{ASG: "web-asg"}},
+		{name: "too many tags", sel: &Selector{Tags: map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5", "f": "6"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, commandTargets(tt.sel) != nil)
+		})
+	}
+}
+
+func TestPrintCommandInvocation_InvocationNotYetCreated_Retries(t *testing.T) {
+	calls := 0
+	mock := &mockSSMCommandAPI{
+		getCommandInvocationFunc: func(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+			calls++
+			if calls == 1 {
+				return nil, &ssm_types.InvocationDoesNotExist{}
+			}
+			return &ssm.GetCommandInvocationOutput{Status: ssm_types.CommandInvocationStatusSuccess}, nil
+		},
+		listCommandInvocationsFunc: listedAs(ssm_types.CommandInvocationStatusSuccess, "i-0aaaaaaaa"),
+	}
+
+	PrintCommandInvocation(context.Background(), mock, []*ssm.GetCommandInvocationInput{
+		{CommandId: aws.String("cmd-1"), InstanceId: aws.String("i-0aaaaaaaa")},
+	}, WatchOptions{})
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestSendCommandOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    SendCommandOptions
+		wantErr bool
+	}{
+		{name: "defaults", opts: SendCommandOptions{}},
+		{name: "counts", opts: SendCommandOptions{MaxConcurrency: "10", MaxErrors: "0"}},
+		{name: "percentages", opts: SendCommandOptions{MaxConcurrency: "25%", MaxErrors: "100%"}},
+		{name: "zero concurrency", opts: SendCommandOptions{MaxConcurrency: "0"}, wantErr: true},
+		{name: "concurrency over 100%", opts: SendCommandOptions{MaxConcurrency: "150%"}, wantErr: true},
+		{name: "negative errors", opts: SendCommandOptions{MaxErrors: "-1"}, wantErr: true},
+		{name: "not a number", opts: SendCommandOptions{MaxErrors: "some"}, wantErr: true},
+		{name: "timeouts", opts: SendCommandOptions{DeliveryTimeout: 10 * time.Minute, ExecutionTimeout: 2 * time.Hour}},
+		{name: "delivery timeout too short", opts: SendCommandOptions{DeliveryTimeout: 10 * time.Second}, wantErr: true},
+		{name: "execution timeout too long", opts: SendCommandOptions{ExecutionTimeout: 72 * time.Hour}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSendCommand_Limits_SetOnEveryBatch(t *testing.T) {
+	var inputs []*ssm.SendCommandInput
+	opts := SendCommandOptions{MaxConcurrency: "10%", MaxErrors: "2"}
+
+	_, err := SendCommand(context.Background(), recordingSendCommand(&inputs, 0), manyTargets(60), ShellScriptDocument, ShellScriptParameters("uptime"), opts)
+
+	require.NoError(t, err)
+	require.Len(t, inputs, 2)
+	for _, input := range inputs {
+		assert.Equal(t, "10%", aws.ToString(input.MaxConcurrency))
+		assert.Equal(t, "2", aws.ToString(input.MaxErrors))
+	}
+}
+
+func TestSendCommand_InvalidLimits_SendsNothing(t *testing.T) {
+	var inputs []*ssm.SendCommandInput
+
+	_, err := SendCommand(context.Background(), recordingSendCommand(&inputs, 0), manyTargets(1), ShellScriptDocument, ShellScriptParameters("uptime"), SendCommandOptions{MaxErrors: "x"})
+
+	assert.Error(t, err)
+	assert.Empty(t, inputs)
+}
+
+func TestSkippedReason(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  ssm_types.CommandInvocationStatus
+		details string
+		want    string
+	}{
+		{name: "error threshold", status: ssm_types.CommandInvocationStatusCancelled, details: "Cancelled", want: "cancelled"},
+		{name: "unreachable", status: ssm_types.CommandInvocationStatusFailed, details: "Undeliverable", want: "undeliverable"},
+		{name: "failed", status: ssm_types.CommandInvocationStatusFailed, details: "Failed"},
+		{name: "timed out", status: ssm_types.CommandInvocationStatusTimedOut, details: "Execution Timed Out"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := skippedReason(&ssm.GetCommandInvocationOutput{Status: tt.status, StatusDetails: aws.String(tt.details)})
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewSendCommandInput_Timeouts(t *testing.T) {
+	defaults := newSendCommandInput(ShellScriptDocument, ShellScriptParameters("uptime"), SendCommandOptions{})
+	custom := newSendCommandInput(ShellScriptDocument, ShellScriptParameters("uptime"), SendCommandOptions{DeliveryTimeout: 5 * time.Minute, ExecutionTimeout: 90 * time.Minute})
+
+	assert.Equal(t, int32(60), aws.ToInt32(defaults.TimeoutSeconds))
+	assert.NotContains(t, defaults.Parameters, "executionTimeout")
+	assert.Equal(t, int32(300), aws.ToInt32(custom.TimeoutSeconds))
+	assert.Equal(t, []string{"5400"}, custom.Parameters["executionTimeout"])
+}
+
+func TestNewSendCommandInput_Comment(t *testing.T) {
+	defaults := newSendCommandInput(ShellScriptDocument, ShellScriptParameters("uptime"), SendCommandOptions{})
+	custom := newSendCommandInput(ShellScriptDocument, ShellScriptParameters("uptime"), SendCommandOptions{Comment: "gossm arn:aws:iam::123456789012:user/alice"})
+
+	assert.Nil(t, defaults.Comment)
+	assert.Equal(t, "gossm arn:aws:iam::123456789012:user/alice", aws.ToString(custom.Comment))
+}
+
+func TestPrintCommandInvocation_ContextDeadline_StopsWaiting(t *testing.T) {
+	mock := &mockSSMCommandAPI{
+		getCommandInvocationFunc: func(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+			return &ssm.GetCommandInvocationOutput{Status: ssm_types.CommandInvocationStatusInProgress}, nil
+		},
+		listCommandInvocationsFunc: listedAs(ssm_types.CommandInvocationStatusInProgress, "i-0aaaaaaaa"),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+
+	results := PrintCommandInvocation(ctx, mock, []*ssm.GetCommandInvocationInput{
+		{CommandId: aws.String("cmd-1"), InstanceId: aws.String("i-0aaaaaaaa")},
+	}, WatchOptions{})
+
+	assert.Less(t, time.Since(start), 3*time.Second)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].TimedOut())
+}
+
+func TestPrintCommandInvocation_ReturnsResults(t *testing.T) {
+	mock := &mockSSMCommandAPI{
+		getCommandInvocationFunc: func(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+			switch aws.ToString(params.InstanceId) {
+			case "i-0aaaaaaaa":
+				return &ssm.GetCommandInvocationOutput{Status: ssm_types.CommandInvocationStatusSuccess}, nil
+			case "i-0bbbbbbbb":
+				return &ssm.GetCommandInvocationOutput{Status: ssm_types.CommandInvocationStatusFailed, StatusDetails: aws.String("Failed"), ResponseCode: 7}, nil
+			default:
+				return nil, assert.AnError
+			}
+		},
+		listCommandInvocationsFunc: func(ctx context.Context, params *ssm.ListCommandInvocationsInput, optFns ...func(*ssm.Options)) (*ssm.ListCommandInvocationsOutput, error) {
+			return &ssm.ListCommandInvocationsOutput{CommandInvocations: []ssm_types.CommandInvocation{
+				{InstanceId: aws.String("i-0aaaaaaaa"), Status: ssm_types.CommandInvocationStatusSuccess},
+				{InstanceId: aws.String("i-0bbbbbbbb"), Status: ssm_types.CommandInvocationStatusFailed},
+				{InstanceId: aws.String("i-0cccccccc"), Status: ssm_types.CommandInvocationStatusFailed},
+			}}, nil
+		},
+	}
+
+	results := PrintCommandInvocation(context.Background(), mock, []*ssm.GetCommandInvocationInput{
+		{CommandId: aws.String("cmd-1"), InstanceId: aws.String("i-0aaaaaaaa")},
+		{CommandId: aws.String("cmd-1"), InstanceId: aws.String("i-0bbbbbbbb")},
+		{CommandId: aws.String("cmd-1"), InstanceId: aws.String("i-0cccccccc")},
+	}, WatchOptions{})
+
+	require.Len(t, results, 3)
+	assert.True(t, results[0].Succeeded())
+	assert.Equal(t, "cmd-1", results[0].CommandID)
+	assert.False(t, results[1].Succeeded())
+	assert.Equal(t, int32(7), results[1].ResponseCode)
+	assert.Equal(t, "Failed", results[1].StatusDetails)
+	assert.ErrorIs(t, results[2].Err, assert.AnError)
+	assert.Equal(t, int32(-1), results[2].ResponseCode)
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2_types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/fatih/color"
+)
+
+var (
+	// startPollInterval is how often instance and agent state are polled while starting instances.
+	startPollInterval = 5 * time.Second
+)
+
+// StartStoppedInstances starts the stopped instances among ids, then waits until every one
+// of them is running and its SSM agent reports Online. Instances that are already running
+// are left alone. It returns the IDs of the instances it started.
+func StartStoppedInstances(ctx context.Context, ec2Client EC2StartInstancesAPI, ssmClient SSMDescribeInstanceInfoAPI, ids []string, timeout time.Duration) ([]string, error) {
+	timer := StartTimer("StartStoppedInstances")
+	defer timer.Stop()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	states, err := describeInstanceStates(ctx, ec2Client, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var stopped, waitFor []string
+	for _, id := range ids {
+		switch state := states[id]; state {
+		case ec2_types.InstanceStateNameRunning:
+		case ec2_types.InstanceStateNamePending:
+			waitFor = append(waitFor, id)
+		case ec2_types.InstanceStateNameStopped:
+			stopped = append(stopped, id)
+			waitFor = append(waitFor, id)
+		case "":
+			return nil, fmt.Errorf("not found ec2 instance %s", id)
+		default:
+			return nil, fmt.Errorf("instance %s is %s and cannot be started now", id, state)
+		}
+	}
+	if len(waitFor) == 0 {
+		return nil, nil
+	}
+
+	if len(stopped) > 0 {
+		if _, err := ec2Client.StartInstances(ctx, &ec2.StartInstancesInput{InstanceIds: stopped}); err != nil {
+			return nil, err
+		}
+		color.Green("[start] %v", stopped)
+	}
+
+	spinner := StartSpinner(fmt.Sprintf("waiting for %d instance(s) to be running...", len(waitFor)))
+	defer spinner.Stop()
+
+	err = pollUntil(ctx, startPollInterval, func() (bool, error) {
+		states, err := describeInstanceStates(ctx, ec2Client, waitFor)
+		if err != nil {
+			return false, err
+		}
+		for _, id := range waitFor {
+			if states[id] != ec2_types.InstanceStateNameRunning {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, waitError(err, timeout, "instances to be running")
+	}
+
+	spinner.Update(fmt.Sprintf("waiting for the SSM agent on %d instance(s) to be Online...", len(waitFor)))
+	err = pollUntil(ctx, startPollInterval, func() (bool, error) {
+		infos, err := describeInstanceInformation(ctx, ssmClient, []ssm_types.InstanceInformationStringFilter{
+			{Key: aws.String("InstanceIds"), Values: waitFor},
+		})
+		if err != nil {
+			return false, err
+		}
+		for _, id := range waitFor {
+			if infos[id].PingStatus != ssm_types.PingStatusOnline {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, waitError(err, timeout, "the SSM agent to be Online")
+	}
+	return stopped, nil
+}
+
+// describeInstanceStates returns the state of each instance keyed by instance ID.
+func describeInstanceStates(ctx context.Context, client EC2DescribeInstancesAPI, ids []string) (map[string]ec2_types.InstanceStateName, error) {
+	states := make(map[string]ec2_types.InstanceStateName, len(ids))
+	input := &ec2.DescribeInstancesInput{InstanceIds: ids}
+	for {
+		output, err := client.DescribeInstances(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, rv := range output.Reservations {
+			for _, inst := range rv.Instances {
+				states[aws.ToString(inst.InstanceId)] = ec2_types.InstanceStateName(instanceState(inst))
+			}
+		}
+		if output.NextToken == nil {
+			return states, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// pollUntil calls check every interval until it reports done, fails, or ctx ends.
+func pollUntil(ctx context.Context, interval time.Duration, check func() (bool, error)) error {
+	for {
+		done, err := check()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// waitError turns a context deadline into a readable timeout error.
+func waitError(err error, timeout time.Duration, what string) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s waiting for %s", timeout, what)
+	}
+	return err
+}
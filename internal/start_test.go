@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2_types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStartEC2 simulates instances that move from pending to running one poll after being started.
+type fakeStartEC2 struct {
+	states  map[string]ec2_types.InstanceStateName
+	started []string
+}
+
+func (f *fakeStartEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	var instances []ec2_types.Instance
+	for _, id := range params.InstanceIds {
+		state, ok := f.states[id]
+		if !ok {
+			continue
+		}
+		instances = append(instances, ec2_types.Instance{
+			InstanceId: aws.String(id),
+			State:      &ec2_types.InstanceState{Name: state},
+		})
+		if state == ec2_types.InstanceStateNamePending {
+			f.states[id] = ec2_types.InstanceStateNameRunning
+		}
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []ec2_types.Reservation{{Instances: instances}}}, nil
+}
+
+func (f *fakeStartEC2) StartInstances(ctx context.Context, params *ec2.StartInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error) {
+	for _, id := range params.InstanceIds {
+		f.states[id] = ec2_types.InstanceStateNamePending
+	}
+	f.started = append(f.started, params.InstanceIds...)
+	return &ec2.StartInstancesOutput{}, nil
+}
+
+// onlineAfterRunning reports the agent Online only for instances the fake EC2 shows as running.
+func onlineAfterRunning(ec2Client *fakeStartEC2) *mockSSMDescribeInstanceInfoAPI {
+	return &mockSSMDescribeInstanceInfoAPI{
+		describeInstanceInformationFunc: func(ctx context.Context, params *ssm.DescribeInstanceInformationInput, optFns ...func(*ssm.Options)) (*ssm.DescribeInstanceInformationOutput, error) {
+			output := &ssm.DescribeInstanceInformationOutput{}
+			for id, state := range ec2Client.states {
+				ping := ssm_types.PingStatusConnectionLost
+				if state == ec2_types.InstanceStateNameRunning {
+					ping = ssm_types.PingStatusOnline
+				}
+				output.InstanceInformationList = append(output.InstanceInformationList, ssm_types.InstanceInformation{
+					InstanceId: aws.String(id),
+					PingStatus: ping,
+				})
+			}
+			return output, nil
+		},
+	}
+}
+
+func withFastStartPolling(t *testing.T) {
+	t.Helper()
+	orig := startPollInterval
+	startPollInterval = time.Millisecond
+	t.Cleanup(func() { startPollInterval = orig })
+}
+
+func TestStartStoppedInstances_StoppedInstance_StartsAndWaits(t *testing.T) {
+	withFastStartPolling(t)
+	ec2Client := &fakeStartEC2{states: map[string]ec2_types.InstanceStateName{
+		"i-0aaaaaaaa": ec2_types.InstanceStateNameStopped,
+		"i-0bbbbbbbb": ec2_types.InstanceStateNameRunning,
+	}}
+
+	started, err := StartStoppedInstances(context.Background(), ec2Client, onlineAfterRunning(ec2Client),
+		[]string{"i-0aaaaaaaa", "i-0bbbbbbbb"}, time.Second)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"i-0aaaaaaaa"}, started)
+	assert.Equal(t, ec2_types.InstanceStateNameRunning, ec2Client.states["i-0aaaaaaaa"])
+}
+
+func TestStartStoppedInstances_AllRunning_DoesNothing(t *testing.T) {
+	withFastStartPolling(t)
+	ec2Client := &fakeStartEC2{states: map[string]ec2_types.InstanceStateName{
+		"i-0aaaaaaaa": ec2_types.InstanceStateNameRunning,
+	}}
+
+	started, err := StartStoppedInstances(context.Background(), ec2Client, onlineAfterRunning(ec2Client),
+		[]string{"i-0aaaaaaaa"}, time.Second)
+
+	require.NoError(t, err)
+	assert.Empty(t, started)
+	assert.Empty(t, ec2Client.started)
+}
+
+func TestStartStoppedInstances_AgentNeverOnline_TimesOut(t *testing.T) {
+	withFastStartPolling(t)
+	ec2Client := &fakeStartEC2{states: map[string]ec2_types.InstanceStateName{
+		"i-0aaaaaaaa": ec2_types.InstanceStateNameStopped,
+	}}
+	ssmClient := newAgentInfoClient(ssm_types.InstanceInformation{
+		InstanceId: aws.String("i-0aaaaaaaa"),
+		PingStatus: ssm_types.PingStatusConnectionLost,
+	})
+
+	_, err := StartStoppedInstances(context.Background(), ec2Client, ssmClient, []string{"i-0aaaaaaaa"}, 50*time.Millisecond)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out")
+}
+
+func TestStartStoppedInstances_UnstartableState_ReturnsError(t *testing.T) {
+	tests := []struct {
+		name   string
+		states map[string]ec2_types.InstanceStateName
+	}{
+		{name: "stopping", states: map[string]ec2_types.InstanceStateName{"i-0aaaaaaaa": ec2_types.InstanceStateNameStopping}},
+		{name: "terminated", states: map[string]ec2_types.InstanceStateName{"i-0aaaaaaaa": ec2_types.InstanceStateNameTerminated}},
+		{name: "not found", states: map[string]ec2_types.InstanceStateName{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ec2Client := &fakeStartEC2{states: tt.states}
+
+			_, err := StartStoppedInstances(context.Background(), ec2Client, onlineAfterRunning(ec2Client),
+				[]string{"i-0aaaaaaaa"}, time.Second)
+
+			assert.Error(t, err)
+			assert.Empty(t, ec2Client.started)
+		})
+	}
+}